@@ -0,0 +1,118 @@
+// Fetch the default.pgo of a target from the profiler server, e.g. in the CI pipelines before go build
+//
+//	go run github.com/xyctruth/profiler/pgo -server http://profiler:8080 -target checkout -o ./cmd/checkout/default.pgo
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/xyctruth/profiler/pkg/storage"
+)
+
+// labelFlags Repeatable key=value flags
+type labelFlags []storage.Label
+
+func (l *labelFlags) String() string {
+	labels := make([]string, 0, len(*l))
+	for _, label := range *l {
+		labels = append(labels, label.Key+"="+label.Value)
+	}
+	return strings.Join(labels, ",")
+}
+
+func (l *labelFlags) Set(value string) error {
+	i := strings.Index(value, "=")
+	if i <= 0 {
+		return fmt.Errorf("label %s must be key=value", value)
+	}
+	*l = append(*l, storage.Label{Key: value[:i], Value: value[i+1:]})
+	return nil
+}
+
+func main() {
+	var (
+		server    string
+		target    string
+		timeRange string
+		output    string
+		timeout   time.Duration
+		labels    labelFlags
+	)
+	flag.StringVar(&server, "server", "http://localhost:8080", "Profiler server address")
+	flag.StringVar(&target, "target", "", "Target name of the cpu profiles")
+	flag.StringVar(&timeRange, "range", "7d", "Time range of the merged cpu profiles, e.g. 7d or 12h")
+	flag.Var(&labels, "label", "The profiles must have the label key=value, can be repeated")
+	flag.StringVar(&output, "o", "default.pgo", "Output file")
+	flag.DurationVar(&timeout, "timeout", 5*time.Minute, "Request timeout")
+	flag.Parse()
+
+	if target == "" {
+		fmt.Fprintln(os.Stderr, "target is empty")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	count, err := fetch(server, target, timeRange, labels, output, timeout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s written, merged from %s cpu profiles of %s\n", output, count, target)
+}
+
+// fetch Download the default.pgo to output, returns the number of the merged profiles
+func fetch(server, target, timeRange string, labels []storage.Label, output string, timeout time.Duration) (string, error) {
+	query := url.Values{}
+	query.Set("range", timeRange)
+	for _, label := range labels {
+		b, err := json.Marshal(label)
+		if err != nil {
+			return "", err
+		}
+		query.Add("labels[]", string(b))
+	}
+	u := fmt.Sprintf("%s/api/pgo/%s?%s", strings.TrimSuffix(server, "/"), url.PathEscape(target), query.Encode())
+
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get(u)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return "", fmt.Errorf("fetch %s: %s: %s", u, resp.Status, body)
+	}
+
+	// written to a temporary file first, the existing output is kept if the download fails
+	tmp, err := ioutil.TempFile(filepath.Dir(output), ".default.pgo-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err = tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err = tmp.Close(); err != nil {
+		return "", err
+	}
+	if err = os.Rename(tmp.Name(), output); err != nil {
+		return "", err
+	}
+	return resp.Header.Get("X-Profile-Count"), nil
+}
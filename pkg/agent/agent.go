@@ -0,0 +1,385 @@
+// Package agent Capture the profiles in-process and push them to the profiler server,
+// for the services that can not expose the pprof endpoints
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/felixge/fgprof"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	retryBaseBackoff = time.Second
+	retryMaxBackoff  = time.Minute
+	stopFlushTimeout = 10 * time.Second
+)
+
+// upload A captured profile waiting to be pushed
+type upload struct {
+	profileType string
+	labels      map[string]string
+	data        []byte
+}
+
+// Agent Capture the profiles periodically and push them to the server
+type Agent struct {
+	opt Options
+	log *log.Entry
+
+	mu sync.Mutex
+	// buffer The profiles waiting to be pushed, oldest first
+	buffer     []*upload
+	bufferSize int
+	// pending Notify the push loop of the new profiles
+	pending chan struct{}
+
+	// resetMutex and resetBlock The mutex fraction and the block rate are set by the agent and reset by Stop
+	resetMutex bool
+	resetBlock bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// Start Validate the options and start capturing
+func Start(opt Options) (*Agent, error) {
+	if opt.ServerAddress == "" {
+		return nil, errors.New("server address is empty")
+	}
+	if opt.AppName == "" {
+		return nil, errors.New("app name is empty")
+	}
+	if opt.Interval <= 0 {
+		return nil, errors.New("interval must be greater than 0")
+	}
+	// the durations are shortened to 0 by a zero budget
+	if opt.CPUBudget <= 0 || opt.CPUBudget > 1 {
+		return nil, errors.New("cpu budget must be in (0, 1]")
+	}
+	if opt.Client == nil {
+		opt.Client = http.DefaultClient
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	a := &Agent{
+		opt:     opt,
+		log:     log.WithFields(log.Fields{"agent": opt.AppName, "instance": opt.Instance}),
+		pending: make(chan struct{}, 1),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+
+	// the rates set by the application are kept
+	for _, profileType := range opt.ProfileTypes {
+		switch profileType {
+		case ProfileMutex:
+			if runtime.SetMutexProfileFraction(-1) == 0 && opt.MutexProfileFraction > 0 {
+				runtime.SetMutexProfileFraction(opt.MutexProfileFraction)
+				a.resetMutex = true
+			}
+		case ProfileBlock:
+			// the block rate can not be read, it is unset if no blocking event is recorded
+			if n, _ := runtime.BlockProfile(nil); n == 0 && opt.BlockProfileRate > 0 {
+				runtime.SetBlockProfileRate(opt.BlockProfileRate)
+				a.resetBlock = true
+			}
+		}
+	}
+
+	a.wg.Add(2)
+	go a.captureLoop()
+	go a.pushLoop()
+	return a, nil
+}
+
+// Stop Stop capturing, the buffered profiles are pushed once more
+func (a *Agent) Stop() {
+	a.cancel()
+	a.wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), stopFlushTimeout)
+	defer cancel()
+	if err := a.flush(ctx); err != nil {
+		a.log.WithError(err).Error("push profile error, the buffered profiles are dropped")
+	}
+	if a.resetMutex {
+		runtime.SetMutexProfileFraction(0)
+	}
+	if a.resetBlock {
+		runtime.SetBlockProfileRate(0)
+	}
+	a.log.Info("agent exit")
+}
+
+func (a *Agent) captureLoop() {
+	defer a.wg.Done()
+
+	ticker := time.NewTicker(a.opt.Interval)
+	defer ticker.Stop()
+	for {
+		a.capture()
+		select {
+		case <-a.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// capture Capture each profile type once, the duration profiles are captured one by one within the cpu budget
+func (a *Agent) capture() {
+	cpuDuration, traceDuration := a.durations()
+
+	labels := make(map[string]string, len(a.opt.Labels))
+	for k, v := range a.opt.Labels {
+		labels[k] = v
+	}
+	if a.opt.DynamicLabels != nil {
+		for k, v := range a.opt.DynamicLabels() {
+			labels[k] = v
+		}
+	}
+
+	for _, profileType := range a.opt.ProfileTypes {
+		if a.ctx.Err() != nil {
+			return
+		}
+
+		buf := &bytes.Buffer{}
+		var err error
+		switch profileType {
+		case ProfileCPU:
+			err = a.captureCPU(buf, cpuDuration)
+		case ProfileFgprof:
+			err = a.captureFgprof(buf, cpuDuration)
+		case ProfileTrace:
+			err = a.captureTrace(buf, traceDuration)
+		case ProfileGoroutineDump:
+			err = pprof.Lookup(ProfileGoroutine).WriteTo(buf, 2)
+		default:
+			p := pprof.Lookup(profileType)
+			if p == nil {
+				err = fmt.Errorf("unknown profile type %s", profileType)
+				break
+			}
+			err = p.WriteTo(buf, 0)
+		}
+		if err != nil {
+			a.log.WithError(err).WithField("profile_type", profileType).Error("capture profile error")
+			continue
+		}
+		a.enqueue(&upload{profileType: profileType, labels: labels, data: buf.Bytes()})
+	}
+}
+
+// durations The cpu and trace durations fit in the cpu budget of the interval
+func (a *Agent) durations() (time.Duration, time.Duration) {
+	cpuDuration, traceDuration := a.opt.CPUDuration, a.opt.TraceDuration
+	var total time.Duration
+	for _, profileType := range a.opt.ProfileTypes {
+		switch profileType {
+		case ProfileCPU, ProfileFgprof:
+			total += cpuDuration
+		case ProfileTrace:
+			total += traceDuration
+		}
+	}
+
+	budget := time.Duration(float64(a.opt.Interval) * a.opt.CPUBudget)
+	if total <= budget || total == 0 {
+		return cpuDuration, traceDuration
+	}
+	scale := float64(budget) / float64(total)
+	return time.Duration(float64(cpuDuration) * scale), time.Duration(float64(traceDuration) * scale)
+}
+
+func (a *Agent) captureCPU(w io.Writer, duration time.Duration) error {
+	if err := pprof.StartCPUProfile(w); err != nil {
+		return err
+	}
+	a.sleep(duration)
+	pprof.StopCPUProfile()
+	return nil
+}
+
+func (a *Agent) captureFgprof(w io.Writer, duration time.Duration) error {
+	stop := fgprof.Start(w, fgprof.FormatPprof)
+	a.sleep(duration)
+	return stop()
+}
+
+func (a *Agent) captureTrace(w io.Writer, duration time.Duration) error {
+	if err := trace.Start(w); err != nil {
+		return err
+	}
+	a.sleep(duration)
+	trace.Stop()
+	return nil
+}
+
+// sleep Sleep until the duration elapses or the agent stops
+func (a *Agent) sleep(duration time.Duration) {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-a.ctx.Done():
+	case <-timer.C:
+	}
+}
+
+// enqueue Buffer the profile, the oldest profiles are dropped when the buffer exceeds the max size
+func (a *Agent) enqueue(u *upload) {
+	a.mu.Lock()
+	a.buffer = append(a.buffer, u)
+	a.bufferSize += len(u.data)
+	for a.opt.MaxBufferSize > 0 && a.bufferSize > a.opt.MaxBufferSize && len(a.buffer) > 0 {
+		dropped := a.buffer[0]
+		a.buffer = a.buffer[1:]
+		a.bufferSize -= len(dropped.data)
+		a.log.WithField("profile_type", dropped.profileType).Warn("buffer is full, drop the oldest profile")
+	}
+	a.mu.Unlock()
+
+	select {
+	case a.pending <- struct{}{}:
+	default:
+	}
+}
+
+// pushLoop Push the buffered profiles, retry with backoff while the server is down
+func (a *Agent) pushLoop() {
+	defer a.wg.Done()
+
+	attempt := 0
+	// retry is not nil while waiting for the backoff
+	var retry <-chan time.Time
+	for {
+		select {
+		case <-a.ctx.Done():
+			return
+		case <-a.pending:
+			if retry != nil {
+				continue
+			}
+		case <-retry:
+			retry = nil
+		}
+
+		if err := a.flush(a.ctx); err != nil {
+			attempt++
+			wait := backoff(attempt)
+			retry = time.After(wait)
+			a.log.WithError(err).Warnf("push profile error, retry after %s", wait)
+			continue
+		}
+		attempt = 0
+	}
+}
+
+func backoff(attempt int) time.Duration {
+	d := retryBaseBackoff << (attempt - 1)
+	if d > retryMaxBackoff || d <= 0 {
+		d = retryMaxBackoff
+	}
+	return d
+}
+
+// flush Push the buffered profiles in order, stop at the first transient error
+func (a *Agent) flush(ctx context.Context) error {
+	for {
+		a.mu.Lock()
+		if len(a.buffer) == 0 {
+			a.mu.Unlock()
+			return nil
+		}
+		u := a.buffer[0]
+		a.mu.Unlock()
+
+		err := a.push(ctx, u)
+		var pe *pushError
+		if errors.As(err, &pe) && pe.transient {
+			return err
+		}
+		if err != nil {
+			a.log.WithError(err).WithField("profile_type", u.profileType).Error("profile rejected by the server")
+		}
+
+		a.mu.Lock()
+		// the buffer may be trimmed during the push
+		if len(a.buffer) > 0 && a.buffer[0] == u {
+			a.buffer = a.buffer[1:]
+			a.bufferSize -= len(u.data)
+		}
+		a.mu.Unlock()
+	}
+}
+
+// pushError The push failed, the transient errors are retried
+type pushError struct {
+	transient bool
+	err       error
+}
+
+func (e *pushError) Error() string {
+	return e.err.Error()
+}
+
+func (e *pushError) Unwrap() error {
+	return e.err
+}
+
+func (a *Agent) push(ctx context.Context, u *upload) error {
+	query := url.Values{}
+	query.Set("target", a.opt.AppName)
+	query.Set("instance", a.opt.Instance)
+	query.Set("profile_type", u.profileType)
+	keys := make([]string, 0, len(u.labels))
+	for k := range u.labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		query.Add("labels", k+"="+u.labels[k])
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST",
+		strings.TrimSuffix(a.opt.ServerAddress, "/")+"/api/ingest?"+query.Encode(), bytes.NewReader(u.data))
+	if err != nil {
+		return &pushError{err: err}
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	for k, v := range a.opt.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := a.opt.Client.Do(req)
+	if err != nil {
+		return &pushError{transient: true, err: err}
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &pushError{
+			transient: resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests,
+			err:       fmt.Errorf("push resp status code is %d", resp.StatusCode),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package agent
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"github.com/xyctruth/profiler/pkg/analysis"
+)
+
+// ingestServer Record the pushed profiles, fail the first requests
+type ingestServer struct {
+	mu       sync.Mutex
+	fail     int
+	requests int
+	profiles map[string][]string
+}
+
+func (s *ingestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	if s.requests <= s.fail {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	query := r.URL.Query()
+	data, _ := ioutil.ReadAll(r.Body)
+	if analysis.IsPProf(query.Get("profile_type")) {
+		if _, err := profile.ParseData(data); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	s.profiles[query.Get("profile_type")] = append(query["labels"], query.Get("target"), query.Get("instance"))
+}
+
+func (s *ingestServer) received(profileType string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.profiles[profileType]
+}
+
+func TestAgent(t *testing.T) {
+	s := &ingestServer{fail: 2, profiles: make(map[string][]string)}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	opt := DefaultOptions(server.URL, "batch-job").
+		WithInstance("worker-1").
+		WithLabels(map[string]string{"env": "prod", "version": "1"}).
+		WithDynamicLabels(func() map[string]string { return map[string]string{"version": "2"} }).
+		WithProfileTypes(ProfileCPU, ProfileHeap, ProfileGoroutine, ProfileGoroutineDump, ProfileMutex, ProfileBlock, ProfileTrace).
+		WithInterval(time.Second).
+		WithCPUDuration(100 * time.Millisecond).
+		WithTraceDuration(100 * time.Millisecond)
+
+	a, err := Start(opt)
+	require.NoError(t, err)
+
+	// the failed pushes are retried
+	require.Eventually(t, func() bool {
+		return len(s.received(ProfileTrace)) > 0
+	}, 10*time.Second, 50*time.Millisecond)
+	a.Stop()
+
+	for _, profileType := range opt.ProfileTypes {
+		require.Equal(t, []string{"env=prod", "version=2", "batch-job", "worker-1"}, s.received(profileType), profileType)
+	}
+
+	// the rates set by the agent are reset
+	require.Equal(t, 0, runtime.SetMutexProfileFraction(-1))
+
+	_, err = Start(DefaultOptions("", "batch-job"))
+	require.Error(t, err)
+	_, err = Start(Options{ServerAddress: server.URL, AppName: "batch-job", Interval: time.Second})
+	require.Error(t, err)
+	_, err = Start(DefaultOptions(server.URL, "batch-job").WithCPUBudget(1.5))
+	require.Error(t, err)
+}
+
+func TestAgentBuffer(t *testing.T) {
+	a := &Agent{
+		opt:     DefaultOptions("http://localhost", "batch-job").WithMaxBufferSize(10),
+		log:     log.WithField("agent", "batch-job"),
+		pending: make(chan struct{}, 1),
+	}
+	a.enqueue(&upload{profileType: ProfileHeap, data: make([]byte, 4)})
+	a.enqueue(&upload{profileType: ProfileGoroutine, data: make([]byte, 4)})
+	a.enqueue(&upload{profileType: ProfileMutex, data: make([]byte, 4)})
+	require.Equal(t, 2, len(a.buffer))
+	require.Equal(t, 8, a.bufferSize)
+	require.Equal(t, ProfileGoroutine, a.buffer[0].profileType)
+}
+
+func TestAgentDurations(t *testing.T) {
+	a := &Agent{opt: DefaultOptions("http://localhost", "batch-job").WithInterval(10*time.Second).WithCPUBudget(0.5).
+		WithProfileTypes(ProfileCPU, ProfileFgprof, ProfileTrace)}
+	cpuDuration, traceDuration := a.durations()
+	require.Equal(t, 2381*time.Millisecond, cpuDuration.Round(time.Millisecond))
+	require.Equal(t, 238*time.Millisecond, traceDuration.Round(time.Millisecond))
+
+	a.opt.Interval = time.Minute
+	cpuDuration, traceDuration = a.durations()
+	require.Equal(t, 10*time.Second, cpuDuration)
+	require.Equal(t, time.Second, traceDuration)
+}
+
+func TestAgentProfileRates(t *testing.T) {
+	opt := DefaultOptions("http://localhost", "batch-job").WithProfileTypes(ProfileMutex).WithInterval(time.Hour)
+
+	// the mutex fraction set by the application is kept
+	runtime.SetMutexProfileFraction(3)
+	defer runtime.SetMutexProfileFraction(0)
+	a, err := Start(opt)
+	require.NoError(t, err)
+	require.False(t, a.resetMutex)
+	require.Equal(t, 3, runtime.SetMutexProfileFraction(-1))
+	a.Stop()
+	require.Equal(t, 3, runtime.SetMutexProfileFraction(-1))
+
+	runtime.SetMutexProfileFraction(0)
+	a, err = Start(opt)
+	require.NoError(t, err)
+	require.True(t, a.resetMutex)
+	require.Equal(t, opt.MutexProfileFraction, runtime.SetMutexProfileFraction(-1))
+	a.Stop()
+	require.Equal(t, 0, runtime.SetMutexProfileFraction(-1))
+}
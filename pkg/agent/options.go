@@ -0,0 +1,123 @@
+package agent
+
+import (
+	"net/http"
+	"os"
+	"time"
+)
+
+// Profile types captured by the agent, the names are the profile types of the server
+const (
+	ProfileCPU       = "profile"
+	ProfileHeap      = "heap"
+	ProfileGoroutine = "goroutine"
+	ProfileMutex     = "mutex"
+	ProfileBlock     = "block"
+	ProfileFgprof    = "fgprof"
+	ProfileTrace     = "trace"
+	// ProfileGoroutineDump The goroutine dump with the states and wait times of the goroutines
+	ProfileGoroutineDump = "goroutinedump"
+)
+
+type Options struct {
+	// ServerAddress Address of the profiler server, e.g. http://profiler:8080
+	ServerAddress string
+	// AppName Target name of the profiles
+	AppName string
+	// Instance Instance of the profiles, default is the hostname
+	Instance string
+	Labels   map[string]string
+	// DynamicLabels Called before each capture, the returned labels override the static labels
+	DynamicLabels func() map[string]string
+	// Headers Added to the push requests, e.g. Authorization
+	Headers map[string]string
+
+	ProfileTypes []string
+	// Interval Capture interval of all profile types
+	Interval time.Duration
+	// CPUDuration Duration of the cpu, fgprof profiles
+	CPUDuration time.Duration
+	// TraceDuration Duration of the runtime trace
+	TraceDuration time.Duration
+	// CPUBudget Max fraction of the interval spent capturing the cpu, fgprof profiles and the trace,
+	// the durations are shortened to fit the budget, must be in (0, 1]
+	CPUBudget float64
+	// MaxBufferSize Max bytes of the profiles waiting to be pushed, the oldest profiles are dropped when exceeded
+	MaxBufferSize int
+	// MutexProfileFraction and BlockProfileRate are set when the mutex and block profile types are captured and
+	// the application has not set them, and reset by Stop. The block rate is regarded as set by the application
+	// if the block profile has records, since it can not be read
+	MutexProfileFraction int
+	BlockProfileRate     int
+
+	Client *http.Client
+}
+
+// DefaultOptions Capture cpu, heap, goroutine, mutex and block profiles every minute
+func DefaultOptions(serverAddress string, appName string) Options {
+	instance, _ := os.Hostname()
+	return Options{
+		ServerAddress:        serverAddress,
+		AppName:              appName,
+		Instance:             instance,
+		ProfileTypes:         []string{ProfileCPU, ProfileHeap, ProfileGoroutine, ProfileMutex, ProfileBlock},
+		Interval:             time.Minute,
+		CPUDuration:          10 * time.Second,
+		TraceDuration:        time.Second,
+		CPUBudget:            0.25,
+		MaxBufferSize:        16 << 20,
+		MutexProfileFraction: 5,
+		BlockProfileRate:     10000,
+		Client:               &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (opt Options) WithInstance(instance string) Options {
+	opt.Instance = instance
+	return opt
+}
+
+func (opt Options) WithLabels(labels map[string]string) Options {
+	opt.Labels = labels
+	return opt
+}
+
+func (opt Options) WithDynamicLabels(fn func() map[string]string) Options {
+	opt.DynamicLabels = fn
+	return opt
+}
+
+func (opt Options) WithHeaders(headers map[string]string) Options {
+	opt.Headers = headers
+	return opt
+}
+
+func (opt Options) WithProfileTypes(profileTypes ...string) Options {
+	opt.ProfileTypes = profileTypes
+	return opt
+}
+
+func (opt Options) WithInterval(interval time.Duration) Options {
+	opt.Interval = interval
+	return opt
+}
+
+func (opt Options) WithCPUDuration(duration time.Duration) Options {
+	opt.CPUDuration = duration
+	return opt
+}
+
+func (opt Options) WithTraceDuration(duration time.Duration) Options {
+	opt.TraceDuration = duration
+	return opt
+}
+
+func (opt Options) WithCPUBudget(budget float64) Options {
+	opt.CPUBudget = budget
+	return opt
+}
+
+func (opt Options) WithMaxBufferSize(size int) Options {
+	opt.MaxBufferSize = size
+	return opt
+}
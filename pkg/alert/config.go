@@ -0,0 +1,202 @@
+// Package alert Evaluate the regression rules over the saved profile metas and send the alerts to webhooks
+package alert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/xyctruth/profiler/pkg/storage"
+	"github.com/xyctruth/profiler/pkg/utils"
+)
+
+// The conditions of the rules
+const (
+	// ConditionIncrease The average of the window is greater than the baseline by more than threshold percent
+	ConditionIncrease = "increase"
+	// ConditionDecrease The average of the window is less than the baseline by more than threshold percent
+	ConditionDecrease = "decrease"
+	// ConditionMonotonic The values of the window never decrease and the last one is greater than the first one
+	ConditionMonotonic = "monotonic"
+)
+
+// LoadConfig watch configPath change, callback fn with the alert configuration
+func LoadConfig(configPath string, fn func(Config)) error {
+	var err error
+	var config Config
+
+	conf := viper.New()
+	conf.SetConfigFile(configPath)
+	conf.SetConfigType("yaml")
+
+	if err = conf.ReadInConfig(); err != nil {
+		return fmt.Errorf("fatal error config file: %w", err)
+	}
+
+	if err = conf.UnmarshalKey("alert", &config); err != nil {
+		return fmt.Errorf("fatal error config AlertConfig: %w", err)
+	}
+
+	err = utils.WatchFile(context.Background(), configPath, func() {
+		var newConfig Config
+		if err := conf.ReadInConfig(); err != nil {
+			log.WithError(err).Error("fatal error config file")
+			return
+		}
+		if err := conf.UnmarshalKey("alert", &newConfig); err != nil {
+			log.WithError(err).Error("fatal error config AlertConfig")
+			return
+		}
+		fn(newConfig)
+	})
+	if err != nil {
+		return fmt.Errorf("watch config file: %w", err)
+	}
+	fn(config)
+
+	return nil
+}
+
+type Config struct {
+	// Interval Evaluation interval of the rules, default 1m
+	Interval time.Duration `yaml:"interval"`
+	// Receivers key is receiver name
+	Receivers map[string]ReceiverConfig `yaml:"receivers"`
+	Rules     []Rule                    `yaml:"rules"`
+}
+
+// ReceiverConfig A webhook receiving the alerts as json
+type ReceiverConfig struct {
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+	// Timeout of the webhook requests, default 10s
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+type Rule struct {
+	Name       string `yaml:"name"`
+	SampleType string `yaml:"sampleType"`
+	// Targets Evaluate the targets only, all targets when empty
+	Targets []string `yaml:"targets"`
+	// Labels The profiles must have all the labels
+	Labels map[string]string `yaml:"labels"`
+	// Function Regex of the functions, the rule is evaluated on the sum of the values of the matched functions
+	// instead of the total values of the profiles
+	Function string `yaml:"function"`
+	// FunctionValue flat or cum (default) value of the matched functions
+	FunctionValue string `yaml:"functionValue"`
+	// Condition increase (default), decrease or monotonic
+	Condition string `yaml:"condition"`
+	// Threshold Percentage of the change of the increase and decrease conditions
+	Threshold float64 `yaml:"threshold"`
+	// Window The recent profiles compared with the baseline, or checked by the monotonic condition, default 1h
+	Window time.Duration `yaml:"window"`
+	// Offset The baseline is the window before offset, default 24h
+	Offset time.Duration `yaml:"offset"`
+	// MinProfiles Minimum profiles of the window and the baseline, default 1, and 3 for the monotonic condition
+	MinProfiles int `yaml:"minProfiles"`
+	// RepeatInterval Interval of sending a firing alert again, default 1h
+	RepeatInterval time.Duration `yaml:"repeatInterval"`
+	Receivers      []string      `yaml:"receivers"`
+}
+
+// rule A validated rule with the defaults
+type rule struct {
+	Rule
+	function *regexp.Regexp
+}
+
+func (c Config) interval() time.Duration {
+	if c.Interval <= 0 {
+		return time.Minute
+	}
+	return c.Interval
+}
+
+func (r Rule) build(receivers map[string]ReceiverConfig) (*rule, error) {
+	if r.Name == "" {
+		return nil, errors.New("name is empty")
+	}
+	if r.SampleType == "" {
+		return nil, errors.New("sampleType is empty")
+	}
+	for _, name := range r.Receivers {
+		if _, ok := receivers[name]; !ok {
+			return nil, fmt.Errorf("receiver %s not found", name)
+		}
+	}
+
+	built := &rule{Rule: r}
+	if r.Function != "" {
+		var err error
+		if built.function, err = regexp.Compile(r.Function); err != nil {
+			return nil, fmt.Errorf("function: %w", err)
+		}
+	}
+	switch r.FunctionValue {
+	case "":
+		built.FunctionValue = "cum"
+	case "flat", "cum":
+	default:
+		return nil, fmt.Errorf("functionValue must be flat or cum, got %s", r.FunctionValue)
+	}
+	switch r.Condition {
+	case "":
+		built.Condition = ConditionIncrease
+	case ConditionIncrease, ConditionDecrease, ConditionMonotonic:
+	default:
+		return nil, fmt.Errorf("unknown condition %s", r.Condition)
+	}
+	if r.Threshold < 0 {
+		return nil, errors.New("threshold must not be negative")
+	}
+	if r.Window <= 0 {
+		built.Window = time.Hour
+	}
+	if r.Offset <= 0 {
+		built.Offset = 24 * time.Hour
+	}
+	if r.MinProfiles <= 0 {
+		built.MinProfiles = 1
+		if built.Condition == ConditionMonotonic {
+			built.MinProfiles = 3
+		}
+	}
+	if r.RepeatInterval <= 0 {
+		built.RepeatInterval = time.Hour
+	}
+	return built, nil
+}
+
+// match The meta is of the targets and has all the labels
+func (r *rule) match(meta *storage.ProfileMeta) bool {
+	if len(r.Targets) > 0 {
+		found := false
+		for _, target := range r.Targets {
+			if target == meta.TargetName {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for k, v := range r.Labels {
+		found := false
+		for _, l := range meta.Labels {
+			if l.Key == k && l.Value == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
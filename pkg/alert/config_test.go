@@ -0,0 +1,109 @@
+package alert
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var alertConfigYAML = `
+collector:
+  targetConfigs:
+    profiler-server:
+      instances: ["localhost:9000"]
+alert:
+  interval: 30s
+  receivers:
+    ops:
+      url: http://localhost:9093/hook
+      timeout: 5s
+  rules:
+    - name: heap-growth
+      sampleType: heap_inuse_space
+      labels:
+        env: prod
+      threshold: 30
+      window: 1h
+      offset: 24h
+      receivers: [ops]
+    - name: goroutine-leak
+      sampleType: goroutine
+      condition: monotonic
+      window: 2h
+      repeatInterval: 6h
+      receivers: [ops]
+`
+
+func TestLoadConfig(t *testing.T) {
+	file, err := ioutil.TempFile("./", "config-*.yaml")
+	require.NoError(t, err)
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(alertConfigYAML)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	var config Config
+	err = LoadConfig(file.Name(), func(c Config) {
+		config = c
+	})
+	require.NoError(t, err)
+	require.Equal(t, 30*time.Second, config.interval())
+	require.Equal(t, ReceiverConfig{URL: "http://localhost:9093/hook", Timeout: 5 * time.Second}, config.Receivers["ops"])
+	require.Equal(t, 2, len(config.Rules))
+	require.Equal(t, Rule{
+		Name:       "heap-growth",
+		SampleType: "heap_inuse_space",
+		Labels:     map[string]string{"env": "prod"},
+		Threshold:  30,
+		Window:     time.Hour,
+		Offset:     24 * time.Hour,
+		Receivers:  []string{"ops"},
+	}, config.Rules[0])
+	require.Equal(t, ConditionMonotonic, config.Rules[1].Condition)
+	require.Equal(t, 6*time.Hour, config.Rules[1].RepeatInterval)
+
+	require.Error(t, LoadConfig("./not-found.yaml", func(Config) {}))
+}
+
+func TestBuildRule(t *testing.T) {
+	receivers := map[string]ReceiverConfig{"ops": {URL: "http://localhost:9093/hook"}}
+
+	r, err := Rule{Name: "heap-growth", SampleType: "heap_inuse_space"}.build(receivers)
+	require.NoError(t, err)
+	require.Equal(t, ConditionIncrease, r.Condition)
+	require.Equal(t, "cum", r.FunctionValue)
+	require.Equal(t, time.Hour, r.Window)
+	require.Equal(t, 24*time.Hour, r.Offset)
+	require.Equal(t, 1, r.MinProfiles)
+	require.Equal(t, time.Hour, r.RepeatInterval)
+
+	r, err = Rule{Name: "goroutine-leak", SampleType: "goroutine", Condition: ConditionMonotonic}.build(receivers)
+	require.NoError(t, err)
+	require.Equal(t, 3, r.MinProfiles)
+
+	for _, invalid := range []Rule{
+		{SampleType: "heap_inuse_space"},
+		{Name: "heap-growth"},
+		{Name: "heap-growth", SampleType: "heap_inuse_space", Receivers: []string{"dev"}},
+		{Name: "heap-growth", SampleType: "heap_inuse_space", Function: "("},
+		{Name: "heap-growth", SampleType: "heap_inuse_space", FunctionValue: "self"},
+		{Name: "heap-growth", SampleType: "heap_inuse_space", Condition: "greater"},
+		{Name: "heap-growth", SampleType: "heap_inuse_space", Threshold: -1},
+	} {
+		_, err = invalid.build(receivers)
+		require.Error(t, err)
+	}
+
+	// the invalid and duplicate rules are skipped
+	e := NewEvaluator(nil)
+	e.Load(Config{Receivers: receivers, Rules: []Rule{
+		{Name: "heap-growth", SampleType: "heap_inuse_space"},
+		{Name: "heap-growth", SampleType: "heap_alloc_space"},
+		{Name: "invalid"},
+	}})
+	require.Equal(t, 1, len(e.rules))
+	require.Equal(t, "heap_inuse_space", e.rules[0].SampleType)
+}
@@ -0,0 +1,387 @@
+package alert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xyctruth/profiler/pkg/metrics"
+	"github.com/xyctruth/profiler/pkg/storage"
+)
+
+// minMonotonicCoverage The profiles of the monotonic condition must cover the fraction of the window,
+// so that the instances started recently are not alerted
+const minMonotonicCoverage = 0.75
+
+// Alert A rule firing for the profiles of a target instance
+type Alert struct {
+	Rule       string          `json:"rule"`
+	Condition  string          `json:"condition"`
+	SampleType string          `json:"sample_type"`
+	Function   string          `json:"function,omitempty"`
+	Target     string          `json:"target"`
+	Instance   string          `json:"instance"`
+	Labels     []storage.Label `json:"labels"`
+	// Value The average value of the window, the last value for the monotonic condition
+	Value float64 `json:"value"`
+	// BaselineValue The average value of the baseline, the first value of the window for the monotonic condition
+	BaselineValue float64 `json:"baseline_value"`
+	// Change Percentage of the change from BaselineValue to Value
+	Change             float64   `json:"change"`
+	Summary            string    `json:"summary"`
+	ProfileIDs         []string  `json:"profile_ids"`
+	BaselineProfileIDs []string  `json:"baseline_profile_ids,omitempty"`
+	FiredAt            time.Time `json:"fired_at"`
+}
+
+// point The value of a profile
+type point struct {
+	profileID string
+	timestamp int64
+	value     float64
+}
+
+// Evaluator Evaluate the rules periodically and send the firing alerts to the receivers of the rules
+type Evaluator struct {
+	store storage.Store
+	mu    sync.Mutex
+	// config The last loaded configuration
+	config    Config
+	rules     []*rule
+	receivers map[string]*receiver
+	// fired The last sending time of the firing alerts by rule name, key is target/instance
+	fired map[string]map[string]time.Time
+
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewEvaluator(store storage.Store) *Evaluator {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Evaluator{
+		store:     store,
+		receivers: make(map[string]*receiver),
+		fired:     make(map[string]map[string]time.Time),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+// Load alert configuration, the invalid rules are logged and skipped
+// It can be called multiple times, the firing state of the rules is kept by rule name
+func (e *Evaluator) Load(config Config) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	receivers := make(map[string]*receiver, len(config.Receivers))
+	for name, c := range config.Receivers {
+		receivers[name] = newReceiver(name, c)
+	}
+
+	rules := make([]*rule, 0, len(config.Rules))
+	names := make(map[string]struct{}, len(config.Rules))
+	for _, r := range config.Rules {
+		if _, ok := names[r.Name]; ok {
+			log.WithField("rule", r.Name).Error("duplicate alert rule")
+			continue
+		}
+		built, err := r.build(config.Receivers)
+		if err != nil {
+			log.WithError(err).WithField("rule", r.Name).Error("invalid alert rule")
+			continue
+		}
+		names[r.Name] = struct{}{}
+		rules = append(rules, built)
+	}
+
+	for name := range e.fired {
+		if _, ok := names[name]; !ok {
+			delete(e.fired, name)
+		}
+	}
+	e.config = config
+	e.rules = rules
+	e.receivers = receivers
+}
+
+// Run Evaluate the rules every interval until Stop
+func (e *Evaluator) Run() {
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		for {
+			e.mu.Lock()
+			interval := e.config.interval()
+			e.mu.Unlock()
+
+			select {
+			case <-e.ctx.Done():
+				return
+			case <-time.After(interval):
+				e.Evaluate(time.Now())
+			}
+		}
+	}()
+}
+
+func (e *Evaluator) Stop() {
+	e.cancel()
+	e.wg.Wait()
+	log.Info("alert evaluator exit")
+}
+
+// firing An alert to send and the firing state key of it
+type firing struct {
+	rule  string
+	key   string
+	alert *Alert
+}
+
+// Evaluate Evaluate the rules at now and send the alerts firing for the first time or for longer than
+// the repeat interval, returns the sent alerts. The alerts failed to send to any receiver are not recorded
+// as fired, so that they are sent again by the next evaluation
+func (e *Evaluator) Evaluate(now time.Time) []*Alert {
+	e.mu.Lock()
+	firings := make([]firing, 0)
+	byReceiver := make(map[*receiver][]*Alert)
+	for _, r := range e.rules {
+		alerts, err := e.evaluateRule(r, now)
+		if err != nil {
+			// the firing state of the rule is kept
+			log.WithError(err).WithField("rule", r.Name).Error("evaluate alert rule error")
+			metrics.AlertEvaluationsTotal.WithLabelValues("error").Inc()
+			continue
+		}
+		metrics.AlertEvaluationsTotal.WithLabelValues("success").Inc()
+
+		// the resolved alerts are sent immediately when they fire again
+		last := e.fired[r.Name]
+		fired := make(map[string]time.Time, len(alerts))
+		for _, alert := range alerts {
+			key := alert.Target + "/" + alert.Instance
+			if t, ok := last[key]; ok && now.Sub(t) < r.RepeatInterval {
+				fired[key] = t
+				continue
+			}
+			firings = append(firings, firing{rule: r.Name, key: key, alert: alert})
+			for _, name := range r.Receivers {
+				byReceiver[e.receivers[name]] = append(byReceiver[e.receivers[name]], alert)
+			}
+		}
+		e.fired[r.Name] = fired
+	}
+	e.mu.Unlock()
+
+	failed := make(map[*Alert]struct{})
+	for receiver, alerts := range byReceiver {
+		if err := receiver.send(alerts); err != nil {
+			for _, alert := range alerts {
+				failed[alert] = struct{}{}
+			}
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	sent := make([]*Alert, 0, len(firings))
+	for _, f := range firings {
+		if _, ok := failed[f.alert]; ok {
+			continue
+		}
+		// the rule may be removed by Load while sending
+		if fired, ok := e.fired[f.rule]; ok {
+			fired[f.key] = now
+		}
+		metrics.AlertsFiredTotal.WithLabelValues(f.rule).Inc()
+		sent = append(sent, f.alert)
+	}
+	return sent
+}
+
+func (e *Evaluator) evaluateRule(r *rule, now time.Time) ([]*Alert, error) {
+	windowStart := now.Add(-r.Window)
+	start := windowStart
+	if r.Condition != ConditionMonotonic {
+		start = now.Add(-r.Offset - r.Window)
+	}
+
+	filters := make([]storage.LabelFilter, 0, len(r.Labels))
+	for k, v := range r.Labels {
+		filters = append(filters, storage.LabelFilter{Label: storage.Label{Key: k, Value: v}})
+	}
+	metaByTargets, err := e.store.ListProfileMeta(r.SampleType, start, now, filters...)
+	if err != nil {
+		return nil, err
+	}
+
+	alerts := make([]*Alert, 0)
+	for _, target := range metaByTargets {
+		metas := make([]*storage.ProfileMeta, 0, len(target.ProfileMetas))
+		for _, meta := range target.ProfileMetas {
+			if r.match(meta) {
+				metas = append(metas, meta)
+			}
+		}
+		if len(metas) == 0 {
+			continue
+		}
+
+		points, err := e.points(r, metas)
+		if err != nil {
+			return nil, err
+		}
+		var alert *Alert
+		if r.Condition == ConditionMonotonic {
+			alert = monotonic(r, points, windowStart, now)
+		} else {
+			alert = compare(r, points, windowStart, now)
+		}
+		if alert == nil {
+			continue
+		}
+		alert.Rule = r.Name
+		alert.Condition = r.Condition
+		alert.SampleType = r.SampleType
+		alert.Function = r.Function
+		alert.Target = metas[0].TargetName
+		alert.Instance = metas[0].Instance
+		alert.Labels = metas[len(metas)-1].Labels
+		alert.FiredAt = now
+		alert.Summary = summary(alert)
+		alerts = append(alerts, alert)
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].Target != alerts[j].Target {
+			return alerts[i].Target < alerts[j].Target
+		}
+		return alerts[i].Instance < alerts[j].Instance
+	})
+	return alerts, nil
+}
+
+// points The values of the metas sorted by timestamp, the values of the matched functions if the rule has a function,
+// the profiles saved without a function summary are skipped
+func (e *Evaluator) points(r *rule, metas []*storage.ProfileMeta) ([]point, error) {
+	points := make([]point, 0, len(metas))
+	for _, meta := range metas {
+		p := point{profileID: meta.ProfileID, timestamp: meta.Timestamp, value: float64(meta.Value)}
+		if r.function != nil {
+			s, err := e.store.GetFunctionSummary(meta.ProfileID)
+			if errors.Is(err, storage.ErrFunctionSummaryNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			i := s.SampleTypeIndex(r.SampleType)
+			if i < 0 {
+				continue
+			}
+			values := s.Cum[i]
+			if r.FunctionValue == "flat" {
+				values = s.Flat[i]
+			}
+			p.value = 0
+			for j, name := range s.Functions {
+				if r.function.MatchString(name) {
+					p.value += float64(values[j])
+				}
+			}
+		}
+		points = append(points, p)
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].timestamp < points[j].timestamp
+	})
+	return points, nil
+}
+
+// compare The average of the window compared with the average of the baseline
+func compare(r *rule, points []point, windowStart, now time.Time) *Alert {
+	baselineEnd := now.Add(-r.Offset)
+	baselineStart := baselineEnd.Add(-r.Window)
+
+	alert := &Alert{ProfileIDs: make([]string, 0), BaselineProfileIDs: make([]string, 0)}
+	for _, p := range points {
+		t := time.Unix(0, p.timestamp*int64(time.Millisecond))
+		if !t.Before(windowStart) && !t.After(now) {
+			alert.ProfileIDs = append(alert.ProfileIDs, p.profileID)
+			alert.Value += p.value
+		} else if !t.Before(baselineStart) && !t.After(baselineEnd) {
+			alert.BaselineProfileIDs = append(alert.BaselineProfileIDs, p.profileID)
+			alert.BaselineValue += p.value
+		}
+	}
+	if len(alert.ProfileIDs) < r.MinProfiles || len(alert.BaselineProfileIDs) < r.MinProfiles {
+		return nil
+	}
+	alert.Value /= float64(len(alert.ProfileIDs))
+	alert.BaselineValue /= float64(len(alert.BaselineProfileIDs))
+	if alert.BaselineValue <= 0 {
+		return nil
+	}
+
+	alert.Change = (alert.Value - alert.BaselineValue) / alert.BaselineValue * 100
+	if r.Condition == ConditionIncrease && alert.Change > r.Threshold {
+		return alert
+	}
+	if r.Condition == ConditionDecrease && -alert.Change > r.Threshold {
+		return alert
+	}
+	return nil
+}
+
+// monotonic The values of the window never decrease and the last one is greater than the first one
+func monotonic(r *rule, points []point, windowStart, now time.Time) *Alert {
+	window := make([]point, 0, len(points))
+	for _, p := range points {
+		t := time.Unix(0, p.timestamp*int64(time.Millisecond))
+		if !t.Before(windowStart) && !t.After(now) {
+			window = append(window, p)
+		}
+	}
+	if len(window) < r.MinProfiles || len(window) < 2 {
+		return nil
+	}
+	first, last := window[0], window[len(window)-1]
+	if time.Duration(last.timestamp-first.timestamp)*time.Millisecond < time.Duration(float64(r.Window)*minMonotonicCoverage) {
+		return nil
+	}
+	for i := 1; i < len(window); i++ {
+		if window[i].value < window[i-1].value {
+			return nil
+		}
+	}
+	if last.value <= first.value {
+		return nil
+	}
+
+	alert := &Alert{Value: last.value, BaselineValue: first.value, ProfileIDs: make([]string, 0, len(window))}
+	if first.value > 0 {
+		alert.Change = (last.value - first.value) / first.value * 100
+	}
+	for _, p := range window {
+		alert.ProfileIDs = append(alert.ProfileIDs, p.profileID)
+	}
+	return alert
+}
+
+func summary(alert *Alert) string {
+	name := alert.SampleType
+	if alert.Function != "" {
+		name = fmt.Sprintf("%s of functions %s", alert.SampleType, alert.Function)
+	}
+	switch alert.Condition {
+	case ConditionMonotonic:
+		return fmt.Sprintf("%s of %s/%s increased monotonically from %.0f to %.0f over %d profiles",
+			name, alert.Target, alert.Instance, alert.BaselineValue, alert.Value, len(alert.ProfileIDs))
+	default:
+		return fmt.Sprintf("%s of %s/%s changed %+.1f%% from the baseline %.0f to %.0f",
+			name, alert.Target, alert.Instance, alert.Change, alert.BaselineValue, alert.Value)
+	}
+}
@@ -0,0 +1,284 @@
+package alert
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xyctruth/profiler/pkg/storage"
+	"github.com/xyctruth/profiler/pkg/storage/badger"
+)
+
+// webhook A local receiver recording the posted messages
+type webhook struct {
+	mu       sync.Mutex
+	messages []WebhookMessage
+	headers  []http.Header
+}
+
+func (w *webhook) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var msg WebhookMessage
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.messages = append(w.messages, msg)
+	w.headers = append(w.headers, r.Header)
+}
+
+func (w *webhook) received() []WebhookMessage {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]WebhookMessage(nil), w.messages...)
+}
+
+var profileSeq int
+
+// saveMeta Save a profile meta of the time, returns the profile id
+func saveMeta(t *testing.T, s storage.Store, instance, sampleType string, value int64, at time.Time) string {
+	profileSeq++
+	id := fmt.Sprintf("%d", profileSeq)
+	err := s.SaveProfileMeta([]*storage.ProfileMeta{{
+		ProfileID:  id,
+		SampleType: sampleType,
+		TargetName: "server",
+		Instance:   instance,
+		Value:      value,
+		Timestamp:  at.UnixNano() / time.Millisecond.Nanoseconds(),
+		Labels:     []storage.Label{{Key: "env", Value: "prod"}},
+	}}, time.Hour)
+	require.NoError(t, err)
+	return id
+}
+
+func newTestStore(t *testing.T) (storage.Store, func()) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	s := badger.NewStore(badger.DefaultOptions(dir))
+	return s, func() {
+		s.Release()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestEvaluateIncrease(t *testing.T) {
+	s, release := newTestStore(t)
+	defer release()
+
+	hook := &webhook{}
+	server := httptest.NewServer(hook)
+	defer server.Close()
+
+	now := time.Now()
+	baseline := now.Add(-24*time.Hour - 30*time.Minute)
+	baselineIDs := []string{
+		saveMeta(t, s, "pod-0", "heap_inuse_space", 100, baseline),
+		saveMeta(t, s, "pod-0", "heap_inuse_space", 100, baseline.Add(time.Minute)),
+	}
+	ids := []string{
+		saveMeta(t, s, "pod-0", "heap_inuse_space", 150, now.Add(-20*time.Minute)),
+		saveMeta(t, s, "pod-0", "heap_inuse_space", 130, now.Add(-10*time.Minute)),
+	}
+	saveMeta(t, s, "pod-1", "heap_inuse_space", 100, baseline)
+	saveMeta(t, s, "pod-1", "heap_inuse_space", 110, now.Add(-10*time.Minute))
+	// not in the windows
+	saveMeta(t, s, "pod-1", "heap_inuse_space", 1000, now.Add(-2*time.Hour))
+
+	rule := Rule{
+		Name:       "heap-growth",
+		SampleType: "heap_inuse_space",
+		Labels:     map[string]string{"env": "prod"},
+		Threshold:  30,
+		Receivers:  []string{"ops"},
+	}
+	config := Config{
+		Receivers: map[string]ReceiverConfig{"ops": {URL: server.URL, Headers: map[string]string{"Authorization": "Bearer token"}}},
+		Rules:     []Rule{rule},
+	}
+	e := NewEvaluator(s)
+	e.Load(config)
+
+	// the index time of the metas is in seconds
+	now = time.Now().Add(time.Second)
+	alerts := e.Evaluate(now)
+	require.Equal(t, 1, len(alerts))
+	require.Equal(t, "pod-0", alerts[0].Instance)
+	require.Equal(t, float64(140), alerts[0].Value)
+	require.Equal(t, float64(100), alerts[0].BaselineValue)
+	require.InDelta(t, 40, alerts[0].Change, 0.001)
+	require.Equal(t, ids, alerts[0].ProfileIDs)
+	require.Equal(t, baselineIDs, alerts[0].BaselineProfileIDs)
+	require.Equal(t, "heap_inuse_space of server/pod-0 changed +40.0% from the baseline 100 to 140", alerts[0].Summary)
+
+	messages := hook.received()
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "ops", messages[0].Receiver)
+	require.Equal(t, "heap-growth", messages[0].Alerts[0].Rule)
+	require.Equal(t, ids, messages[0].Alerts[0].ProfileIDs)
+	require.Equal(t, "Bearer token", hook.headers[0].Get("Authorization"))
+
+	// not sent again within the repeat interval
+	require.Empty(t, e.Evaluate(now.Add(time.Second)))
+	require.Equal(t, 1, len(hook.received()))
+
+	// the firing state is kept by the reloaded rule
+	rule.RepeatInterval = time.Second
+	config.Rules = []Rule{rule}
+	e.Load(config)
+	require.Equal(t, 1, len(e.Evaluate(now.Add(2*time.Second))))
+	require.Equal(t, 2, len(hook.received()))
+
+	// the threshold is not exceeded
+	rule.Threshold = 50
+	config.Rules = []Rule{rule}
+	e.Load(config)
+	require.Empty(t, e.Evaluate(now.Add(3*time.Second)))
+
+	rule.Condition = ConditionDecrease
+	rule.Threshold = 0
+	config.Rules = []Rule{rule}
+	e.Load(config)
+	require.Empty(t, e.Evaluate(now.Add(3*time.Second)))
+}
+
+func TestEvaluateMonotonic(t *testing.T) {
+	s, release := newTestStore(t)
+	defer release()
+
+	now := time.Now()
+	for i, v := range []int64{10, 20, 20, 30} {
+		saveMeta(t, s, "pod-0", "goroutine", v, now.Add(-110*time.Minute+time.Duration(i)*30*time.Minute))
+	}
+	for i, v := range []int64{10, 20, 15, 30} {
+		saveMeta(t, s, "pod-1", "goroutine", v, now.Add(-110*time.Minute+time.Duration(i)*30*time.Minute))
+	}
+	// started recently
+	for i, v := range []int64{10, 20, 30} {
+		saveMeta(t, s, "pod-2", "goroutine", v, now.Add(-30*time.Minute+time.Duration(i)*10*time.Minute))
+	}
+
+	e := NewEvaluator(s)
+	e.Load(Config{Rules: []Rule{{
+		Name:       "goroutine-leak",
+		SampleType: "goroutine",
+		Condition:  ConditionMonotonic,
+		Window:     2 * time.Hour,
+	}}})
+	alerts := e.Evaluate(time.Now().Add(time.Second))
+	require.Equal(t, 1, len(alerts))
+	require.Equal(t, "pod-0", alerts[0].Instance)
+	require.Equal(t, float64(30), alerts[0].Value)
+	require.Equal(t, float64(10), alerts[0].BaselineValue)
+	require.Equal(t, 4, len(alerts[0].ProfileIDs))
+	require.Equal(t, "goroutine of server/pod-0 increased monotonically from 10 to 30 over 4 profiles", alerts[0].Summary)
+}
+
+func TestEvaluateFunction(t *testing.T) {
+	s, release := newTestStore(t)
+	defer release()
+
+	saveSummary := func(id string, cum int64) {
+		require.NoError(t, s.SaveFunctionSummary(id, &storage.FunctionSummary{
+			SampleTypes: []string{"profile_samples", "profile_cpu"},
+			Functions:   []string{"encoding/json.Unmarshal", "main.main"},
+			Flat:        [][]int64{{0, 0}, {50, 0}},
+			Cum:         [][]int64{{0, 0}, {cum, 1000}},
+		}, time.Hour))
+	}
+
+	now := time.Now()
+	saveSummary(saveMeta(t, s, "pod-0", "profile_cpu", 1000, now.Add(-24*time.Hour-time.Minute)), 100)
+	saveSummary(saveMeta(t, s, "pod-0", "profile_cpu", 1000, now.Add(-time.Minute)), 200)
+	// saved without a function summary
+	saveMeta(t, s, "pod-0", "profile_cpu", 1000, now.Add(-2*time.Minute))
+
+	e := NewEvaluator(s)
+	e.Load(Config{Rules: []Rule{{
+		Name:       "json-cpu",
+		SampleType: "profile_cpu",
+		Function:   `^encoding/json\.`,
+		Threshold:  50,
+	}}})
+	alerts := e.Evaluate(time.Now().Add(time.Second))
+	require.Equal(t, 1, len(alerts))
+	require.Equal(t, float64(200), alerts[0].Value)
+	require.Equal(t, 1, len(alerts[0].ProfileIDs))
+
+	e.Load(Config{Rules: []Rule{{
+		Name:          "json-cpu",
+		SampleType:    "profile_cpu",
+		Function:      `^encoding/json\.`,
+		FunctionValue: "flat",
+		Threshold:     50,
+	}}})
+	// the flat values are not changed
+	require.Empty(t, e.Evaluate(time.Now().Add(time.Second)))
+}
+
+func TestReceiverError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	r := newReceiver("ops", ReceiverConfig{URL: server.URL})
+	require.Error(t, r.post([]*Alert{{Rule: "heap-growth"}}))
+	require.Equal(t, 10*time.Second, r.client.Timeout)
+}
+
+func TestEvaluateReceiverError(t *testing.T) {
+	s, release := newTestStore(t)
+	defer release()
+
+	var failing int32 = 1
+	hook := &webhook{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		hook.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	baseline := now.Add(-24*time.Hour - 30*time.Minute)
+	saveMeta(t, s, "pod-0", "heap_inuse_space", 100, baseline)
+	saveMeta(t, s, "pod-0", "heap_inuse_space", 200, now.Add(-10*time.Minute))
+
+	e := NewEvaluator(s)
+	e.Load(Config{
+		Receivers: map[string]ReceiverConfig{"ops": {URL: server.URL}},
+		Rules: []Rule{{
+			Name:       "heap-growth",
+			SampleType: "heap_inuse_space",
+			Threshold:  30,
+			Receivers:  []string{"ops"},
+		}},
+	})
+
+	// the alert failed to send is not recorded as fired
+	now = time.Now().Add(time.Second)
+	require.Empty(t, e.Evaluate(now))
+	require.Empty(t, hook.received())
+
+	// sent by the next evaluation within the repeat interval
+	atomic.StoreInt32(&failing, 0)
+	alerts := e.Evaluate(now.Add(time.Second))
+	require.Equal(t, 1, len(alerts))
+	require.Equal(t, "pod-0", alerts[0].Instance)
+	require.Equal(t, 1, len(hook.received()))
+
+	require.Empty(t, e.Evaluate(now.Add(2*time.Second)))
+	require.Equal(t, 1, len(hook.received()))
+}
@@ -0,0 +1,72 @@
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xyctruth/profiler/pkg/metrics"
+)
+
+// WebhookMessage The json body posted to the webhook receivers
+type WebhookMessage struct {
+	Receiver string   `json:"receiver"`
+	Alerts   []*Alert `json:"alerts"`
+}
+
+type receiver struct {
+	name   string
+	config ReceiverConfig
+	client *http.Client
+}
+
+func newReceiver(name string, config ReceiverConfig) *receiver {
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return &receiver{name: name, config: config, client: &http.Client{Timeout: timeout}}
+}
+
+// send Post the alerts to the webhook, the errors are logged and returned
+func (r *receiver) send(alerts []*Alert) error {
+	if err := r.post(alerts); err != nil {
+		log.WithError(err).WithField("receiver", r.name).Error("send alerts error")
+		metrics.AlertNotificationsTotal.WithLabelValues(r.name, "failed").Inc()
+		return err
+	}
+	metrics.AlertNotificationsTotal.WithLabelValues(r.name, "sent").Inc()
+	return nil
+}
+
+func (r *receiver) post(alerts []*Alert) error {
+	body, err := json.Marshal(WebhookMessage{Receiver: r.name, Alerts: alerts})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", r.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range r.config.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook resp status code is %d", resp.StatusCode)
+	}
+	return nil
+}
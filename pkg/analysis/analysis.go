@@ -0,0 +1,210 @@
+// Package analysis Extract the metas of the profiles and save them to the store
+package analysis
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/pprof/profile"
+	log "github.com/sirupsen/logrus"
+	"github.com/xyctruth/profiler/pkg/storage"
+)
+
+// TraceProfileType The profile type saved as go trace, the others are pprof
+const TraceProfileType = "trace"
+
+// TopFunctionsLimit Number of the top functions saved for each sample type of the profiles
+const TopFunctionsLimit = 10
+
+// ErrInvalidProfile The data is not a valid pprof or go trace
+var ErrInvalidProfile = errors.New("invalid profile")
+
+// Profile A scraped or pushed profile of an instance
+type Profile struct {
+	TargetName  string
+	Instance    string
+	ProfileType string
+	Labels      []storage.Label
+	Data        []byte
+	// Time of the profile, default is the save time
+	Time time.Time
+	// Partial The profile contains a part of the sample types of the profile type,
+	// the sample types are named profileType_type even if there is only one
+	Partial bool
+	// Functions Save the function summary and the top functions of the pprof, so that the function series,
+	// the top functions and the function alert rules don't parse the profile
+	Functions bool
+}
+
+// nonPProfSavers The savers of the profile types not saved as pprof, key is profile type
+var nonPProfSavers = map[string]func(store storage.Store, p Profile, expiration time.Duration) (string, error){
+	TraceProfileType:         saveTrace,
+	GoroutineDumpProfileType: saveGoroutineDump,
+}
+
+// IsPProf Whether the data of the profile type is a pprof, the trace and the goroutine dump are not
+func IsPProf(profileType string) bool {
+	_, ok := nonPProfSavers[profileType]
+	return !ok
+}
+
+// Save Save the profile and a meta for each sample type, returns the profile id
+func Save(store storage.Store, p Profile, expiration time.Duration) (string, error) {
+	if save, ok := nonPProfSavers[p.ProfileType]; ok {
+		return save(store, p, expiration)
+	}
+	return savePProf(store, p, expiration)
+}
+
+func savePProf(store storage.Store, p Profile, expiration time.Duration) (string, error) {
+	prof, err := profile.ParseData(p.Data)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrInvalidProfile, err)
+	}
+	return saveProfile(store, p, prof, expiration, nil)
+}
+
+// saveProfile Save the parsed pprof, records saves the other records of the profile id before the metas
+func saveProfile(store storage.Store, p Profile, prof *profile.Profile, expiration time.Duration,
+	records func(profileID string) error) (string, error) {
+	if len(prof.SampleType) == 0 {
+		return "", fmt.Errorf("%w: sample type is nil", ErrInvalidProfile)
+	}
+	err := prof.CheckValid()
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrInvalidProfile, err)
+	}
+
+	// Set profile name , Display it on the Profile UI
+	if len(prof.Mapping) > 0 {
+		prof.Mapping[0].File = p.TargetName
+	}
+
+	b := &bytes.Buffer{}
+	if err = prof.Write(b); err != nil {
+		return "", err
+	}
+
+	profileID, err := store.SaveProfile(fmt.Sprintf("%s-%s", p.TargetName, p.ProfileType), b.Bytes(), expiration)
+	if err != nil {
+		return "", err
+	}
+
+	sampleTypes := SampleTypeNames(p.ProfileType, p.Partial, prof)
+	metas := make([]*storage.ProfileMeta, 0, len(prof.SampleType))
+	for i := range prof.SampleType {
+		meta := newMeta(p, profileID)
+		meta.Duration = prof.DurationNanos
+		meta.SampleTypeUnit = prof.SampleType[i].Unit
+		for _, s := range prof.Sample {
+			meta.Value += s.Value[i]
+		}
+		meta.SampleType = sampleTypes[i]
+		metas = append(metas, meta)
+	}
+
+	// Saved before the metas, so the function values of the listed profiles are queried without parsing them
+	if p.Functions {
+		summary := NewFunctionSummary(prof, sampleTypes)
+		if err = store.SaveFunctionSummary(profileID, summary, expiration); err != nil {
+			return "", err
+		}
+		if err = store.SaveTopFunctions(profileID, NewTopFunctions(prof, summary, TopFunctionsLimit), expiration); err != nil {
+			return "", err
+		}
+	}
+	if records != nil {
+		if err = records(profileID); err != nil {
+			return "", err
+		}
+	}
+
+	if err = store.SaveProfileMeta(metas, expiration); err != nil {
+		return "", err
+	}
+	return profileID, nil
+}
+
+// partialProfileTypes The profile types of the pprof endpoints with more than one sample type
+var partialProfileTypes = map[string]struct{}{
+	"profile": {}, "fgprof": {}, "heap": {}, "allocs": {}, "mutex": {}, "block": {},
+}
+
+// IsPartialProfileType Whether the sample types of the profile type are named profileType_type even if the
+// profile has one of them, the pprof endpoints of the profile type have more than one sample type
+func IsPartialProfileType(profileType string) bool {
+	_, ok := partialProfileTypes[profileType]
+	return ok
+}
+
+// SampleTypeNames The sample types of the metas of the pprof, named profileType_type if the profile has more than
+// one sample type or is partial, otherwise named as the profile type
+func SampleTypeNames(profileType string, partial bool, prof *profile.Profile) []string {
+	names := make([]string, 0, len(prof.SampleType))
+	for _, st := range prof.SampleType {
+		if len(prof.SampleType) > 1 || partial {
+			names = append(names, fmt.Sprintf("%s_%s", profileType, st.Type))
+		} else {
+			names = append(names, profileType)
+		}
+	}
+	return names
+}
+
+func saveTrace(store storage.Store, p Profile, expiration time.Duration) (string, error) {
+	if !isTrace(p.Data) {
+		return "", fmt.Errorf("%w: not a go trace", ErrInvalidProfile)
+	}
+
+	profileID, err := store.SaveProfile(fmt.Sprintf("%s-%s", p.TargetName, p.ProfileType), p.Data, expiration)
+	if err != nil {
+		return "", err
+	}
+
+	meta := newMeta(p, profileID)
+	meta.SampleType = p.ProfileType
+	metas := []*storage.ProfileMeta{meta}
+
+	// the trace is saved without the metrics if the parser doesn't support it
+	traceMetrics, duration, err := TraceMetrics(p.Data)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{"target": p.TargetName, "instance": p.Instance}).
+			Info("compute trace metrics error")
+	}
+	meta.Duration = duration.Nanoseconds()
+	for _, metric := range traceMetrics {
+		m := newMeta(p, profileID)
+		m.SampleType = fmt.Sprintf("%s_%s", p.ProfileType, metric.Name)
+		m.SampleTypeUnit = metric.Unit
+		m.Value = metric.Value
+		m.Duration = meta.Duration
+		metas = append(metas, m)
+	}
+
+	if err = store.SaveProfileMeta(metas, expiration); err != nil {
+		return "", err
+	}
+	return profileID, nil
+}
+
+func newMeta(p Profile, profileID string) *storage.ProfileMeta {
+	t := p.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	return &storage.ProfileMeta{
+		Timestamp:   t.UnixNano() / time.Millisecond.Nanoseconds(),
+		ProfileID:   profileID,
+		ProfileType: p.ProfileType,
+		TargetName:  p.TargetName,
+		Instance:    p.Instance,
+		Labels:      append([]storage.Label(nil), p.Labels...),
+	}
+}
+
+// isTrace The go trace starts with a header like "go 1.19 trace\x00\x00\x00"
+func isTrace(data []byte) bool {
+	return len(data) >= 16 && bytes.HasPrefix(data, []byte("go 1.")) && bytes.Contains(data[:16], []byte(" trace"))
+}
@@ -0,0 +1,96 @@
+package analysis
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+	"github.com/xyctruth/profiler/pkg/storage"
+	"github.com/xyctruth/profiler/pkg/storage/badger"
+)
+
+func TestSave(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	store := badger.NewStore(badger.DefaultOptions(dir))
+	defer store.Release()
+
+	profileBytes, err := ioutil.ReadFile("../apiserver/testdata/profile.out.testdata")
+	require.NoError(t, err)
+	id, err := Save(store, Profile{
+		TargetName:  "server",
+		Instance:    "localhost:9000",
+		ProfileType: "heap",
+		Labels:      []storage.Label{{Key: "env", Value: "prod"}},
+		Data:        profileBytes,
+		Functions:   true,
+	}, time.Hour)
+	require.NoError(t, err)
+
+	name, _, err := store.GetProfile(id)
+	require.NoError(t, err)
+	require.Equal(t, "server-heap", name)
+
+	sampleTypes, err := store.ListSampleType()
+	require.NoError(t, err)
+	require.Equal(t, []string{"heap_alloc_objects", "heap_alloc_space", "heap_inuse_objects", "heap_inuse_space"}, sampleTypes)
+
+	metas, err := store.ListProfileMeta("heap_inuse_space", time.Now().Add(-time.Minute), time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(metas))
+	require.Equal(t, "localhost:9000", metas[0].ProfileMetas[0].Instance)
+	require.Equal(t, "bytes", metas[0].ProfileMetas[0].SampleTypeUnit)
+	require.Contains(t, metas[0].ProfileMetas[0].Labels, storage.Label{Key: "env", Value: "prod"})
+
+	summary, err := store.GetFunctionSummary(id)
+	require.NoError(t, err)
+	require.Equal(t, sampleTypes, summary.SampleTypes)
+	require.Equal(t, len(summary.Functions), len(summary.Cum[3]))
+	require.NotEmpty(t, summary.Functions)
+
+	tops, err := store.GetTopFunctions(id)
+	require.NoError(t, err)
+	require.Equal(t, 4, len(tops))
+	require.Equal(t, "heap_inuse_space", tops[3].SampleType)
+	require.Equal(t, metas[0].ProfileMetas[0].Value, tops[3].Total)
+	require.LessOrEqual(t, len(tops[3].Cum), TopFunctionsLimit)
+
+	id, err = Save(store, Profile{TargetName: "server", ProfileType: "heap", Data: profileBytes}, time.Hour)
+	require.NoError(t, err)
+	_, err = store.GetFunctionSummary(id)
+	require.ErrorIs(t, err, storage.ErrFunctionSummaryNotFound)
+	_, err = store.GetTopFunctions(id)
+	require.ErrorIs(t, err, storage.ErrTopFunctionsNotFound)
+
+	traceBytes, err := ioutil.ReadFile("../apiserver/testdata/trace.out.testdata")
+	require.NoError(t, err)
+	_, err = Save(store, Profile{TargetName: "server", ProfileType: TraceProfileType, Data: traceBytes}, time.Hour)
+	require.NoError(t, err)
+
+	_, err = Save(store, Profile{TargetName: "server", ProfileType: "heap", Data: []byte("invalid")}, time.Hour)
+	require.ErrorIs(t, err, ErrInvalidProfile)
+	_, err = Save(store, Profile{TargetName: "server", ProfileType: TraceProfileType, Data: profileBytes}, time.Hour)
+	require.ErrorIs(t, err, ErrInvalidProfile)
+}
+
+func TestIsPProf(t *testing.T) {
+	require.True(t, IsPProf("heap"))
+	require.True(t, IsPProf("profile"))
+	require.False(t, IsPProf(TraceProfileType))
+	require.False(t, IsPProf(GoroutineDumpProfileType))
+}
+
+func TestSampleTypeNames(t *testing.T) {
+	prof := &profile.Profile{SampleType: []*profile.ValueType{{Type: "samples"}, {Type: "cpu"}}}
+	require.Equal(t, []string{"profile_samples", "profile_cpu"}, SampleTypeNames("profile", false, prof))
+
+	prof.SampleType = prof.SampleType[1:]
+	require.Equal(t, []string{"profile_cpu"}, SampleTypeNames("profile", true, prof))
+	require.Equal(t, []string{"profile"}, SampleTypeNames("profile", false, prof))
+	require.True(t, IsPartialProfileType("heap"))
+	require.False(t, IsPartialProfileType("goroutine"))
+}
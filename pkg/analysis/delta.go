@@ -0,0 +1,117 @@
+package analysis
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/pprof/profile"
+)
+
+// DeltaPrefix The prefix of the delta sample types in the delta profiles, saved as e.g. heap_delta_alloc_space
+const DeltaPrefix = "delta_"
+
+// ErrProfileReset The cumulative values decreased since the previous profile, the process restarted
+var ErrProfileReset = errors.New("cumulative profile reset")
+
+// CumulativeSampleTypes The sample types cumulative since the process start by profile type
+var CumulativeSampleTypes = map[string][]string{
+	"heap":   {"alloc_objects", "alloc_space"},
+	"allocs": {"alloc_objects", "alloc_space"},
+	"mutex":  {"contentions", "delay"},
+	"block":  {"contentions", "delay"},
+}
+
+// SelectSampleTypes A copy of the profile with the sample types for which keep returns true, nil if there is none
+func SelectSampleTypes(p *profile.Profile, keep func(sampleType string) bool) *profile.Profile {
+	indices := make([]int, 0, len(p.SampleType))
+	for i, st := range p.SampleType {
+		if keep(st.Type) {
+			indices = append(indices, i)
+		}
+	}
+	if len(indices) == 0 {
+		return nil
+	}
+
+	selected := p.Copy()
+	if len(indices) == len(p.SampleType) {
+		return selected
+	}
+	sampleTypes := make([]*profile.ValueType, 0, len(indices))
+	for _, i := range indices {
+		sampleTypes = append(sampleTypes, selected.SampleType[i])
+	}
+	selected.SampleType = sampleTypes
+	if !keep(selected.DefaultSampleType) {
+		selected.DefaultSampleType = ""
+	}
+	for _, s := range selected.Sample {
+		values := make([]int64, 0, len(indices))
+		for _, i := range indices {
+			values = append(values, s.Value[i])
+		}
+		s.Value = values
+	}
+	return selected
+}
+
+// Delta The values of cur minus prev for the profiles of the cumulative sample types, the sample types are renamed
+// with DeltaPrefix, the duration is the time between the profiles
+// ErrProfileReset if the total of a sample type decreased
+func Delta(prev, cur *profile.Profile) (*profile.Profile, error) {
+	if len(prev.SampleType) != len(cur.SampleType) {
+		return nil, fmt.Errorf("%w: sample types changed", ErrProfileReset)
+	}
+	for i := range cur.SampleType {
+		if prev.SampleType[i].Type != cur.SampleType[i].Type || prev.SampleType[i].Unit != cur.SampleType[i].Unit {
+			return nil, fmt.Errorf("%w: sample types changed", ErrProfileReset)
+		}
+		var prevTotal, curTotal int64
+		for _, s := range prev.Sample {
+			prevTotal += s.Value[i]
+		}
+		for _, s := range cur.Sample {
+			curTotal += s.Value[i]
+		}
+		if curTotal < prevTotal {
+			return nil, fmt.Errorf("%w: %s decreased from %d to %d", ErrProfileReset, cur.SampleType[i].Type, prevTotal, curTotal)
+		}
+	}
+
+	negative := prev.Copy()
+	negative.Scale(-1)
+	delta, err := profile.Merge([]*profile.Profile{cur.Copy(), negative})
+	if err != nil {
+		return nil, err
+	}
+
+	// the stacks disappeared from the cumulative profiles are ignored
+	samples := make([]*profile.Sample, 0, len(delta.Sample))
+	for _, s := range delta.Sample {
+		positive := false
+		for i, v := range s.Value {
+			if v > 0 {
+				positive = true
+			} else {
+				s.Value[i] = 0
+			}
+		}
+		if positive {
+			samples = append(samples, s)
+		}
+	}
+	delta.Sample = samples
+
+	for _, st := range delta.SampleType {
+		st.Type = DeltaPrefix + st.Type
+	}
+	if delta.DefaultSampleType != "" {
+		delta.DefaultSampleType = DeltaPrefix + delta.DefaultSampleType
+	}
+	delta.TimeNanos = cur.TimeNanos
+	delta.DurationNanos = 0
+	if prev.TimeNanos > 0 && cur.TimeNanos > prev.TimeNanos {
+		delta.DurationNanos = cur.TimeNanos - prev.TimeNanos
+	}
+	return delta, nil
+}
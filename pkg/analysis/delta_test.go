@@ -0,0 +1,86 @@
+package analysis
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+)
+
+// newHeapProfile A heap profile of the stacks main;foo and main;bar with the alloc_space and inuse_space values
+func newHeapProfile(t time.Time, foo, bar [2]int64) *profile.Profile {
+	main := &profile.Function{ID: 1, Name: "main"}
+	fooFunc := &profile.Function{ID: 2, Name: "foo"}
+	barFunc := &profile.Function{ID: 3, Name: "bar"}
+	mainLoc := &profile.Location{ID: 1, Line: []profile.Line{{Function: main}}}
+	fooLoc := &profile.Location{ID: 2, Line: []profile.Line{{Function: fooFunc}}}
+	barLoc := &profile.Location{ID: 3, Line: []profile.Line{{Function: barFunc}}}
+	return &profile.Profile{
+		SampleType:        []*profile.ValueType{{Type: "alloc_space", Unit: "bytes"}, {Type: "inuse_space", Unit: "bytes"}},
+		DefaultSampleType: "alloc_space",
+		PeriodType:        &profile.ValueType{Type: "space", Unit: "bytes"},
+		TimeNanos:         t.UnixNano(),
+		Sample: []*profile.Sample{
+			{Location: []*profile.Location{fooLoc, mainLoc}, Value: foo[:]},
+			{Location: []*profile.Location{barLoc, mainLoc}, Value: bar[:]},
+		},
+		Location: []*profile.Location{mainLoc, fooLoc, barLoc},
+		Function: []*profile.Function{main, fooFunc, barFunc},
+	}
+}
+
+func TestSelectSampleTypes(t *testing.T) {
+	p := newHeapProfile(time.Now(), [2]int64{10, 1}, [2]int64{20, 2})
+
+	alloc := SelectSampleTypes(p, func(sampleType string) bool { return sampleType == "alloc_space" })
+	require.Equal(t, 1, len(alloc.SampleType))
+	require.Equal(t, "alloc_space", alloc.DefaultSampleType)
+	require.Equal(t, []int64{10}, alloc.Sample[0].Value)
+	require.NoError(t, alloc.CheckValid())
+
+	inuse := SelectSampleTypes(p, func(sampleType string) bool { return sampleType == "inuse_space" })
+	require.Equal(t, "", inuse.DefaultSampleType)
+	require.Equal(t, []int64{2}, inuse.Sample[1].Value)
+
+	// the profile is not modified
+	require.Equal(t, []int64{10, 1}, p.Sample[0].Value)
+	require.Nil(t, SelectSampleTypes(p, func(string) bool { return false }))
+}
+
+func TestDelta(t *testing.T) {
+	now := time.Now()
+	prev := newHeapProfile(now.Add(-time.Minute), [2]int64{10, 1}, [2]int64{20, 2})
+	cur := newHeapProfile(now, [2]int64{15, 1}, [2]int64{20, 3})
+
+	delta, err := Delta(prev, cur)
+	require.NoError(t, err)
+	require.NoError(t, delta.CheckValid())
+	require.Equal(t, "delta_alloc_space", delta.SampleType[0].Type)
+	require.Equal(t, "delta_inuse_space", delta.SampleType[1].Type)
+	require.Equal(t, "delta_alloc_space", delta.DefaultSampleType)
+	require.Equal(t, now.UnixNano(), delta.TimeNanos)
+	require.Equal(t, time.Minute.Nanoseconds(), delta.DurationNanos)
+	// bar has no alloc_space delta, the inuse_space delta is kept
+	require.Equal(t, 2, len(delta.Sample))
+	functions := Functions(delta, 0)
+	require.Equal(t, int64(5), functions["foo"].Flat)
+	require.Nil(t, functions["bar"])
+	require.Equal(t, int64(1), Functions(delta, 1)["bar"].Flat)
+	// the profiles are not modified
+	require.Equal(t, []int64{10, 1}, prev.Sample[0].Value)
+	require.Equal(t, "alloc_space", cur.SampleType[0].Type)
+
+	// unchanged
+	delta, err = Delta(cur, cur)
+	require.NoError(t, err)
+	require.Empty(t, delta.Sample)
+
+	// restarted
+	_, err = Delta(cur, prev)
+	require.True(t, errors.Is(err, ErrProfileReset))
+	alloc := SelectSampleTypes(cur, func(sampleType string) bool { return sampleType == "alloc_space" })
+	_, err = Delta(prev, alloc)
+	require.True(t, errors.Is(err, ErrProfileReset))
+}
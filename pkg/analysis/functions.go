@@ -0,0 +1,141 @@
+package analysis
+
+import (
+	"math"
+	"sort"
+
+	"github.com/google/pprof/profile"
+	"github.com/xyctruth/profiler/pkg/storage"
+)
+
+// FunctionValue The flat and cum values of a function, flat is the value of the samples where the function is the leaf,
+// cum is the value of the samples where the function is on the stack
+type FunctionValue struct {
+	Name string `json:"name"`
+	Flat int64  `json:"flat"`
+	Cum  int64  `json:"cum"`
+}
+
+// Functions The values of the functions of the sample type by name, the recursive functions are counted once per sample
+func Functions(p *profile.Profile, sampleIndex int) map[string]*FunctionValue {
+	functions := make(map[string]*FunctionValue)
+	get := func(name string) *FunctionValue {
+		f, ok := functions[name]
+		if !ok {
+			f = &FunctionValue{Name: name}
+			functions[name] = f
+		}
+		return f
+	}
+
+	seen := make(map[string]struct{})
+	for _, s := range p.Sample {
+		value := s.Value[sampleIndex]
+		if value == 0 {
+			continue
+		}
+		for k := range seen {
+			delete(seen, k)
+		}
+
+		leaf := true
+		for _, loc := range s.Location {
+			for _, line := range loc.Line {
+				name := "?"
+				if line.Function != nil {
+					name = line.Function.Name
+				}
+				f := get(name)
+				if leaf {
+					f.Flat += value
+					leaf = false
+				}
+				if _, ok := seen[name]; !ok {
+					f.Cum += value
+					seen[name] = struct{}{}
+				}
+			}
+		}
+	}
+	return functions
+}
+
+// NewFunctionSummary The function values of all the sample types, sampleTypes are the names of the sample types
+// in the metas, the functions are sorted by name
+func NewFunctionSummary(p *profile.Profile, sampleTypes []string) *storage.FunctionSummary {
+	values := make([]map[string]*FunctionValue, len(p.SampleType))
+	names := make(map[string]struct{})
+	for i := range p.SampleType {
+		values[i] = Functions(p, i)
+		for name := range values[i] {
+			names[name] = struct{}{}
+		}
+	}
+
+	summary := &storage.FunctionSummary{
+		SampleTypes: sampleTypes,
+		Functions:   make([]string, 0, len(names)),
+		Flat:        make([][]int64, len(p.SampleType)),
+		Cum:         make([][]int64, len(p.SampleType)),
+	}
+	for name := range names {
+		summary.Functions = append(summary.Functions, name)
+	}
+	sort.Strings(summary.Functions)
+
+	for i := range p.SampleType {
+		summary.Flat[i] = make([]int64, len(summary.Functions))
+		summary.Cum[i] = make([]int64, len(summary.Functions))
+		for j, name := range summary.Functions {
+			if f, ok := values[i][name]; ok {
+				summary.Flat[i][j] = f.Flat
+				summary.Cum[i][j] = f.Cum
+			}
+		}
+	}
+	return summary
+}
+
+// NewTopFunctions The top n functions by flat and cum value of each sample type of the summary of the profile
+func NewTopFunctions(p *profile.Profile, summary *storage.FunctionSummary, n int) []*storage.TopFunctions {
+	tops := make([]*storage.TopFunctions, 0, len(p.SampleType))
+	for i, st := range p.SampleType {
+		top := &storage.TopFunctions{SampleType: summary.SampleTypes[i], Unit: st.Unit}
+		for _, s := range p.Sample {
+			top.Total += s.Value[i]
+		}
+		top.Flat = topFunctions(summary.Functions, summary.Flat[i], top.Total, n)
+		top.Cum = topFunctions(summary.Functions, summary.Cum[i], top.Total, n)
+		tops = append(tops, top)
+	}
+	return tops
+}
+
+func topFunctions(names []string, values []int64, total int64, n int) []*storage.TopFunction {
+	functions := make([]*storage.TopFunction, 0)
+	for j, name := range names {
+		if values[j] == 0 {
+			continue
+		}
+		f := &storage.TopFunction{Name: name, Value: values[j]}
+		if total != 0 {
+			f.Percent = float64(values[j]) * 100 / math.Abs(float64(total))
+		}
+		functions = append(functions, f)
+	}
+	// the names are sorted, the functions of the same value stay in name order
+	sort.SliceStable(functions, func(i, j int) bool {
+		return abs(functions[i].Value) > abs(functions[j].Value)
+	})
+	if len(functions) > n {
+		functions = functions[:n]
+	}
+	return functions
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
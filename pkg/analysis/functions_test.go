@@ -0,0 +1,82 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+	"github.com/xyctruth/profiler/pkg/storage"
+)
+
+func TestFunctions(t *testing.T) {
+	main := &profile.Function{ID: 1, Name: "main"}
+	foo := &profile.Function{ID: 2, Name: "foo"}
+	inlined := &profile.Function{ID: 3, Name: "inlined"}
+	mainLoc := &profile.Location{ID: 1, Line: []profile.Line{{Function: main}}}
+	fooLoc := &profile.Location{ID: 2, Line: []profile.Line{{Function: inlined}, {Function: foo}}}
+
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples"}, {Type: "cpu"}},
+		Sample: []*profile.Sample{
+			{Location: []*profile.Location{fooLoc, mainLoc}, Value: []int64{1, 10}},
+			// recursive foo
+			{Location: []*profile.Location{fooLoc, fooLoc, mainLoc}, Value: []int64{2, 20}},
+			{Location: []*profile.Location{mainLoc}, Value: []int64{3, 30}},
+			{Location: []*profile.Location{mainLoc}, Value: []int64{0, 0}},
+		},
+	}
+
+	functions := Functions(p, 1)
+	require.Equal(t, 3, len(functions))
+	require.Equal(t, &FunctionValue{Name: "main", Flat: 30, Cum: 60}, functions["main"])
+	require.Equal(t, &FunctionValue{Name: "foo", Flat: 0, Cum: 30}, functions["foo"])
+	require.Equal(t, &FunctionValue{Name: "inlined", Flat: 30, Cum: 30}, functions["inlined"])
+}
+
+func TestNewFunctionSummary(t *testing.T) {
+	main := &profile.Function{ID: 1, Name: "main"}
+	foo := &profile.Function{ID: 2, Name: "foo"}
+	mainLoc := &profile.Location{ID: 1, Line: []profile.Line{{Function: main}}}
+	fooLoc := &profile.Location{ID: 2, Line: []profile.Line{{Function: foo}}}
+
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "alloc_space"}, {Type: "inuse_space"}},
+		Sample: []*profile.Sample{
+			{Location: []*profile.Location{fooLoc, mainLoc}, Value: []int64{1, 0}},
+			{Location: []*profile.Location{mainLoc}, Value: []int64{2, 20}},
+		},
+	}
+
+	summary := NewFunctionSummary(p, []string{"heap_alloc_space", "heap_inuse_space"})
+	require.Equal(t, []string{"heap_alloc_space", "heap_inuse_space"}, summary.SampleTypes)
+	require.Equal(t, []string{"foo", "main"}, summary.Functions)
+	require.Equal(t, [][]int64{{1, 2}, {0, 20}}, summary.Flat)
+	require.Equal(t, [][]int64{{1, 3}, {0, 20}}, summary.Cum)
+}
+
+func TestNewTopFunctions(t *testing.T) {
+	main := &profile.Function{ID: 1, Name: "main"}
+	foo := &profile.Function{ID: 2, Name: "foo"}
+	bar := &profile.Function{ID: 3, Name: "bar"}
+	mainLoc := &profile.Location{ID: 1, Line: []profile.Line{{Function: main}}}
+	fooLoc := &profile.Location{ID: 2, Line: []profile.Line{{Function: foo}}}
+	barLoc := &profile.Location{ID: 3, Line: []profile.Line{{Function: bar}}}
+
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "cpu", Unit: "nanoseconds"}},
+		Sample: []*profile.Sample{
+			{Location: []*profile.Location{fooLoc, mainLoc}, Value: []int64{50}},
+			{Location: []*profile.Location{barLoc, mainLoc}, Value: []int64{30}},
+			{Location: []*profile.Location{mainLoc}, Value: []int64{20}},
+		},
+	}
+
+	tops := NewTopFunctions(p, NewFunctionSummary(p, []string{"profile_cpu"}), 2)
+	require.Equal(t, []*storage.TopFunctions{{
+		SampleType: "profile_cpu",
+		Unit:       "nanoseconds",
+		Total:      100,
+		Flat:       []*storage.TopFunction{{Name: "foo", Value: 50, Percent: 50}, {Name: "bar", Value: 30, Percent: 30}},
+		Cum:        []*storage.TopFunction{{Name: "main", Value: 100, Percent: 100}, {Name: "foo", Value: 50, Percent: 50}},
+	}}, tops)
+}
@@ -0,0 +1,107 @@
+package analysis
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/pprof/profile"
+	"github.com/xyctruth/profiler/pkg/format"
+)
+
+// GoroutineSampleType The sample type of the goroutine profiles scraped from /debug/pprof/goroutine
+const GoroutineSampleType = "goroutine"
+
+// GoroutineSnapshot The goroutine counts of a goroutine profile by stack
+type GoroutineSnapshot struct {
+	ProfileID string
+	// Timestamp in milliseconds
+	Timestamp int64
+	// Counts key is the stack frames from root to leaf joined by ;
+	Counts map[string]int64
+}
+
+// GoroutineLeak The goroutines of a stack whose count never decreased over the snapshots
+type GoroutineLeak struct {
+	// Stack The frames from root to leaf, the root is the function the goroutines were created with
+	// and the leaf is where they are blocked
+	Stack []string `json:"stack"`
+	// InitialCount The count of the first snapshot, 0 if the stack appeared later
+	InitialCount int64 `json:"initial_count"`
+	// Count The count of the last snapshot
+	Count int64 `json:"count"`
+	// GrowthRate The increased goroutines per hour
+	GrowthRate float64 `json:"growth_rate"`
+	// ProfileIDs Examples of the profiles, the first one with the stack and the last one
+	ProfileIDs []string `json:"profile_ids"`
+}
+
+// NewGoroutineSnapshot Group the goroutines of the goroutine profile by stack, the goroutines with different pprof labels
+// or at different lines of the same functions are of the same stack
+func NewGoroutineSnapshot(profileID string, timestamp int64, p *profile.Profile) *GoroutineSnapshot {
+	snapshot := &GoroutineSnapshot{ProfileID: profileID, Timestamp: timestamp, Counts: make(map[string]int64)}
+	for _, s := range p.Sample {
+		if len(s.Value) == 0 || s.Value[0] == 0 {
+			continue
+		}
+		snapshot.Counts[strings.Join(format.Frames(s), ";")] += s.Value[0]
+	}
+	return snapshot
+}
+
+// GoroutineLeaks The stacks whose counts never decreased over the snapshots sorted by timestamp and increased by
+// at least minIncrease (at least 1), sorted by growth rate descending
+func GoroutineLeaks(snapshots []*GoroutineSnapshot, minIncrease int64) []*GoroutineLeak {
+	leaks := make([]*GoroutineLeak, 0)
+	if minIncrease < 1 {
+		minIncrease = 1
+	}
+	if len(snapshots) < 2 {
+		return leaks
+	}
+	first, last := snapshots[0], snapshots[len(snapshots)-1]
+	hours := float64(last.Timestamp-first.Timestamp) / float64(time.Hour.Milliseconds())
+	if hours <= 0 {
+		return leaks
+	}
+
+	for stack, count := range last.Counts {
+		if count-first.Counts[stack] < minIncrease {
+			continue
+		}
+		// the missing stacks have no goroutines
+		monotonic := true
+		appeared := -1
+		for i, snapshot := range snapshots {
+			if i > 0 && snapshot.Counts[stack] < snapshots[i-1].Counts[stack] {
+				monotonic = false
+				break
+			}
+			if appeared < 0 && snapshot.Counts[stack] > 0 {
+				appeared = i
+			}
+		}
+		if !monotonic {
+			continue
+		}
+
+		leak := &GoroutineLeak{
+			Stack:        strings.Split(stack, ";"),
+			InitialCount: first.Counts[stack],
+			Count:        count,
+			GrowthRate:   float64(count-first.Counts[stack]) / hours,
+			ProfileIDs:   []string{snapshots[appeared].ProfileID},
+		}
+		if snapshots[appeared] != last {
+			leak.ProfileIDs = append(leak.ProfileIDs, last.ProfileID)
+		}
+		leaks = append(leaks, leak)
+	}
+	sort.Slice(leaks, func(i, j int) bool {
+		if leaks[i].GrowthRate != leaks[j].GrowthRate {
+			return leaks[i].GrowthRate > leaks[j].GrowthRate
+		}
+		return strings.Join(leaks[i].Stack, ";") < strings.Join(leaks[j].Stack, ";")
+	})
+	return leaks
+}
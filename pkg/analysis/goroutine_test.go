@@ -0,0 +1,57 @@
+package analysis
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+	"github.com/xyctruth/profiler/pkg/format"
+)
+
+func goroutineSnapshot(t *testing.T, id string, minute int64, folded string) *GoroutineSnapshot {
+	p, err := format.ParseFolded(strings.NewReader(folded), &profile.ValueType{Type: "goroutine", Unit: "count"})
+	require.NoError(t, err)
+	return NewGoroutineSnapshot(id, minute*time.Minute.Milliseconds(), p)
+}
+
+func TestNewGoroutineSnapshot(t *testing.T) {
+	snapshot := goroutineSnapshot(t, "0", 0, "main;worker;chanrecv 2\nmain;http.Serve;accept 1\nmain;worker;chanrecv 3")
+	require.Equal(t, "0", snapshot.ProfileID)
+	require.Equal(t, map[string]int64{
+		"main;worker;chanrecv":   5,
+		"main;http.Serve;accept": 1,
+	}, snapshot.Counts)
+}
+
+func TestGoroutineLeaks(t *testing.T) {
+	snapshots := []*GoroutineSnapshot{
+		goroutineSnapshot(t, "0", 0, "worker;chanrecv 10\nserve;accept 1\nbatch;sleep 5"),
+		goroutineSnapshot(t, "1", 30, "worker;chanrecv 10\nserve;accept 1\nbatch;sleep 2\nsubscribe;select 1"),
+		goroutineSnapshot(t, "2", 60, "worker;chanrecv 40\nserve;accept 1\nbatch;sleep 8\nsubscribe;select 3"),
+	}
+
+	leaks := GoroutineLeaks(snapshots, 1)
+	require.Equal(t, 2, len(leaks))
+	require.Equal(t, &GoroutineLeak{
+		Stack:        []string{"worker", "chanrecv"},
+		InitialCount: 10,
+		Count:        40,
+		GrowthRate:   30,
+		ProfileIDs:   []string{"0", "2"},
+	}, leaks[0])
+	require.Equal(t, &GoroutineLeak{
+		Stack:        []string{"subscribe", "select"},
+		InitialCount: 0,
+		Count:        3,
+		GrowthRate:   3,
+		ProfileIDs:   []string{"1", "2"},
+	}, leaks[1])
+
+	leaks = GoroutineLeaks(snapshots, 5)
+	require.Equal(t, 1, len(leaks))
+	require.Equal(t, []string{"worker", "chanrecv"}, leaks[0].Stack)
+
+	require.Empty(t, GoroutineLeaks(snapshots[:1], 1))
+}
@@ -0,0 +1,190 @@
+package analysis
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/pprof/profile"
+	"github.com/xyctruth/profiler/pkg/storage"
+)
+
+// GoroutineDumpProfileType The goroutine dump scraped from /debug/pprof/goroutine?debug=2, saved as the profile of
+// the goroutines grouped by state and the parsed goroutines
+const GoroutineDumpProfileType = "goroutinedump"
+
+// goroutineHeader e.g. "goroutine 18 [chan receive, 5 minutes, locked to thread]:"
+var goroutineHeader = regexp.MustCompile(`^goroutine (\d+)\b.*\[(.*)\]:$`)
+
+// goroutineCreator e.g. "created by main.main in goroutine 1", the goroutine is reported since go 1.21
+var goroutineCreator = regexp.MustCompile(`^created by (.+?)(?: in goroutine (\d+))?$`)
+
+// waitMinutes e.g. "5 minutes"
+var waitMinutes = regexp.MustCompile(`^(\d+) minutes$`)
+
+// ParseGoroutineDump Parse the goroutines of the text dump of /debug/pprof/goroutine?debug=2,
+// the arguments and pc offsets of the frames are dropped
+func ParseGoroutineDump(data []byte) (*storage.GoroutineDump, error) {
+	dump := &storage.GoroutineDump{Goroutines: make([]*storage.Goroutine, 0)}
+	var g *storage.Goroutine
+	// frame The frame waiting for the file line
+	var frame *storage.StackFrame
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			g, frame = nil, nil
+		case g == nil:
+			match := goroutineHeader.FindStringSubmatch(line)
+			if match == nil {
+				return nil, fmt.Errorf("%w: not a goroutine dump, unexpected line %q", ErrInvalidProfile, line)
+			}
+			g = &storage.Goroutine{Stack: make([]*storage.StackFrame, 0)}
+			g.ID, _ = strconv.ParseInt(match[1], 10, 64)
+			parseGoroutineStatus(g, match[2])
+			dump.Goroutines = append(dump.Goroutines, g)
+		case strings.HasPrefix(line, "\t"):
+			if frame == nil {
+				continue
+			}
+			frame.File, frame.Line = parseFileLine(strings.TrimSpace(line))
+			frame = nil
+		case strings.HasPrefix(line, "created by "):
+			match := goroutineCreator.FindStringSubmatch(line)
+			frame = &storage.StackFrame{Function: match[1]}
+			g.CreatedBy = frame
+			g.CreatorID, _ = strconv.ParseInt(match[2], 10, 64)
+		case strings.HasPrefix(line, "..."):
+			// ...additional frames elided...
+			frame = nil
+		default:
+			frame = &storage.StackFrame{Function: trimArguments(line)}
+			g.Stack = append(g.Stack, frame)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(dump.Goroutines) == 0 {
+		return nil, fmt.Errorf("%w: no goroutine in the dump", ErrInvalidProfile)
+	}
+	return dump, nil
+}
+
+// parseGoroutineStatus e.g. "chan receive, 5 minutes, locked to thread", the parts other than the wait time
+// and the thread lock are the state
+func parseGoroutineStatus(g *storage.Goroutine, status string) {
+	states := make([]string, 0, 1)
+	for _, part := range strings.Split(status, ", ") {
+		if match := waitMinutes.FindStringSubmatch(part); match != nil {
+			g.WaitMinutes, _ = strconv.ParseInt(match[1], 10, 64)
+			continue
+		}
+		if part == "locked to thread" {
+			g.LockedToThread = true
+			continue
+		}
+		states = append(states, part)
+	}
+	g.State = strings.Join(states, ", ")
+}
+
+// trimArguments e.g. "main.(*T).Run(0xc000010000, {0x0, 0x1})" to "main.(*T).Run"
+func trimArguments(line string) string {
+	if !strings.HasSuffix(line, ")") {
+		return line
+	}
+	depth := 0
+	for i := len(line) - 1; i >= 0; i-- {
+		switch line[i] {
+		case ')':
+			depth++
+		case '(':
+			depth--
+			if depth == 0 {
+				return line[:i]
+			}
+		}
+	}
+	return line
+}
+
+// parseFileLine e.g. "/app/main.go:10 +0x6b"
+func parseFileLine(s string) (string, int64) {
+	if i := strings.LastIndex(s, " +0x"); i >= 0 {
+		s = s[:i]
+	}
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return s, 0
+	}
+	line, err := strconv.ParseInt(s[i+1:], 10, 64)
+	if err != nil {
+		return s, 0
+	}
+	return s[:i], line
+}
+
+// GoroutineDumpProfile The profile of the goroutines, the root frames are the states like [chan receive] and the
+// samples are labeled with the states, the wait sample type is the wait time of the goroutines
+func GoroutineDumpProfile(dump *storage.GoroutineDump) (*profile.Profile, error) {
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "goroutine", Unit: "count"}, {Type: "wait", Unit: "nanoseconds"}},
+		PeriodType: &profile.ValueType{Type: "goroutine", Unit: "count"},
+		Period:     1,
+	}
+	functions := make(map[string]*profile.Function)
+	locations := make(map[string]*profile.Location)
+	location := func(name, file string, line int64) *profile.Location {
+		key := fmt.Sprintf("%s %s:%d", name, file, line)
+		if loc, ok := locations[key]; ok {
+			return loc
+		}
+		fn, ok := functions[name+" "+file]
+		if !ok {
+			fn = &profile.Function{ID: uint64(len(functions) + 1), Name: name, SystemName: name, Filename: file}
+			functions[name+" "+file] = fn
+			p.Function = append(p.Function, fn)
+		}
+		loc := &profile.Location{ID: uint64(len(locations) + 1), Line: []profile.Line{{Function: fn, Line: line}}}
+		locations[key] = loc
+		p.Location = append(p.Location, loc)
+		return loc
+	}
+
+	for _, g := range dump.Goroutines {
+		s := &profile.Sample{
+			Location: make([]*profile.Location, 0, len(g.Stack)+1),
+			Value:    []int64{1, g.WaitMinutes * time.Minute.Nanoseconds()},
+			Label:    map[string][]string{"state": {g.State}},
+		}
+		for _, frame := range g.Stack {
+			s.Location = append(s.Location, location(frame.Function, frame.File, frame.Line))
+		}
+		s.Location = append(s.Location, location("["+g.State+"]", "", 0))
+		p.Sample = append(p.Sample, s)
+	}
+	// the goroutines of the same state and stack are merged
+	return profile.Merge([]*profile.Profile{p})
+}
+
+func saveGoroutineDump(store storage.Store, p Profile, expiration time.Duration) (string, error) {
+	dump, err := ParseGoroutineDump(p.Data)
+	if err != nil {
+		return "", err
+	}
+	prof, err := GoroutineDumpProfile(dump)
+	if err != nil {
+		return "", err
+	}
+	return saveProfile(store, p, prof, expiration, func(profileID string) error {
+		return store.SaveGoroutineDump(profileID, dump, expiration)
+	})
+}
@@ -0,0 +1,103 @@
+package analysis
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xyctruth/profiler/pkg/format"
+	"github.com/xyctruth/profiler/pkg/storage"
+	"github.com/xyctruth/profiler/pkg/storage/badger"
+)
+
+func TestParseGoroutineDump(t *testing.T) {
+	data, err := ioutil.ReadFile("../apiserver/testdata/goroutine.debug2.testdata")
+	require.NoError(t, err)
+	dump, err := ParseGoroutineDump(data)
+	require.NoError(t, err)
+	require.Equal(t, 6, len(dump.Goroutines))
+
+	main := dump.Goroutines[0]
+	require.Equal(t, int64(1), main.ID)
+	require.Equal(t, "running", main.State)
+	require.Nil(t, main.CreatedBy)
+	require.Equal(t, &storage.StackFrame{Function: "runtime/pprof.(*Profile).WriteTo", File: "/go/src/runtime/pprof/pprof.go", Line: 405},
+		main.Stack[2])
+
+	require.Equal(t, &storage.Goroutine{
+		ID:          6,
+		State:       "chan receive",
+		WaitMinutes: 5,
+		Stack:       []*storage.StackFrame{{Function: "main.worker", File: "/app/main.go", Line: 10}},
+		CreatedBy:   &storage.StackFrame{Function: "main.main", File: "/app/main.go", Line: 17},
+		CreatorID:   1,
+	}, dump.Goroutines[1])
+	require.Equal(t, "sync.Mutex.Lock", dump.Goroutines[3].State)
+	require.Equal(t, 5, len(dump.Goroutines[3].Stack))
+	require.Equal(t, "select (no cases)", dump.Goroutines[4].State)
+
+	sleep := dump.Goroutines[5]
+	require.Equal(t, "sleep", sleep.State)
+	require.Equal(t, int64(59), sleep.WaitMinutes)
+	require.True(t, sleep.LockedToThread)
+
+	_, err = ParseGoroutineDump([]byte("not a goroutine dump"))
+	require.ErrorIs(t, err, ErrInvalidProfile)
+	_, err = ParseGoroutineDump(nil)
+	require.ErrorIs(t, err, ErrInvalidProfile)
+}
+
+func TestGoroutineDumpProfile(t *testing.T) {
+	data, err := ioutil.ReadFile("../apiserver/testdata/goroutine.debug2.testdata")
+	require.NoError(t, err)
+	dump, err := ParseGoroutineDump(data)
+	require.NoError(t, err)
+	p, err := GoroutineDumpProfile(dump)
+	require.NoError(t, err)
+	require.NoError(t, p.CheckValid())
+
+	// the goroutines of main.worker are merged
+	require.Equal(t, 5, len(p.Sample))
+	for _, s := range p.Sample {
+		if s.Label["state"][0] != "chan receive" {
+			continue
+		}
+		require.Equal(t, []int64{2, (17 * time.Minute).Nanoseconds()}, s.Value)
+		require.Equal(t, []string{"[chan receive]", "main.worker"}, format.Frames(s))
+	}
+}
+
+func TestSaveGoroutineDump(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	store := badger.NewStore(badger.DefaultOptions(dir))
+	defer store.Release()
+
+	data, err := ioutil.ReadFile("../apiserver/testdata/goroutine.debug2.testdata")
+	require.NoError(t, err)
+	id, err := Save(store, Profile{
+		TargetName:  "server",
+		Instance:    "localhost:9000",
+		ProfileType: GoroutineDumpProfileType,
+		Data:        data,
+	}, time.Hour)
+	require.NoError(t, err)
+
+	sampleTypes, err := store.ListSampleType()
+	require.NoError(t, err)
+	require.Equal(t, []string{"goroutinedump_goroutine", "goroutinedump_wait"}, sampleTypes)
+	metas, err := store.ListProfileMeta("goroutinedump_goroutine", time.Now().Add(-time.Minute), time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(metas))
+	require.Equal(t, int64(6), metas[0].ProfileMetas[0].Value)
+
+	dump, err := store.GetGoroutineDump(id)
+	require.NoError(t, err)
+	require.Equal(t, 6, len(dump.Goroutines))
+
+	_, err = Save(store, Profile{TargetName: "server", ProfileType: GoroutineDumpProfileType, Data: []byte("<html>")}, time.Hour)
+	require.ErrorIs(t, err, ErrInvalidProfile)
+}
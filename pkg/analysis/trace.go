@@ -0,0 +1,115 @@
+package analysis
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/xyctruth/profiler/pkg/internal/v1175/trace"
+)
+
+// TraceMetric A metric of a go trace, saved as the sample type trace_<name>
+type TraceMetric struct {
+	Name  string
+	Unit  string
+	Value int64
+}
+
+// TraceMetrics Compute the GC, scheduler, syscall and blocking metrics of the go trace and its duration,
+// the traces of go 1.22+ are not supported by the parser. The blocking time is split by the cause:
+// block (channels, select, mutexes and conditions), block_net (network IO), block_gc (GC assists),
+// sleep (time.Sleep) and block_other (the other parked goroutines, mostly the idle goroutines of the runtime)
+func TraceMetrics(data []byte) ([]*TraceMetric, time.Duration, error) {
+	res, err := trace.Parse(bytes.NewReader(data), "")
+	if err != nil {
+		return nil, 0, err
+	}
+	events := res.Events
+	if len(events) == 0 {
+		return nil, 0, nil
+	}
+
+	var gcCount, goroutineCreations int64
+	var stw, syscall, block, blockNet, blockGC, sleep, blockOther time.Duration
+	schedLatencies := make([]time.Duration, 0)
+	for _, ev := range events {
+		switch ev.Type {
+		case trace.EvGCStart:
+			gcCount++
+		case trace.EvGCSTWStart:
+			if ev.Link != nil {
+				stw += time.Duration(ev.Link.Ts - ev.Ts)
+			}
+		case trace.EvGoCreate:
+			goroutineCreations++
+		case trace.EvGoSysCall:
+			// linked to the exit if the syscall blocked
+			if ev.Link != nil {
+				syscall += time.Duration(ev.Link.Ts - ev.Ts)
+			}
+		case trace.EvGoBlockSend, trace.EvGoBlockRecv, trace.EvGoBlockSelect, trace.EvGoBlockSync, trace.EvGoBlockCond:
+			block += linkedDuration(ev)
+		case trace.EvGoBlockNet:
+			blockNet += linkedDuration(ev)
+		case trace.EvGoBlockGC:
+			blockGC += linkedDuration(ev)
+		case trace.EvGoSleep:
+			sleep += linkedDuration(ev)
+		case trace.EvGoBlock:
+			blockOther += linkedDuration(ev)
+		}
+		// the time from runnable to running
+		if (ev.Type == trace.EvGoCreate || ev.Type == trace.EvGoUnblock) && ev.Link != nil {
+			schedLatencies = append(schedLatencies, time.Duration(ev.Link.Ts-ev.Ts))
+		}
+	}
+	sort.Slice(schedLatencies, func(i, j int) bool {
+		return schedLatencies[i] < schedLatencies[j]
+	})
+
+	metrics := []*TraceMetric{
+		{Name: "gc_count", Unit: "count", Value: gcCount},
+		{Name: "gc_stw", Unit: "nanoseconds", Value: stw.Nanoseconds()},
+	}
+	utils := trace.MutatorUtilization(events, trace.UtilSTW|trace.UtilBackground|trace.UtilAssist|trace.UtilSweep)
+	if len(utils) > 0 {
+		mmu := trace.NewMMUCurve(utils)
+		metrics = append(metrics,
+			&TraceMetric{Name: "mmu_1ms", Unit: "percent", Value: int64(math.Round(mmu.MMU(time.Millisecond) * 100))},
+			&TraceMetric{Name: "mmu_10ms", Unit: "percent", Value: int64(math.Round(mmu.MMU(10*time.Millisecond) * 100))},
+		)
+	}
+	metrics = append(metrics,
+		&TraceMetric{Name: "goroutine_creations", Unit: "count", Value: goroutineCreations},
+		&TraceMetric{Name: "sched_latency_p50", Unit: "nanoseconds", Value: percentile(schedLatencies, 0.5).Nanoseconds()},
+		&TraceMetric{Name: "sched_latency_p99", Unit: "nanoseconds", Value: percentile(schedLatencies, 0.99).Nanoseconds()},
+		&TraceMetric{Name: "syscall", Unit: "nanoseconds", Value: syscall.Nanoseconds()},
+		&TraceMetric{Name: "block", Unit: "nanoseconds", Value: block.Nanoseconds()},
+		&TraceMetric{Name: "block_net", Unit: "nanoseconds", Value: blockNet.Nanoseconds()},
+		&TraceMetric{Name: "block_gc", Unit: "nanoseconds", Value: blockGC.Nanoseconds()},
+		&TraceMetric{Name: "sleep", Unit: "nanoseconds", Value: sleep.Nanoseconds()},
+		&TraceMetric{Name: "block_other", Unit: "nanoseconds", Value: blockOther.Nanoseconds()},
+	)
+	return metrics, time.Duration(events[len(events)-1].Ts - events[0].Ts), nil
+}
+
+// linkedDuration The time from the event to the linked unblock event, 0 if it is not unblocked in the trace
+func linkedDuration(ev *trace.Event) time.Duration {
+	if ev.Link == nil {
+		return 0
+	}
+	return time.Duration(ev.Link.Ts - ev.Ts)
+}
+
+// percentile The nearest rank percentile of the sorted values, 0 if there is none
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
@@ -0,0 +1,82 @@
+package analysis
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xyctruth/profiler/pkg/storage/badger"
+)
+
+func TestTraceMetrics(t *testing.T) {
+	data, err := ioutil.ReadFile("../internal/v1175/trace/testdata/stress_1_11_good")
+	require.NoError(t, err)
+	metrics, duration, err := TraceMetrics(data)
+	require.NoError(t, err)
+	require.Equal(t, 162401664*time.Nanosecond, duration)
+
+	values := make(map[string]int64)
+	for _, metric := range metrics {
+		values[metric.Name+" "+metric.Unit] = metric.Value
+	}
+	require.Equal(t, map[string]int64{
+		"gc_count count":                251,
+		"gc_stw nanoseconds":            18929563,
+		"mmu_1ms percent":               57,
+		"mmu_10ms percent":              74,
+		"goroutine_creations count":     36,
+		"sched_latency_p50 nanoseconds": 1924,
+		"sched_latency_p99 nanoseconds": 62774,
+		"syscall nanoseconds":           52642794,
+		"block nanoseconds":             27301457,
+		"block_net nanoseconds":         1095689,
+		"block_gc nanoseconds":          1450362,
+		"sleep nanoseconds":             52186261,
+		"block_other nanoseconds":       1240780256,
+	}, values)
+
+	_, _, err = TraceMetrics([]byte("go 1.22 trace\x00\x00\x00\x00"))
+	require.Error(t, err)
+}
+
+func TestSaveTraceMetrics(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	store := badger.NewStore(badger.DefaultOptions(dir))
+	defer store.Release()
+
+	data, err := ioutil.ReadFile("../internal/v1175/trace/testdata/stress_1_11_good")
+	require.NoError(t, err)
+	id, err := Save(store, Profile{TargetName: "server", Instance: "localhost:9000", ProfileType: TraceProfileType, Data: data}, time.Hour)
+	require.NoError(t, err)
+
+	sampleTypes, err := store.ListSampleType()
+	require.NoError(t, err)
+	require.Equal(t, 14, len(sampleTypes))
+	require.Contains(t, sampleTypes, "trace")
+	require.Contains(t, sampleTypes, "trace_sched_latency_p99")
+
+	metas, err := store.ListProfileMeta("trace_gc_count", time.Now().Add(-time.Minute), time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(metas))
+	meta := metas[0].ProfileMetas[0]
+	require.Equal(t, id, meta.ProfileID)
+	require.Equal(t, TraceProfileType, meta.ProfileType)
+	require.Equal(t, "count", meta.SampleTypeUnit)
+	require.Equal(t, int64(251), meta.Value)
+	require.Equal(t, int64(162401664), meta.Duration)
+
+	// the traces not supported by the parser are saved without the metrics
+	_, err = Save(store, Profile{TargetName: "server", Instance: "localhost:9001", ProfileType: TraceProfileType,
+		Data: []byte("go 1.22 trace\x00\x00\x00\x00")}, time.Hour)
+	require.NoError(t, err)
+	metas, err = store.ListProfileMeta("trace", time.Now().Add(-time.Minute), time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 2, len(metas))
+	metas, err = store.ListProfileMeta("trace_gc_count", time.Now().Add(-time.Minute), time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(metas))
+}
@@ -5,19 +5,29 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/pprof/profile"
 	log "github.com/sirupsen/logrus"
 	"github.com/xyctruth/profiler/pkg/apiserver/ui"
 	"github.com/xyctruth/profiler/pkg/apiserver/ui/pprof"
 	"github.com/xyctruth/profiler/pkg/apiserver/ui/trace"
+	"github.com/xyctruth/profiler/pkg/collector"
+	"github.com/xyctruth/profiler/pkg/format"
+	"github.com/xyctruth/profiler/pkg/metrics"
 	"github.com/xyctruth/profiler/pkg/storage"
 	"github.com/xyctruth/profiler/pkg/utils"
 	"github.com/xyctruth/profiler/version"
 )
 
+const (
+	pprofPath = "/api/pprof/ui"
+	tracePath = "/api/trace/ui"
+)
+
 type APIServer struct {
 	opt    Options
 	store  storage.Store
@@ -28,9 +38,6 @@ type APIServer struct {
 }
 
 func NewAPIServer(opt Options) *APIServer {
-	pprofPath := "/api/pprof/ui"
-	tracePath := "/api/trace/ui"
-
 	apiServer := &APIServer{
 		opt:   opt,
 		store: opt.Store,
@@ -39,6 +46,11 @@ func NewAPIServer(opt Options) *APIServer {
 	}
 
 	router := gin.Default()
+	router.Use(metrics.HandleAPIMetrics)
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
+	if opt.ProfileMetrics != nil {
+		router.GET("/metrics/profiles", gin.WrapH(opt.ProfileMetrics))
+	}
 	router.GET("/api/healthz", func(c *gin.Context) {
 		c.String(200, "I'm fine")
 	})
@@ -46,11 +58,32 @@ func NewAPIServer(opt Options) *APIServer {
 		c.JSON(200, gin.H{"version": version.Version, "gitRevision": version.GitRevision})
 	})
 	router.Use(HandleCors).GET("/api/targets", apiServer.listTarget)
+	router.Use(HandleCors).GET("/api/targets/status", apiServer.listTargetStatus)
+	router.Use(HandleCors).GET("/api/targets/status/:target", apiServer.getTargetStatus)
 	router.Use(HandleCors).GET("/api/group_labels", apiServer.listGroupLabel)
 	router.Use(HandleCors).GET("/api/sample_types", apiServer.listSampleTypes)
 	router.Use(HandleCors).GET("/api/group_sample_types", apiServer.listGroupSampleTypes)
 	router.Use(HandleCors).GET("/api/profile_meta/:sample_type", apiServer.listProfileMeta)
 	router.Use(HandleCors).GET("/api/download/:id", apiServer.downloadProfile)
+	router.Use(HandleCors).GET("/api/flamegraph/:id", apiServer.flamegraph)
+	router.Use(HandleCors).GET("/api/profile/:id/top", apiServer.topFunctions)
+	router.Use(HandleCors).GET("/api/profile/:id/goroutines", apiServer.goroutineDump)
+	router.Use(HandleCors).GET("/api/merge/:sample_type", apiServer.mergeProfile)
+	router.Use(HandleCors).POST("/api/merge/:sample_type", apiServer.saveMergeProfile)
+	router.Use(HandleCors).GET("/api/functions/:sample_type", apiServer.listFunctions)
+	router.Use(HandleCors).GET("/api/pgo/:target", apiServer.pgoProfile)
+	router.Use(HandleCors).GET("/api/analysis/goroutine-leaks", apiServer.goroutineLeaks)
+	router.Use(HandleCors).GET("/api/diff/:base_id/:id", apiServer.diffProfile)
+	router.Use(HandleCors).POST("/api/diff/:base_id/:id", apiServer.saveDiffProfile)
+	// GET opens the diff from a link, the diff profile is saved with MergeExpiration
+	router.Use(HandleCors).GET("/api/diff/:base_id/:id/ui", apiServer.webDiffProfile)
+	router.Use(HandleCors).POST("/api/diff/:base_id/:id/ui", apiServer.webDiffProfile)
+	router.Use(HandleCors).GET("/api/diff/:base_id/:id/functions", apiServer.diffFunctions)
+	router.Use(HandleCors).POST("/api/ingest", apiServer.ingest)
+	// pyroscope clients push to /ingest of the server address
+	router.Use(HandleCors).POST("/ingest", apiServer.pyroscopeIngest)
+	// OTLP/HTTP exporters push the profiles signal to /v1development/profiles
+	router.Use(HandleCors).POST("/v1development/profiles", apiServer.otlpProfiles)
 
 	// register pprof page
 	router.Use(HandleCors).GET(pprofPath+"/*any", apiServer.webPProf)
@@ -100,6 +133,27 @@ func (s *APIServer) listTarget(c *gin.Context) {
 	c.JSON(http.StatusOK, jobs)
 }
 
+func (s *APIServer) listTargetStatus(c *gin.Context) {
+	if s.opt.TargetStatus == nil {
+		c.JSON(http.StatusOK, []*collector.TargetStatus{})
+		return
+	}
+	c.JSON(http.StatusOK, s.opt.TargetStatus.ListTargetStatus())
+}
+
+func (s *APIServer) getTargetStatus(c *gin.Context) {
+	if s.opt.TargetStatus == nil {
+		c.String(http.StatusNotFound, "Target not found")
+		return
+	}
+	status, ok := s.opt.TargetStatus.GetTargetStatus(c.Param("target"))
+	if !ok {
+		c.String(http.StatusNotFound, "Target not found")
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
 func (s *APIServer) listGroupLabel(c *gin.Context) {
 	labels, err := s.store.ListLabel()
 	if err != nil {
@@ -147,10 +201,25 @@ func (s *APIServer) listGroupSampleTypes(c *gin.Context) {
 }
 
 func (s *APIServer) listProfileMeta(c *gin.Context) {
-	var startTime, endTime time.Time
-	var err error
-
 	sampleType := c.Param("sample_type")
+	startTime, endTime, filters, ok := parseMetaQuery(c)
+	if !ok {
+		return
+	}
+
+	metas, err := s.store.ListProfileMeta(sampleType, startTime, endTime, filters...)
+
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, metas)
+}
+
+// parseMetaQuery Parse the start_time, end_time and labels[] query of the profile metas,
+// the error response is written if ok is false
+func parseMetaQuery(c *gin.Context) (startTime, endTime time.Time, filters []storage.LabelFilter, ok bool) {
+	var err error
 
 	if c.Query("start_time") == "" || c.Query("end_time") == "" {
 		c.String(http.StatusBadRequest, "start_time or end_time is empty")
@@ -174,14 +243,7 @@ func (s *APIServer) listProfileMeta(c *gin.Context) {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
-
-	metas, err := s.store.ListProfileMeta(sampleType, startTime, endTime, req.Filters...)
-
-	if err != nil {
-		c.String(http.StatusInternalServerError, err.Error())
-		return
-	}
-	c.JSON(http.StatusOK, metas)
+	return startTime, endTime, req.Filters, true
 }
 
 func (s *APIServer) downloadProfile(c *gin.Context) {
@@ -195,8 +257,82 @@ func (s *APIServer) downloadProfile(c *gin.Context) {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
-	c.Writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment;filename=%s-%s.out", name, id))
-	c.Data(200, "application/octet-stream", data)
+
+	// convert the pprof to the format, the go trace can not be converted
+	switch c.DefaultQuery("format", "pprof") {
+	case "pprof":
+		c.Writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment;filename=%s-%s.out", name, id))
+		c.Data(200, "application/octet-stream", data)
+	case "folded":
+		p, err := profile.ParseData(data)
+		if err != nil {
+			c.String(http.StatusBadRequest, "the profile can not be converted: %s", err.Error())
+			return
+		}
+		sampleIndex, err := parseSampleIndex(p, c.Query("sample_type"))
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		c.Writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment;filename=%s-%s.folded", name, id))
+		c.Writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		c.Status(http.StatusOK)
+		_ = format.WriteFolded(c.Writer, p, sampleIndex)
+	case "speedscope":
+		p, err := profile.ParseData(data)
+		if err != nil {
+			c.String(http.StatusBadRequest, "the profile can not be converted: %s", err.Error())
+			return
+		}
+		c.Writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment;filename=%s-%s.speedscope.json", name, id))
+		c.Writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+		c.Status(http.StatusOK)
+		_ = format.WriteSpeedscope(c.Writer, p, name)
+	default:
+		c.String(http.StatusBadRequest, "unsupported format %s", c.Query("format"))
+	}
+}
+
+// getPProf Get and parse the pprof of the id param, the error response is written if ok is false
+func (s *APIServer) getPProf(c *gin.Context) (p *profile.Profile, ok bool) {
+	return s.getPProfByID(c, c.Param("id"))
+}
+
+// getPProfByID Get and parse the pprof of the id, the error response is written if ok is false
+func (s *APIServer) getPProfByID(c *gin.Context, id string) (p *profile.Profile, ok bool) {
+	_, data, err := s.store.GetProfile(id)
+	if err != nil {
+		if errors.Is(err, storage.ErrProfileNotFound) {
+			c.String(http.StatusNotFound, "Profile not found")
+			return nil, false
+		}
+		c.String(http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+	p, err = profile.ParseData(data)
+	if err != nil {
+		c.String(http.StatusBadRequest, "the profile is not a pprof: %s", err.Error())
+		return nil, false
+	}
+	return p, true
+}
+
+// parseSampleIndex The sample index or sample type, the sample type can be prefixed with the profile type
+// like the sample types of the profile metas, default is the last sample type
+func parseSampleIndex(p *profile.Profile, value string) (int, error) {
+	if i, err := strconv.Atoi(value); err == nil {
+		if i < 0 || i >= len(p.SampleType) {
+			return 0, fmt.Errorf("sample index %d out of range", i)
+		}
+		return i, nil
+	}
+	i, err := format.SampleIndex(p, value)
+	if err != nil && strings.Contains(value, "_") {
+		if i, prefixErr := format.SampleIndex(p, value[strings.Index(value, "_")+1:]); prefixErr == nil {
+			return i, nil
+		}
+	}
+	return i, err
 }
 
 func (s *APIServer) webPProf(c *gin.Context) {
@@ -11,6 +11,7 @@ import (
 	"github.com/gavv/httpexpect/v2"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/require"
+	"github.com/xyctruth/profiler/pkg/collector"
 	"github.com/xyctruth/profiler/pkg/storage"
 	"github.com/xyctruth/profiler/pkg/storage/badger"
 )
@@ -129,6 +130,12 @@ func TestBasisAPI(t *testing.T) {
 		Expect().
 		Status(http.StatusOK)
 
+	e.GET("/metrics").
+		Expect().
+		Status(http.StatusOK).Text().
+		Contains(`profiler_api_request_duration_seconds_count{code="200",method="GET",route="/api/healthz"}`).
+		Contains("profiler_store_profile_metas_total")
+
 	e.GET("/api/targets").
 		Expect().
 		Status(http.StatusOK).JSON().Array().Contains("profiler-server", "server2", "server3")
@@ -218,6 +225,95 @@ func TestDownloadProfile(t *testing.T) {
 		Status(http.StatusOK).Header("Content-Type").Equal("application/octet-stream")
 }
 
+func TestDownloadProfileFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	s := badger.NewStore(badger.DefaultOptions(dir))
+	_, _, id, traceID := initProfileData(s, t)
+	apiServer := NewAPIServer(DefaultOptions(s))
+	e := getExpect(apiServer, t)
+
+	folded := e.GET(fmt.Sprintf("/api/download/%s", id)).WithQuery("format", "folded").
+		Expect().
+		Status(http.StatusOK)
+	folded.Header("Content-Type").Equal("text/plain; charset=utf-8")
+	folded.Body().NotEmpty()
+
+	e.GET(fmt.Sprintf("/api/download/%s", id)).WithQuery("format", "folded").WithQuery("sample_type", "unknown").
+		Expect().
+		Status(http.StatusBadRequest)
+
+	speedscope := e.GET(fmt.Sprintf("/api/download/%s", id)).WithQuery("format", "speedscope").
+		Expect().
+		Status(http.StatusOK).JSON().Object()
+	speedscope.Value("$schema").Equal("https://www.speedscope.app/file-format-schema.json")
+	speedscope.Value("profiles").Array().NotEmpty()
+
+	e.GET(fmt.Sprintf("/api/download/%s", traceID)).WithQuery("format", "folded").
+		Expect().
+		Status(http.StatusBadRequest)
+
+	e.GET(fmt.Sprintf("/api/download/%s", id)).WithQuery("format", "svg").
+		Expect().
+		Status(http.StatusBadRequest)
+}
+
+func TestFlamegraph(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	s := badger.NewStore(badger.DefaultOptions(dir))
+	_, invalidID, id, _ := initProfileData(s, t)
+	apiServer := NewAPIServer(DefaultOptions(s))
+	e := getExpect(apiServer, t)
+
+	fg := e.GET(fmt.Sprintf("/api/flamegraph/%s", id)).
+		Expect().
+		Status(http.StatusOK).JSON().Object()
+	fg.Value("names").Array().First().Equal("root")
+	fg.Value("sample_type").Equal("alloc_space")
+	total := fg.Value("total").Number().Raw()
+	fg.Path("$.root.t").Equal(total)
+
+	e.GET(fmt.Sprintf("/api/flamegraph/%s", id)).WithQuery("sample_index", "heap_alloc_objects").
+		Expect().
+		Status(http.StatusOK).JSON().Object().Value("sample_type").Equal("alloc_objects")
+	e.GET(fmt.Sprintf("/api/flamegraph/%s", id)).WithQuery("sample_index", 0).
+		Expect().
+		Status(http.StatusOK).JSON().Object().Value("sample_type").Equal("alloc_objects")
+
+	// the samples without the runtime frames
+	ignored := e.GET(fmt.Sprintf("/api/flamegraph/%s", id)).WithQuery("ignore", "^runtime\\.").WithQuery("node_fraction", 0).
+		Expect().
+		Status(http.StatusOK).JSON().Object()
+	ignored.Value("names").Array().NotContains("runtime.main")
+	ignored.Value("total").Number().Lt(total)
+
+	focused := e.GET(fmt.Sprintf("/api/flamegraph/%s", id)).WithQuery("focus", "^runtime\\.main$").
+		Expect().
+		Status(http.StatusOK).JSON().Object()
+	focused.Value("names").Array().Contains("runtime.main")
+
+	e.GET(fmt.Sprintf("/api/flamegraph/%s", id)).WithQuery("sample_index", 9).
+		Expect().
+		Status(http.StatusBadRequest)
+	e.GET(fmt.Sprintf("/api/flamegraph/%s", id)).WithQuery("focus", "(").
+		Expect().
+		Status(http.StatusBadRequest)
+	e.GET(fmt.Sprintf("/api/flamegraph/%s", id)).WithQuery("node_fraction", 2).
+		Expect().
+		Status(http.StatusBadRequest)
+	e.GET(fmt.Sprintf("/api/flamegraph/%s", invalidID)).
+		Expect().
+		Status(http.StatusBadRequest)
+	e.GET("/api/flamegraph/999").
+		Expect().
+		Status(http.StatusNotFound)
+}
+
 func TestWebProfile(t *testing.T) {
 	dir, err := ioutil.TempDir("./", "temp-*")
 	require.Equal(t, nil, err)
@@ -244,6 +340,60 @@ func TestTraceProfile(t *testing.T) {
 		Status(http.StatusNotFound).Text().Equal("Profile not found\n")
 }
 
+type targetStatusProvider []*collector.TargetStatus
+
+func (p targetStatusProvider) ListTargetStatus() []*collector.TargetStatus {
+	return p
+}
+
+func (p targetStatusProvider) GetTargetStatus(targetName string) (*collector.TargetStatus, bool) {
+	for _, status := range p {
+		if status.TargetName == targetName {
+			return status, true
+		}
+	}
+	return nil, false
+}
+
+func TestTargetStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	s := badger.NewStore(badger.DefaultOptions(dir))
+
+	e := getExpect(NewAPIServer(DefaultOptions(s)), t)
+	e.GET("/api/targets/status").
+		Expect().
+		Status(http.StatusOK).JSON().Array().Empty()
+	e.GET("/api/targets/status/server").
+		Expect().
+		Status(http.StatusNotFound)
+
+	provider := targetStatusProvider{
+		{TargetName: "profiler-server", Up: 1, Scrapes: []*collector.ScrapeStatus{
+			{Instance: "localhost:9000", ProfileType: "heap", Health: collector.HealthUp, StatusCode: http.StatusOK},
+		}},
+		{TargetName: "server2", Down: 1, Scrapes: []*collector.ScrapeStatus{
+			{Instance: "localhost:9001", ProfileType: "heap", Health: collector.HealthDown, LastError: "connection refused", ConsecutiveFailures: 3},
+		}},
+	}
+	e = getExpect(NewAPIServer(DefaultOptions(s).WithTargetStatus(provider)), t)
+	e.GET("/api/targets/status").
+		Expect().
+		Status(http.StatusOK).JSON().Array().Length().Equal(2)
+
+	res := e.GET("/api/targets/status/server2").
+		Expect().
+		Status(http.StatusOK).JSON().Object()
+	res.Value("down").Equal(1)
+	res.Path("$.scrapes[0].last_error").Equal("connection refused")
+	res.Path("$.scrapes[0].consecutive_failures").Equal(3)
+
+	e.GET("/api/targets/status/server3").
+		Expect().
+		Status(http.StatusNotFound)
+}
+
 func getExpect(apiServer *APIServer, t *testing.T) *httpexpect.Expect {
 	handler := apiServer.router
 
@@ -0,0 +1,233 @@
+package apiserver
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/pprof/profile"
+	"github.com/xyctruth/profiler/pkg/analysis"
+)
+
+// defaultDiffLimit The number of the increased and decreased functions
+const defaultDiffLimit = 20
+
+// diffProfile Download the profile with the base subtracted like go tool pprof -diff_base, the diff profile
+// is not saved
+// Query parameters: normalize (scale the profile to the base totals, default false)
+func (s *APIServer) diffProfile(c *gin.Context) {
+	data, ok := s.diff(c)
+	if !ok {
+		return
+	}
+	c.Writer.Header().Set("Content-Disposition",
+		fmt.Sprintf("attachment;filename=diff-%s-%s.out", c.Param("base_id"), c.Param("id")))
+	c.Data(http.StatusOK, "application/octet-stream", data)
+}
+
+// saveDiffProfile Save the diff profile with MergeExpiration, so that it can be opened in the pprof ui by the
+// returned id. The merged profiles saved by POST /api/merge can be compared by their ids
+// Query parameters: normalize
+func (s *APIServer) saveDiffProfile(c *gin.Context) {
+	profileID, ok := s.saveDiff(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"profile_id": profileID,
+		"pprof_ui":   fmt.Sprintf("%s/%s", pprofPath, profileID),
+	})
+}
+
+// webDiffProfile Save the diff profile and redirect to its pprof ui
+func (s *APIServer) webDiffProfile(c *gin.Context) {
+	profileID, ok := s.saveDiff(c)
+	if !ok {
+		return
+	}
+	c.Redirect(http.StatusSeeOther, fmt.Sprintf("%s/%s", pprofPath, profileID))
+}
+
+func (s *APIServer) saveDiff(c *gin.Context) (string, bool) {
+	data, ok := s.diff(c)
+	if !ok {
+		return "", false
+	}
+	profileID, err := s.store.SaveProfile(fmt.Sprintf("diff-%s-%s", c.Param("base_id"), c.Param("id")), data, s.opt.MergeExpiration)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return "", false
+	}
+	return profileID, true
+}
+
+// diff The pprof of the profile with the base subtracted, the error response is written if ok is false
+func (s *APIServer) diff(c *gin.Context) ([]byte, bool) {
+	base, p, ok := s.getDiffProfiles(c)
+	if !ok {
+		return nil, false
+	}
+
+	base.SetLabel("pprof::base", []string{"true"})
+	base.Scale(-1)
+	diff, err := profile.Merge([]*profile.Profile{p, base})
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+
+	b := &bytes.Buffer{}
+	if err = diff.Write(b); err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+	return b.Bytes(), true
+}
+
+// getDiffProfiles Get the base and the profile, the profile is normalized to the base if normalize is true
+func (s *APIServer) getDiffProfiles(c *gin.Context) (base, p *profile.Profile, ok bool) {
+	if base, ok = s.getPProfByID(c, c.Param("base_id")); !ok {
+		return nil, nil, false
+	}
+	if p, ok = s.getPProf(c); !ok {
+		return nil, nil, false
+	}
+	if !sameSampleTypes(base, p) {
+		c.String(http.StatusBadRequest, "the sample types of the profiles are different")
+		return nil, nil, false
+	}
+	if normalize, _ := strconv.ParseBool(c.Query("normalize")); normalize {
+		if err := p.Normalize(base); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return nil, nil, false
+		}
+	}
+	return base, p, true
+}
+
+// FunctionDelta The values of a function in the base and the profile
+type FunctionDelta struct {
+	Name      string `json:"name"`
+	BaseFlat  int64  `json:"base_flat"`
+	BaseCum   int64  `json:"base_cum"`
+	Flat      int64  `json:"flat"`
+	Cum       int64  `json:"cum"`
+	FlatDelta int64  `json:"flat_delta"`
+	CumDelta  int64  `json:"cum_delta"`
+}
+
+// diffFunctions The functions with the biggest positive and negative deltas
+// Query parameters: sample_index, normalize, sort (flat or cum, default flat), limit (default 20)
+func (s *APIServer) diffFunctions(c *gin.Context) {
+	base, p, ok := s.getDiffProfiles(c)
+	if !ok {
+		return
+	}
+
+	sampleIndex, err := parseSampleIndex(p, c.Query("sample_index"))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	limit := defaultDiffLimit
+	if v := c.Query("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			c.String(http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+	}
+	sortBy := c.DefaultQuery("sort", "flat")
+	if sortBy != "flat" && sortBy != "cum" {
+		c.String(http.StatusBadRequest, "sort must be flat or cum")
+		return
+	}
+
+	deltas := make(map[string]*FunctionDelta)
+	get := func(name string) *FunctionDelta {
+		d, ok := deltas[name]
+		if !ok {
+			d = &FunctionDelta{Name: name}
+			deltas[name] = d
+		}
+		return d
+	}
+	for name, f := range analysis.Functions(base, sampleIndex) {
+		d := get(name)
+		d.BaseFlat, d.BaseCum = f.Flat, f.Cum
+	}
+	for name, f := range analysis.Functions(p, sampleIndex) {
+		d := get(name)
+		d.Flat, d.Cum = f.Flat, f.Cum
+	}
+
+	increased := make([]*FunctionDelta, 0)
+	decreased := make([]*FunctionDelta, 0)
+	for _, d := range deltas {
+		d.FlatDelta, d.CumDelta = d.Flat-d.BaseFlat, d.Cum-d.BaseCum
+		delta := d.FlatDelta
+		if sortBy == "cum" {
+			delta = d.CumDelta
+		}
+		if delta > 0 {
+			increased = append(increased, d)
+		} else if delta < 0 {
+			decreased = append(decreased, d)
+		}
+	}
+	value := func(d *FunctionDelta) int64 {
+		if sortBy == "cum" {
+			return d.CumDelta
+		}
+		return d.FlatDelta
+	}
+	sort.Slice(increased, func(i, j int) bool {
+		if value(increased[i]) != value(increased[j]) {
+			return value(increased[i]) > value(increased[j])
+		}
+		return increased[i].Name < increased[j].Name
+	})
+	sort.Slice(decreased, func(i, j int) bool {
+		if value(decreased[i]) != value(decreased[j]) {
+			return value(decreased[i]) < value(decreased[j])
+		}
+		return decreased[i].Name < decreased[j].Name
+	})
+	if len(increased) > limit {
+		increased = increased[:limit]
+	}
+	if len(decreased) > limit {
+		decreased = decreased[:limit]
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sample_type": p.SampleType[sampleIndex].Type,
+		"unit":        p.SampleType[sampleIndex].Unit,
+		"base_total":  total(base, sampleIndex),
+		"total":       total(p, sampleIndex),
+		"increased":   increased,
+		"decreased":   decreased,
+	})
+}
+
+func total(p *profile.Profile, sampleIndex int) int64 {
+	var v int64
+	for _, s := range p.Sample {
+		v += s.Value[sampleIndex]
+	}
+	return v
+}
+
+func sameSampleTypes(p1, p2 *profile.Profile) bool {
+	if len(p1.SampleType) != len(p2.SampleType) {
+		return false
+	}
+	for i := range p1.SampleType {
+		if p1.SampleType[i].Type != p2.SampleType[i].Type || p1.SampleType[i].Unit != p2.SampleType[i].Unit {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,129 @@
+package apiserver
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gavv/httpexpect/v2"
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+	"github.com/xyctruth/profiler/pkg/format"
+	"github.com/xyctruth/profiler/pkg/storage"
+	"github.com/xyctruth/profiler/pkg/storage/badger"
+)
+
+// saveFolded Save the folded stacks as a cpu profile, returns the profile id
+func saveFolded(t *testing.T, s storage.Store, folded string) string {
+	p, err := format.ParseFolded(strings.NewReader(folded),
+		&profile.ValueType{Type: "samples", Unit: "count"}, &profile.ValueType{Type: "cpu", Unit: "nanoseconds"})
+	require.NoError(t, err)
+	b := &bytes.Buffer{}
+	require.NoError(t, p.Write(b))
+	id, err := s.SaveProfile("checkout-profile", b.Bytes(), time.Hour)
+	require.NoError(t, err)
+	return id
+}
+
+func TestDiffProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	s := badger.NewStore(badger.DefaultOptions(dir))
+	defer s.Release()
+
+	baseID := saveFolded(t, s, "main;foo 1 100\nmain;bar 1 100\nmain;baz 1 50\n")
+	id := saveFolded(t, s, "main;foo 1 300\nmain;bar 1 20\nmain;qux 1 10\n")
+	_, _, heapID, traceID := initProfileData(s, t)
+
+	e := getExpect(NewAPIServer(DefaultOptions(s)), t)
+
+	// GET does not save the diff profile
+	data := e.GET(fmt.Sprintf("/api/diff/%s/%s", baseID, id)).
+		Expect().
+		Status(http.StatusOK).Body().Raw()
+	requireDiff(t, []byte(data))
+	lastID, err := strconv.Atoi(traceID)
+	require.NoError(t, err)
+	nextID := strconv.Itoa(lastID + 1)
+	_, _, err = s.GetProfile(nextID)
+	require.ErrorIs(t, err, storage.ErrProfileNotFound)
+
+	diff := e.POST(fmt.Sprintf("/api/diff/%s/%s", baseID, id)).
+		Expect().
+		Status(http.StatusOK).JSON().Object()
+	diffID := diff.Value("profile_id").String().Raw()
+	require.Equal(t, nextID, diffID)
+	diff.Value("pprof_ui").Equal("/api/pprof/ui/" + diffID)
+	_, saved, err := s.GetProfile(diffID)
+	require.NoError(t, err)
+	requireDiff(t, saved)
+
+	e.POST(fmt.Sprintf("/api/diff/%s/%s/ui", baseID, id)).
+		WithRedirectPolicy(httpexpect.DontFollowRedirects).
+		Expect().
+		Status(http.StatusSeeOther).Header("Location").Match(`^/api/pprof/ui/\d+$`)
+	e.GET(fmt.Sprintf("/api/diff/%s/%s/ui", baseID, id)).
+		WithRedirectPolicy(httpexpect.DontFollowRedirects).
+		Expect().
+		Status(http.StatusSeeOther).Header("Location").Match(`^/api/pprof/ui/\d+$`)
+
+	functions := e.GET(fmt.Sprintf("/api/diff/%s/%s/functions", baseID, id)).
+		Expect().
+		Status(http.StatusOK).JSON().Object()
+	functions.Value("sample_type").Equal("cpu")
+	functions.Value("base_total").Equal(250)
+	functions.Value("total").Equal(330)
+	functions.Path("$.increased[*].name").Equal([]string{"foo", "qux"})
+	functions.Path("$.increased[0].flat_delta").Equal(200)
+	functions.Path("$.decreased[*].name").Equal([]string{"bar", "baz"})
+	functions.Path("$.decreased[0].base_flat").Equal(100)
+	functions.Path("$.decreased[0].flat").Equal(20)
+
+	functions = e.GET(fmt.Sprintf("/api/diff/%s/%s/functions", baseID, id)).
+		WithQuery("sort", "cum").WithQuery("limit", 1).WithQuery("sample_index", "samples").
+		Expect().
+		Status(http.StatusOK).JSON().Object()
+	functions.Path("$.increased[*].name").Equal([]string{"qux"})
+	functions.Path("$.decreased[*].name").Equal([]string{"baz"})
+
+	// the profile is scaled to the base total
+	e.GET(fmt.Sprintf("/api/diff/%s/%s/functions", baseID, id)).WithQuery("normalize", true).
+		Expect().
+		Status(http.StatusOK).JSON().Object().Value("total").Equal(250)
+
+	e.GET(fmt.Sprintf("/api/diff/%s/%s/functions", baseID, id)).WithQuery("sort", "self").
+		Expect().
+		Status(http.StatusBadRequest)
+	e.GET(fmt.Sprintf("/api/diff/%s/%s", baseID, heapID)).
+		Expect().
+		Status(http.StatusBadRequest)
+	e.GET(fmt.Sprintf("/api/diff/%s/%s", baseID, traceID)).
+		Expect().
+		Status(http.StatusBadRequest)
+	e.GET(fmt.Sprintf("/api/diff/%s/999", baseID)).
+		Expect().
+		Status(http.StatusNotFound)
+}
+
+// requireDiff The diff profile has the base samples of -250 and the samples of 330
+func requireDiff(t *testing.T, data []byte) {
+	p, err := profile.ParseData(data)
+	require.NoError(t, err)
+	var base, value int64
+	for _, sample := range p.Sample {
+		if sample.DiffBaseSample() {
+			base += sample.Value[1]
+		} else {
+			value += sample.Value[1]
+		}
+	}
+	require.Equal(t, int64(-250), base)
+	require.Equal(t, int64(330), value)
+}
@@ -0,0 +1,56 @@
+package apiserver
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xyctruth/profiler/pkg/format"
+)
+
+// defaultNodeFraction The nodes less than 0.5% of the total are pruned, same as go tool pprof
+const defaultNodeFraction = 0.005
+
+// flamegraph The flame graph json of a pprof
+// Query parameters: sample_index (index or sample type, default the last sample type), focus and ignore
+// (regexps of the frame names), node_fraction (default 0.005)
+func (s *APIServer) flamegraph(c *gin.Context) {
+	p, ok := s.getPProf(c)
+	if !ok {
+		return
+	}
+
+	sampleIndex, err := parseSampleIndex(p, c.Query("sample_index"))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	nodeFraction := defaultNodeFraction
+	if v := c.Query("node_fraction"); v != "" {
+		if nodeFraction, err = strconv.ParseFloat(v, 64); err != nil || nodeFraction < 0 || nodeFraction > 1 {
+			c.String(http.StatusBadRequest, "node_fraction must be between 0 and 1")
+			return
+		}
+	}
+
+	var focus, ignore *regexp.Regexp
+	if v := c.Query("focus"); v != "" {
+		if focus, err = regexp.Compile(v); err != nil {
+			c.String(http.StatusBadRequest, "invalid focus: %s", err.Error())
+			return
+		}
+	}
+	if v := c.Query("ignore"); v != "" {
+		if ignore, err = regexp.Compile(v); err != nil {
+			c.String(http.StatusBadRequest, "invalid ignore: %s", err.Error())
+			return
+		}
+	}
+	if focus != nil || ignore != nil {
+		p.FilterSamplesByName(focus, ignore, nil, nil)
+	}
+
+	c.JSON(http.StatusOK, format.NewFlameGraph(p, sampleIndex, nodeFraction))
+}
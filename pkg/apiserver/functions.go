@@ -0,0 +1,150 @@
+package apiserver
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/pprof/profile"
+	"github.com/xyctruth/profiler/pkg/analysis"
+	"github.com/xyctruth/profiler/pkg/storage"
+)
+
+// maxFunctionMatches Max number of the functions matching the regex in a profile
+const maxFunctionMatches = 100
+
+// errTooManyFunctions More than maxFunctionMatches functions match the regex, the regex should be narrowed
+var errTooManyFunctions = fmt.Errorf("more than %d functions match the regex", maxFunctionMatches)
+
+// FunctionSeries The function values of the profiles of a target instance
+type FunctionSeries struct {
+	Key    string           `json:"key"`
+	Points []*FunctionPoint `json:"points"`
+}
+
+// FunctionPoint The values of the matched functions in a profile, sorted by name
+type FunctionPoint struct {
+	Timestamp int64                     `json:"timestamp"`
+	ProfileID string                    `json:"profile_id"`
+	Functions []*analysis.FunctionValue `json:"functions"`
+}
+
+// listFunctions The flat and cum values of the functions matching the regex in the profiles of the sample type,
+// the values are read from the function summaries saved with the profiles
+// Query parameters: regex, start_time, end_time, labels[] same as the profile metas
+func (s *APIServer) listFunctions(c *gin.Context) {
+	sampleType := c.Param("sample_type")
+	if c.Query("regex") == "" {
+		c.String(http.StatusBadRequest, "regex is empty")
+		return
+	}
+	re, err := regexp.Compile(c.Query("regex"))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	startTime, endTime, filters, ok := parseMetaQuery(c)
+	if !ok {
+		return
+	}
+
+	metaByTargets, err := s.store.ListProfileMeta(sampleType, startTime, endTime, filters...)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	series := make([]*FunctionSeries, 0, len(metaByTargets))
+	for _, target := range metaByTargets {
+		points := make([]*FunctionPoint, 0, len(target.ProfileMetas))
+		for _, meta := range target.ProfileMetas {
+			functions, err := s.functionValues(meta.ProfileID, sampleType, re)
+			if err != nil {
+				switch {
+				case errors.Is(err, errTooManyFunctions):
+					c.String(http.StatusBadRequest, err.Error())
+				case errors.Is(err, storage.ErrProfileNotFound):
+					c.String(http.StatusNotFound, err.Error())
+				default:
+					c.String(http.StatusInternalServerError, err.Error())
+				}
+				return
+			}
+			points = append(points, &FunctionPoint{
+				Timestamp: meta.Timestamp,
+				ProfileID: meta.ProfileID,
+				Functions: functions,
+			})
+		}
+		sort.Slice(points, func(i, j int) bool {
+			return points[i].Timestamp < points[j].Timestamp
+		})
+		series = append(series, &FunctionSeries{Key: target.Key, Points: points})
+	}
+	sort.Slice(series, func(i, j int) bool {
+		return series[i].Key < series[j].Key
+	})
+	c.JSON(http.StatusOK, series)
+}
+
+// functionValues The values of the matched functions of a profile, the profiles saved without a function summary
+// are parsed
+func (s *APIServer) functionValues(profileID, sampleType string, re *regexp.Regexp) ([]*analysis.FunctionValue, error) {
+	summary, err := s.store.GetFunctionSummary(profileID)
+	if errors.Is(err, storage.ErrFunctionSummaryNotFound) {
+		return s.parseFunctionValues(profileID, sampleType, re)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]*analysis.FunctionValue, 0)
+	i := summary.SampleTypeIndex(sampleType)
+	if i < 0 {
+		return values, nil
+	}
+	for j, name := range summary.Functions {
+		if !re.MatchString(name) {
+			continue
+		}
+		if len(values) == maxFunctionMatches {
+			return nil, fmt.Errorf("%w in profile %s", errTooManyFunctions, profileID)
+		}
+		values = append(values, &analysis.FunctionValue{Name: name, Flat: summary.Flat[i][j], Cum: summary.Cum[i][j]})
+	}
+	return values, nil
+}
+
+func (s *APIServer) parseFunctionValues(profileID, sampleType string, re *regexp.Regexp) ([]*analysis.FunctionValue, error) {
+	_, data, err := s.store.GetProfile(profileID)
+	if err != nil {
+		return nil, fmt.Errorf("get profile %s: %w", profileID, err)
+	}
+	p, err := profile.ParseData(data)
+	if err != nil {
+		return nil, fmt.Errorf("profile %s is not a pprof: %w", profileID, err)
+	}
+	sampleIndex := 0
+	if len(p.SampleType) > 1 {
+		if sampleIndex, err = parseSampleIndex(p, sampleType); err != nil {
+			return nil, err
+		}
+	}
+
+	values := make([]*analysis.FunctionValue, 0)
+	for name, f := range analysis.Functions(p, sampleIndex) {
+		if re.MatchString(name) {
+			values = append(values, f)
+		}
+	}
+	if len(values) > maxFunctionMatches {
+		return nil, fmt.Errorf("%w in profile %s", errTooManyFunctions, profileID)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return values[i].Name < values[j].Name
+	})
+	return values, nil
+}
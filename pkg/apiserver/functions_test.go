@@ -0,0 +1,109 @@
+package apiserver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xyctruth/profiler/pkg/storage"
+	"github.com/xyctruth/profiler/pkg/storage/badger"
+)
+
+func TestListFunctions(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	s := badger.NewStore(badger.DefaultOptions(dir))
+	defer s.Release()
+
+	saveCPUProfile(t, s, "pod-0", 10, 10*time.Second)
+	saveCPUProfile(t, s, "pod-0", 20, 10*time.Second)
+	saveCPUProfile(t, s, "pod-1", 30, 10*time.Second)
+
+	// A profile saved without the function summary is parsed
+	legacyID := saveFolded(t, s, "main;foo 1 40\nmain;bar 1 50\n")
+	require.NoError(t, s.SaveProfileMeta([]*storage.ProfileMeta{{
+		ProfileID:  legacyID,
+		SampleType: "profile_cpu",
+		TargetName: "checkout",
+		Instance:   "pod-2",
+		Timestamp:  time.Now().UnixNano() / time.Millisecond.Nanoseconds(),
+		Labels:     []storage.Label{{Key: "env", Value: "prod"}},
+	}}, time.Hour))
+
+	e := getExpect(NewAPIServer(DefaultOptions(s)), t)
+	startTime := time.Now().Add(-1 * time.Minute).Format(time.RFC3339)
+	endTime := time.Now().Add(time.Minute).Format(time.RFC3339)
+
+	series := e.GET("/api/functions/profile_cpu").
+		WithQuery("start_time", startTime).WithQuery("end_time", endTime).
+		WithQuery("labels[]", `{"Key":"env","Value":"prod"}`).
+		WithQuery("regex", "^(foo|main)$").
+		Expect().
+		Status(http.StatusOK).JSON().Array()
+	series.Length().Equal(3)
+	series.Element(0).Object().Value("key").Equal("checkout/pod-0")
+	points := series.Element(0).Object().Value("points").Array()
+	points.Length().Equal(2)
+	points.Element(0).Object().Value("functions").Equal([]map[string]interface{}{
+		{"name": "foo", "flat": 10, "cum": 10},
+		{"name": "main", "flat": 0, "cum": 10},
+	})
+	points.Element(1).Object().Value("functions").Array().Element(0).Object().Value("flat").Equal(20)
+
+	legacy := series.Element(2).Object().Value("points").Array().Element(0).Object()
+	legacy.Value("profile_id").Equal(legacyID)
+	legacy.Value("functions").Equal([]map[string]interface{}{
+		{"name": "foo", "flat": 40, "cum": 40},
+		{"name": "main", "flat": 0, "cum": 90},
+	})
+
+	e.GET("/api/functions/profile_cpu").
+		WithQuery("start_time", startTime).WithQuery("end_time", endTime).
+		WithQuery("regex", "nothing").
+		Expect().
+		Status(http.StatusOK).JSON().Array().Element(0).Object().
+		Value("points").Array().Element(0).Object().Value("functions").Array().Empty()
+
+	e.GET("/api/functions/profile_cpu").
+		WithQuery("start_time", startTime).WithQuery("end_time", endTime).
+		Expect().
+		Status(http.StatusBadRequest)
+	e.GET("/api/functions/profile_cpu").
+		WithQuery("start_time", startTime).WithQuery("end_time", endTime).
+		WithQuery("regex", "(").
+		Expect().
+		Status(http.StatusBadRequest)
+
+	// the store errors are not client errors
+	invalidID, err := s.SaveProfile("checkout-profile", []byte("invalid"), time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, s.SaveProfileMeta([]*storage.ProfileMeta{{
+		ProfileID:  invalidID,
+		SampleType: "profile_samples",
+		TargetName: "checkout",
+		Instance:   "pod-3",
+		Timestamp:  time.Now().UnixNano() / time.Millisecond.Nanoseconds(),
+	}}, time.Hour))
+	e.GET("/api/functions/profile_samples").
+		WithQuery("start_time", startTime).WithQuery("end_time", endTime).
+		WithQuery("regex", "foo").
+		Expect().
+		Status(http.StatusInternalServerError)
+
+	require.NoError(t, s.SaveProfileMeta([]*storage.ProfileMeta{{
+		ProfileID:  "999",
+		SampleType: "profile_missing",
+		TargetName: "checkout",
+		Instance:   "pod-3",
+		Timestamp:  time.Now().UnixNano() / time.Millisecond.Nanoseconds(),
+	}}, time.Hour))
+	e.GET("/api/functions/profile_missing").
+		WithQuery("start_time", startTime).WithQuery("end_time", endTime).
+		WithQuery("regex", "foo").
+		Expect().
+		Status(http.StatusNotFound)
+}
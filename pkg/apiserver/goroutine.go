@@ -0,0 +1,157 @@
+package apiserver
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/pprof/profile"
+	"github.com/xyctruth/profiler/pkg/analysis"
+	"github.com/xyctruth/profiler/pkg/storage"
+)
+
+// defaultGoroutineLeakWindow The time range of the goroutine profiles checked for leaks
+const defaultGoroutineLeakWindow = time.Hour
+
+// minGoroutineLeakCoverage The goroutine profiles of an instance must cover the fraction of the window,
+// so that the goroutines of the instances started recently are not reported
+const minGoroutineLeakCoverage = 0.75
+
+// GoroutineLeaks The stacks of a target instance whose goroutine counts increased monotonically over the window
+type GoroutineLeaks struct {
+	Key          string                    `json:"key"`
+	Target       string                    `json:"target"`
+	Instance     string                    `json:"instance"`
+	Labels       []storage.Label           `json:"labels"`
+	ProfileCount int                       `json:"profile_count"`
+	Leaks        []*analysis.GoroutineLeak `json:"leaks"`
+}
+
+// goroutineLeaks Group the goroutines of the goroutine profiles of the target by stack and report the stacks whose
+// counts never decreased over the window, the profiles are sampled evenly if there are more than MaxMergeProfiles
+// Query parameters: target, window (e.g. 6h, 1d, default 1h), min_profiles (default 3), min_increase (default 1),
+// limit of the stacks of each instance (default 20), labels[] the profiles must have all the labels
+func (s *APIServer) goroutineLeaks(c *gin.Context) {
+	target := c.Query("target")
+	if target == "" {
+		c.String(http.StatusBadRequest, "target is empty")
+		return
+	}
+	window := defaultGoroutineLeakWindow
+	if v := c.Query("window"); v != "" {
+		var err error
+		if window, err = parseRange(v); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	minProfiles, ok := parsePositiveInt(c, "min_profiles", 3)
+	if !ok {
+		return
+	}
+	minIncrease, ok := parsePositiveInt(c, "min_increase", 1)
+	if !ok {
+		return
+	}
+	limit, ok := parsePositiveInt(c, "limit", 20)
+	if !ok {
+		return
+	}
+	req := struct {
+		Filters []storage.LabelFilter `json:"labels[]" form:"labels[]"`
+	}{}
+	if err := c.ShouldBind(&req); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	endTime := time.Now()
+	startTime := endTime.Add(-window)
+	metaByTargets, err := s.store.ListProfileMeta(analysis.GoroutineSampleType, startTime, endTime, req.Filters...)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	res := make([]*GoroutineLeaks, 0)
+	for _, instance := range metaByTargets {
+		metas := make([]*storage.ProfileMeta, 0, len(instance.ProfileMetas))
+		for _, meta := range instance.ProfileMetas {
+			// the metas are indexed by the saving time, the pushed profiles can be older
+			if meta.TargetName == target && meta.Timestamp >= startTime.UnixNano()/time.Millisecond.Nanoseconds() && hasLabels(meta, req.Filters) {
+				metas = append(metas, meta)
+			}
+		}
+		if len(metas) < minProfiles {
+			continue
+		}
+		metas = sampleMetas(metas, s.opt.MaxMergeProfiles)
+		sort.Slice(metas, func(i, j int) bool {
+			return metas[i].Timestamp < metas[j].Timestamp
+		})
+		span := time.Duration(metas[len(metas)-1].Timestamp-metas[0].Timestamp) * time.Millisecond
+		if span < time.Duration(float64(window)*minGoroutineLeakCoverage) {
+			continue
+		}
+
+		snapshots := make([]*analysis.GoroutineSnapshot, 0, len(metas))
+		for _, meta := range metas {
+			p, err := s.loadGoroutineProfile(meta.ProfileID)
+			if err != nil {
+				c.String(http.StatusInternalServerError, err.Error())
+				return
+			}
+			snapshots = append(snapshots, analysis.NewGoroutineSnapshot(meta.ProfileID, meta.Timestamp, p))
+		}
+		leaks := analysis.GoroutineLeaks(snapshots, int64(minIncrease))
+		if len(leaks) == 0 {
+			continue
+		}
+		if len(leaks) > limit {
+			leaks = leaks[:limit]
+		}
+		last := metas[len(metas)-1]
+		res = append(res, &GoroutineLeaks{
+			Key:          instance.Key,
+			Target:       last.TargetName,
+			Instance:     last.Instance,
+			Labels:       last.Labels,
+			ProfileCount: len(metas),
+			Leaks:        leaks,
+		})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Key < res[j].Key
+	})
+	c.JSON(http.StatusOK, res)
+}
+
+// loadGoroutineProfile Get and parse the goroutine profile of the id
+func (s *APIServer) loadGoroutineProfile(profileID string) (*profile.Profile, error) {
+	_, data, err := s.store.GetProfile(profileID)
+	if err != nil {
+		return nil, fmt.Errorf("get profile %s: %w", profileID, err)
+	}
+	p, err := profile.ParseData(data)
+	if err != nil {
+		return nil, fmt.Errorf("profile %s is not a pprof: %w", profileID, err)
+	}
+	return p, nil
+}
+
+// parsePositiveInt The positive integer query parameter, defaultValue if it is empty
+func parsePositiveInt(c *gin.Context, name string, defaultValue int) (int, bool) {
+	value := c.Query(name)
+	if value == "" {
+		return defaultValue, true
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil || i <= 0 {
+		c.String(http.StatusBadRequest, "%s must be a positive integer", name)
+		return 0, false
+	}
+	return i, true
+}
@@ -0,0 +1,104 @@
+package apiserver
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+	"github.com/xyctruth/profiler/pkg/analysis"
+	"github.com/xyctruth/profiler/pkg/format"
+	"github.com/xyctruth/profiler/pkg/storage"
+	"github.com/xyctruth/profiler/pkg/storage/badger"
+)
+
+func saveGoroutineProfile(t *testing.T, s storage.Store, instance, folded string, at time.Time) string {
+	p, err := format.ParseFolded(strings.NewReader(folded), &profile.ValueType{Type: "goroutine", Unit: "count"})
+	require.NoError(t, err)
+	b := &bytes.Buffer{}
+	require.NoError(t, p.Write(b))
+	id, err := analysis.Save(s, analysis.Profile{
+		TargetName:  "checkout",
+		Instance:    instance,
+		ProfileType: "goroutine",
+		Labels:      []storage.Label{{Key: "env", Value: "prod"}},
+		Data:        b.Bytes(),
+		Time:        at,
+	}, time.Hour)
+	require.NoError(t, err)
+	return id
+}
+
+func TestGoroutineLeaks(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	s := badger.NewStore(badger.DefaultOptions(dir))
+	defer s.Release()
+
+	now := time.Now()
+	ids := make([]string, 0)
+	for i, count := range []string{"1", "5", "5", "9"} {
+		ids = append(ids, saveGoroutineProfile(t, s, "pod-0",
+			"main;worker;chanrecv "+count+"\nmain;serve;accept 1", now.Add(time.Duration(i-3)*15*time.Minute)))
+	}
+	// the goroutines of pod-1 exit
+	for i, count := range []string{"1", "5", "2", "9"} {
+		saveGoroutineProfile(t, s, "pod-1", "main;worker;chanrecv "+count, now.Add(time.Duration(i-3)*15*time.Minute))
+	}
+	// pod-2 started recently
+	for i, count := range []string{"1", "5", "9"} {
+		saveGoroutineProfile(t, s, "pod-2", "main;worker;chanrecv "+count, now.Add(time.Duration(i-2)*2*time.Minute))
+	}
+
+	// the index time of the metas is in seconds
+	time.Sleep(time.Second)
+
+	e := getExpect(NewAPIServer(DefaultOptions(s)), t)
+
+	leaks := e.GET("/api/analysis/goroutine-leaks").
+		WithQuery("target", "checkout").
+		WithQuery("window", "1h").
+		WithQuery("labels[]", `{"Key":"env","Value":"prod"}`).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Array()
+	leaks.Length().Equal(1)
+	leak := leaks.Element(0).Object()
+	leak.Value("key").Equal("checkout/pod-0")
+	leak.Value("instance").Equal("pod-0")
+	leak.Value("profile_count").Equal(4)
+	leak.Value("leaks").Array().Length().Equal(1)
+	stack := leak.Value("leaks").Array().Element(0).Object()
+	stack.Value("stack").Equal([]string{"main", "worker", "chanrecv"})
+	stack.Value("initial_count").Equal(1)
+	stack.Value("count").Equal(9)
+	stack.Value("growth_rate").Number().InDelta(8/0.75, 0.01)
+	stack.Value("profile_ids").Equal([]string{ids[0], ids[3]})
+
+	e.GET("/api/analysis/goroutine-leaks").
+		WithQuery("target", "checkout").
+		WithQuery("min_increase", "10").
+		Expect().
+		Status(http.StatusOK).
+		JSON().Array().Empty()
+	e.GET("/api/analysis/goroutine-leaks").
+		WithQuery("target", "checkout").
+		WithQuery("window", "5m").
+		Expect().
+		Status(http.StatusOK).
+		JSON().Array().Element(0).Object().Value("instance").Equal("pod-2")
+	e.GET("/api/analysis/goroutine-leaks").
+		Expect().
+		Status(http.StatusBadRequest)
+	e.GET("/api/analysis/goroutine-leaks").
+		WithQuery("target", "checkout").
+		WithQuery("min_profiles", "0").
+		Expect().
+		Status(http.StatusBadRequest)
+}
@@ -0,0 +1,130 @@
+package apiserver
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xyctruth/profiler/pkg/storage"
+)
+
+// defaultGoroutineLimit Max number of the goroutines returned by default
+const defaultGoroutineLimit = 100
+
+// GoroutineDump The goroutines of a goroutine dump matching the filters
+type GoroutineDump struct {
+	ProfileID string `json:"profile_id"`
+	// Total The goroutines of the dump
+	Total int `json:"total"`
+	// Matched The goroutines matching the filters, more than the returned goroutines if they are limited
+	Matched int `json:"matched"`
+	// States The counts of the matched goroutines by state
+	States     map[string]int       `json:"states"`
+	Goroutines []*storage.Goroutine `json:"goroutines"`
+}
+
+// goroutineDump The goroutines of a goroutine dump profile
+// Query parameters: state, min_wait the minimum wait minutes, function regex of the frames or the go statement,
+// limit (default 100), format json (default) or text like the dump
+func (s *APIServer) goroutineDump(c *gin.Context) {
+	id := c.Param("id")
+	var minWait int64
+	if v := c.Query("min_wait"); v != "" {
+		var err error
+		if minWait, err = strconv.ParseInt(v, 10, 64); err != nil || minWait < 0 {
+			c.String(http.StatusBadRequest, "min_wait must be a non-negative integer")
+			return
+		}
+	}
+	var function *regexp.Regexp
+	if v := c.Query("function"); v != "" {
+		var err error
+		if function, err = regexp.Compile(v); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	limit, ok := parsePositiveInt(c, "limit", defaultGoroutineLimit)
+	if !ok {
+		return
+	}
+	state := c.Query("state")
+
+	dump, err := s.store.GetGoroutineDump(id)
+	if err != nil {
+		if errors.Is(err, storage.ErrGoroutineDumpNotFound) {
+			c.String(http.StatusNotFound, "Goroutine dump not found")
+			return
+		}
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	res := &GoroutineDump{
+		ProfileID:  id,
+		Total:      len(dump.Goroutines),
+		States:     make(map[string]int),
+		Goroutines: make([]*storage.Goroutine, 0),
+	}
+	for _, g := range dump.Goroutines {
+		if (state != "" && g.State != state) || g.WaitMinutes < minWait || (function != nil && !matchGoroutine(g, function)) {
+			continue
+		}
+		res.Matched++
+		res.States[g.State]++
+		if len(res.Goroutines) < limit {
+			res.Goroutines = append(res.Goroutines, g)
+		}
+	}
+
+	switch c.Query("format") {
+	case "", "json":
+		c.JSON(http.StatusOK, res)
+	case "text":
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(writeGoroutines(res.Goroutines)))
+	default:
+		c.String(http.StatusBadRequest, "unsupported format %s", c.Query("format"))
+	}
+}
+
+func matchGoroutine(g *storage.Goroutine, function *regexp.Regexp) bool {
+	for _, frame := range g.Stack {
+		if function.MatchString(frame.Function) {
+			return true
+		}
+	}
+	return g.CreatedBy != nil && function.MatchString(g.CreatedBy.Function)
+}
+
+// writeGoroutines Write the goroutines like the dump, the arguments are elided
+func writeGoroutines(goroutines []*storage.Goroutine) string {
+	b := &strings.Builder{}
+	for i, g := range goroutines {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		status := g.State
+		if g.WaitMinutes > 0 {
+			status += fmt.Sprintf(", %d minutes", g.WaitMinutes)
+		}
+		if g.LockedToThread {
+			status += ", locked to thread"
+		}
+		fmt.Fprintf(b, "goroutine %d [%s]:\n", g.ID, status)
+		for _, frame := range g.Stack {
+			fmt.Fprintf(b, "%s(...)\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		}
+		if g.CreatedBy != nil {
+			fmt.Fprintf(b, "created by %s", g.CreatedBy.Function)
+			if g.CreatorID > 0 {
+				fmt.Fprintf(b, " in goroutine %d", g.CreatorID)
+			}
+			fmt.Fprintf(b, "\n\t%s:%d\n", g.CreatedBy.File, g.CreatedBy.Line)
+		}
+	}
+	return b.String()
+}
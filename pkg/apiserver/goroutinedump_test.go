@@ -0,0 +1,95 @@
+package apiserver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xyctruth/profiler/pkg/analysis"
+	"github.com/xyctruth/profiler/pkg/storage/badger"
+)
+
+func TestGoroutineDump(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	s := badger.NewStore(badger.DefaultOptions(dir))
+	defer s.Release()
+
+	data, err := ioutil.ReadFile("./testdata/goroutine.debug2.testdata")
+	require.NoError(t, err)
+	id, err := analysis.Save(s, analysis.Profile{
+		TargetName:  "server",
+		Instance:    "localhost:9000",
+		ProfileType: analysis.GoroutineDumpProfileType,
+		Data:        data,
+	}, time.Hour)
+	require.NoError(t, err)
+
+	e := getExpect(NewAPIServer(DefaultOptions(s)), t)
+
+	dump := e.GET(fmt.Sprintf("/api/profile/%s/goroutines", id)).
+		Expect().
+		Status(http.StatusOK).JSON().Object()
+	dump.Value("total").Equal(6)
+	dump.Value("matched").Equal(6)
+	dump.Value("states").Object().Value("chan receive").Equal(2)
+	dump.Value("goroutines").Array().Length().Equal(6)
+
+	dump = e.GET(fmt.Sprintf("/api/profile/%s/goroutines", id)).
+		WithQuery("state", "chan receive").
+		WithQuery("min_wait", 10).
+		Expect().
+		Status(http.StatusOK).JSON().Object()
+	dump.Value("matched").Equal(1)
+	goroutine := dump.Value("goroutines").Array().Element(0).Object()
+	goroutine.Value("id").Equal(7)
+	goroutine.Value("wait_minutes").Equal(12)
+	goroutine.Value("created_by").Object().Value("function").Equal("main.main")
+
+	dump = e.GET(fmt.Sprintf("/api/profile/%s/goroutines", id)).
+		WithQuery("function", `^sync\.`).
+		Expect().
+		Status(http.StatusOK).JSON().Object()
+	dump.Value("matched").Equal(1)
+	dump.Value("states").Equal(map[string]int{"sync.Mutex.Lock": 1})
+
+	e.GET(fmt.Sprintf("/api/profile/%s/goroutines", id)).
+		WithQuery("function", `^main\.main$`).
+		WithQuery("limit", 1).
+		Expect().
+		Status(http.StatusOK).JSON().Object().
+		ValueEqual("matched", 6).
+		Value("goroutines").Array().Length().Equal(1)
+
+	e.GET(fmt.Sprintf("/api/profile/%s/goroutines", id)).
+		WithQuery("state", "sleep").
+		WithQuery("format", "text").
+		Expect().
+		Status(http.StatusOK).
+		Body().Equal("goroutine 10 [sleep, 59 minutes, locked to thread]:\n" +
+		"time.Sleep(...)\n\t/go/src/runtime/time.go:368\n" +
+		"main.main.func3(...)\n\t/app/main.go:21\n" +
+		"created by main.main in goroutine 1\n\t/app/main.go:21\n")
+
+	// the pprof of the goroutines grouped by state
+	e.GET(fmt.Sprintf("/api/download/%s", id)).
+		Expect().
+		Status(http.StatusOK)
+
+	e.GET(fmt.Sprintf("/api/profile/%s/goroutines", id)).
+		WithQuery("min_wait", -1).
+		Expect().
+		Status(http.StatusBadRequest)
+	e.GET(fmt.Sprintf("/api/profile/%s/goroutines", id)).
+		WithQuery("function", "(").
+		Expect().
+		Status(http.StatusBadRequest)
+	e.GET("/api/profile/999/goroutines").
+		Expect().
+		Status(http.StatusNotFound)
+}
@@ -0,0 +1,103 @@
+package apiserver
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/pprof/profile"
+	"github.com/xyctruth/profiler/pkg/analysis"
+	"github.com/xyctruth/profiler/pkg/format"
+	"github.com/xyctruth/profiler/pkg/storage"
+)
+
+// profileTypeRegexp The profile type is the prefix of the sample types, it can not contain _
+var profileTypeRegexp = regexp.MustCompile(`^[a-zA-Z0-9]+$`)
+
+// ingest Save a pushed pprof or go trace body
+// Query parameters: target, profile_type, instance (default client ip), labels (repeated key=value),
+// format (pprof or folded, default pprof), sample_type and unit of the folded stacks (default samples and count)
+func (s *APIServer) ingest(c *gin.Context) {
+	target := c.Query("target")
+	if target == "" {
+		c.String(http.StatusBadRequest, "target is empty")
+		return
+	}
+	profileType := c.Query("profile_type")
+	if !profileTypeRegexp.MatchString(profileType) {
+		c.String(http.StatusBadRequest, "profile_type must be alphanumeric")
+		return
+	}
+	instance := c.Query("instance")
+	if instance == "" {
+		instance = c.ClientIP()
+	}
+
+	labels := make([]storage.Label, 0)
+	for _, l := range c.QueryArray("labels") {
+		kv := strings.SplitN(l, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || strings.HasPrefix(kv[0], "_") {
+			c.String(http.StatusBadRequest, "invalid label %s, the format is key=value", l)
+			return
+		}
+		labels = append(labels, storage.Label{Key: kv[0], Value: kv[1]})
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, s.opt.MaxIngestSize+1))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if int64(len(data)) > s.opt.MaxIngestSize {
+		c.String(http.StatusRequestEntityTooLarge, "body is larger than %d bytes", s.opt.MaxIngestSize)
+		return
+	}
+	if len(data) == 0 {
+		c.String(http.StatusBadRequest, "body is empty")
+		return
+	}
+
+	switch c.DefaultQuery("format", "pprof") {
+	case "pprof":
+	case "folded":
+		// the perf and ebpf tools produce the collapsed stacks
+		sampleType := &profile.ValueType{Type: c.DefaultQuery("sample_type", "samples"), Unit: c.DefaultQuery("unit", "count")}
+		prof, err := format.ParseFolded(bytes.NewReader(data), sampleType)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		b := &bytes.Buffer{}
+		if err = prof.Write(b); err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		data = b.Bytes()
+	default:
+		c.String(http.StatusBadRequest, "unsupported format %s", c.Query("format"))
+		return
+	}
+
+	profileID, err := analysis.Save(s.store, analysis.Profile{
+		TargetName:  target,
+		Instance:    instance,
+		ProfileType: profileType,
+		Labels:      labels,
+		Data:        data,
+		Functions:   s.opt.IngestFunctions,
+	}, s.opt.IngestExpiration)
+	if err != nil {
+		if errors.Is(err, analysis.ErrInvalidProfile) {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"profile_id": profileID})
+}
@@ -0,0 +1,120 @@
+package apiserver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xyctruth/profiler/pkg/storage/badger"
+)
+
+func TestIngest(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	s := badger.NewStore(badger.DefaultOptions(dir))
+	defer s.Release()
+
+	profileBytes, err := ioutil.ReadFile("./testdata/profile.out.testdata")
+	require.Equal(t, nil, err)
+	traceBytes, err := ioutil.ReadFile("./testdata/trace.out.testdata")
+	require.Equal(t, nil, err)
+
+	apiServer := NewAPIServer(DefaultOptions(s).WithIngestExpiration(time.Hour).WithMaxIngestSize(int64(len(traceBytes))))
+	e := getExpect(apiServer, t)
+
+	id := e.POST("/api/ingest").
+		WithQuery("target", "batch-job").WithQuery("instance", "worker-1").WithQuery("profile_type", "heap").
+		WithQuery("labels", "env=prod").WithQuery("labels", "region=us").
+		WithBytes(profileBytes).
+		Expect().
+		Status(http.StatusOK).JSON().Object().Value("profile_id").String().Raw()
+
+	e.GET("/api/download/" + id).
+		Expect().
+		Status(http.StatusOK)
+	e.GET("/api/targets").
+		Expect().
+		Status(http.StatusOK).JSON().Array().Contains("batch-job")
+	e.GET("/api/group_labels").
+		Expect().
+		Status(http.StatusOK).JSON().Object().Keys().Contains("env", "region")
+
+	startTime := time.Now().Add(-1 * time.Minute).Format(time.RFC3339)
+	endTime := time.Now().Add(time.Minute).Format(time.RFC3339)
+	e.GET("/api/profile_meta/heap_inuse_space").
+		WithQuery("start_time", startTime).WithQuery("end_time", endTime).
+		Expect().
+		Status(http.StatusOK).JSON().Array().Element(0).Path("$.profile_metas[0].instance").Equal("worker-1")
+
+	// trace, instance is the client ip
+	e.POST("/api/ingest").
+		WithQuery("target", "batch-job").WithQuery("profile_type", "trace").
+		WithBytes(traceBytes).
+		Expect().
+		Status(http.StatusOK)
+
+	e.POST("/api/ingest").WithQuery("profile_type", "heap").WithBytes(profileBytes).
+		Expect().
+		Status(http.StatusBadRequest).Text().Equal("target is empty")
+	e.POST("/api/ingest").WithQuery("target", "batch-job").WithQuery("profile_type", "heap_inuse").WithBytes(profileBytes).
+		Expect().
+		Status(http.StatusBadRequest)
+	e.POST("/api/ingest").WithQuery("target", "batch-job").WithQuery("profile_type", "heap").WithQuery("labels", "env").WithBytes(profileBytes).
+		Expect().
+		Status(http.StatusBadRequest)
+	e.POST("/api/ingest").WithQuery("target", "batch-job").WithQuery("profile_type", "heap").
+		Expect().
+		Status(http.StatusBadRequest).Text().Equal("body is empty")
+	e.POST("/api/ingest").WithQuery("target", "batch-job").WithQuery("profile_type", "heap").WithBytes([]byte("invalid")).
+		Expect().
+		Status(http.StatusBadRequest).Text().Contains("invalid profile")
+	e.POST("/api/ingest").WithQuery("target", "batch-job").WithQuery("profile_type", "heap").WithBytes(append(traceBytes, 0)).
+		Expect().
+		Status(http.StatusRequestEntityTooLarge)
+}
+
+func TestIngestFolded(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	s := badger.NewStore(badger.DefaultOptions(dir))
+	defer s.Release()
+
+	e := getExpect(NewAPIServer(DefaultOptions(s)), t)
+
+	id := e.POST("/api/ingest").
+		WithQuery("target", "perf").WithQuery("instance", "node-1").WithQuery("profile_type", "offcpu").
+		WithQuery("format", "folded").WithQuery("sample_type", "time").WithQuery("unit", "nanoseconds").
+		WithBytes([]byte("main;read 100\nmain;write 50\nmain;read 10\n")).
+		Expect().
+		Status(http.StatusOK).JSON().Object().Value("profile_id").String().Raw()
+
+	e.GET("/api/download/"+id).WithQuery("format", "folded").
+		Expect().
+		Status(http.StatusOK).Body().Equal("main;read 110\nmain;write 50\n")
+
+	startTime := time.Now().Add(-1 * time.Minute).Format(time.RFC3339)
+	endTime := time.Now().Add(time.Minute).Format(time.RFC3339)
+	meta := e.GET("/api/profile_meta/offcpu").
+		WithQuery("start_time", startTime).WithQuery("end_time", endTime).
+		Expect().
+		Status(http.StatusOK).JSON().Array().Element(0).Path("$.profile_metas[0]").Object()
+	meta.Value("sample_type_unit").Equal("nanoseconds")
+	meta.Value("value").Equal(160)
+
+	e.POST("/api/ingest").
+		WithQuery("target", "perf").WithQuery("profile_type", "offcpu").WithQuery("format", "folded").
+		WithBytes([]byte("main;read x\n")).
+		Expect().
+		Status(http.StatusBadRequest)
+
+	e.POST("/api/ingest").
+		WithQuery("target", "perf").WithQuery("profile_type", "offcpu").WithQuery("format", "jfr").
+		WithBytes([]byte("x")).
+		Expect().
+		Status(http.StatusBadRequest)
+}
@@ -0,0 +1,152 @@
+package apiserver
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/pprof/profile"
+	"github.com/xyctruth/profiler/pkg/storage"
+)
+
+// mergeBatchSize The profiles are merged in batches to limit the memory
+const mergeBatchSize = 100
+
+// mergeProfile Download the merged profile of the sample type, the merged profile is not saved
+// Query parameters: start_time, end_time, labels[] same as the profile metas
+func (s *APIServer) mergeProfile(c *gin.Context) {
+	sampleType := c.Param("sample_type")
+	data, _, ok := s.mergeMetaProfiles(c, sampleType)
+	if !ok {
+		return
+	}
+	c.Writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment;filename=merged-%s.out", sampleType))
+	c.Data(http.StatusOK, "application/octet-stream", data)
+}
+
+// saveMergeProfile Save the merged profile of the sample type with MergeExpiration, so that it can be opened
+// in the pprof ui and diffed by the returned id
+// Query parameters: start_time, end_time, labels[] same as the profile metas
+func (s *APIServer) saveMergeProfile(c *gin.Context) {
+	sampleType := c.Param("sample_type")
+	data, count, ok := s.mergeMetaProfiles(c, sampleType)
+	if !ok {
+		return
+	}
+	profileID, err := s.store.SaveProfile(fmt.Sprintf("merged-%s", sampleType), data, s.opt.MergeExpiration)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"profile_id":    profileID,
+		"profile_count": count,
+		"pprof_ui":      fmt.Sprintf("%s/%s?si=%s", pprofPath, profileID, sampleType),
+	})
+}
+
+// mergeMetaProfiles Merge the profiles of the sample type matching the time range and the labels of the query,
+// returns the merged pprof and the number of the merged profiles, the error response is written if ok is false
+func (s *APIServer) mergeMetaProfiles(c *gin.Context, sampleType string) (data []byte, count int, ok bool) {
+	startTime, endTime, filters, ok := parseMetaQuery(c)
+	if !ok {
+		return nil, 0, false
+	}
+
+	metaByTargets, err := s.store.ListProfileMeta(sampleType, startTime, endTime, filters...)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return nil, 0, false
+	}
+	metas := uniqueMetas(metaByTargets)
+	if len(metas) == 0 {
+		c.String(http.StatusNotFound, "Profile not found")
+		return nil, 0, false
+	}
+	if len(metas) > s.opt.MaxMergeProfiles {
+		c.String(http.StatusBadRequest, "%d profiles are matched, more than the limit %d, narrow the time range or the labels",
+			len(metas), s.opt.MaxMergeProfiles)
+		return nil, 0, false
+	}
+
+	merged, err := mergeProfiles(s.store, metas)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return nil, 0, false
+	}
+
+	b := &bytes.Buffer{}
+	if err = merged.Write(b); err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return nil, 0, false
+	}
+	return b.Bytes(), len(metas), true
+}
+
+// uniqueMetas The metas of the different profiles
+func uniqueMetas(metaByTargets []*storage.ProfileMetaByTarget) []*storage.ProfileMeta {
+	metas := make([]*storage.ProfileMeta, 0)
+	seen := make(map[string]struct{})
+	for _, target := range metaByTargets {
+		for _, meta := range target.ProfileMetas {
+			if _, ok := seen[meta.ProfileID]; ok {
+				continue
+			}
+			seen[meta.ProfileID] = struct{}{}
+			metas = append(metas, meta)
+		}
+	}
+	return metas
+}
+
+// mergeProfiles Load and merge the pprofs of the metas in batches, the profiles with durations are scaled to
+// the average duration so that the profiles scraped with different durations have the same weight
+func mergeProfiles(store storage.Store, metas []*storage.ProfileMeta) (*profile.Profile, error) {
+	var durations, durationCount int64
+	for _, meta := range metas {
+		if meta.Duration > 0 {
+			durations += meta.Duration
+			durationCount++
+		}
+	}
+	var average int64
+	if durationCount > 0 {
+		average = durations / durationCount
+	}
+
+	var merged *profile.Profile
+	for start := 0; start < len(metas); start += mergeBatchSize {
+		end := start + mergeBatchSize
+		if end > len(metas) {
+			end = len(metas)
+		}
+
+		batch := make([]*profile.Profile, 0, end-start+1)
+		if merged != nil {
+			batch = append(batch, merged)
+		}
+		for _, meta := range metas[start:end] {
+			_, data, err := store.GetProfile(meta.ProfileID)
+			if err != nil {
+				return nil, fmt.Errorf("get profile %s: %w", meta.ProfileID, err)
+			}
+			p, err := profile.ParseData(data)
+			if err != nil {
+				return nil, fmt.Errorf("profile %s is not a pprof: %w", meta.ProfileID, err)
+			}
+			if p.DurationNanos > 0 && p.DurationNanos != average {
+				p.Scale(float64(average) / float64(p.DurationNanos))
+				p.DurationNanos = average
+			}
+			batch = append(batch, p)
+		}
+
+		var err error
+		if merged, err = profile.Merge(batch); err != nil {
+			return nil, err
+		}
+	}
+	return merged, nil
+}
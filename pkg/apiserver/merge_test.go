@@ -0,0 +1,141 @@
+package apiserver
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+	"github.com/xyctruth/profiler/pkg/analysis"
+	"github.com/xyctruth/profiler/pkg/format"
+	"github.com/xyctruth/profiler/pkg/storage"
+	"github.com/xyctruth/profiler/pkg/storage/badger"
+)
+
+// saveCPUProfile Save a cpu profile of main;foo with the value and duration
+func saveCPUProfile(t *testing.T, s storage.Store, instance string, value int64, duration time.Duration) {
+	p, err := format.ParseFolded(strings.NewReader("main;foo 1"),
+		&profile.ValueType{Type: "cpu", Unit: "nanoseconds"})
+	require.NoError(t, err)
+	p.Sample[0].Value[0] = value
+	p.DurationNanos = duration.Nanoseconds()
+
+	b := &bytes.Buffer{}
+	require.NoError(t, p.Write(b))
+	_, err = analysis.Save(s, analysis.Profile{
+		TargetName:  "checkout",
+		Instance:    instance,
+		ProfileType: "profile",
+		Labels:      []storage.Label{{Key: "env", Value: "prod"}},
+		Data:        b.Bytes(),
+		Partial:     true,
+		Functions:   true,
+	}, time.Hour)
+	require.NoError(t, err)
+}
+
+func TestMergeProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	s := badger.NewStore(badger.DefaultOptions(dir))
+	defer s.Release()
+
+	saveCPUProfile(t, s, "pod-0", 10, 10*time.Second)
+	saveCPUProfile(t, s, "pod-1", 30, 30*time.Second)
+	saveCPUProfile(t, s, "pod-2", 60, 20*time.Second)
+
+	e := getExpect(NewAPIServer(DefaultOptions(s)), t)
+	startTime := time.Now().Add(-1 * time.Minute).Format(time.RFC3339)
+	endTime := time.Now().Add(time.Minute).Format(time.RFC3339)
+
+	// GET does not save the merged profile
+	data := e.GET("/api/merge/profile_cpu").
+		WithQuery("start_time", startTime).WithQuery("end_time", endTime).
+		WithQuery("labels[]", `{"Key":"env","Value":"prod"}`).
+		Expect().
+		Status(http.StatusOK).Body().Raw()
+	p, err := profile.ParseData([]byte(data))
+	require.NoError(t, err)
+	require.Equal(t, int64(20+20+60), p.Sample[0].Value[0])
+	_, _, err = s.GetProfile("3")
+	require.ErrorIs(t, err, storage.ErrProfileNotFound)
+
+	merged := e.POST("/api/merge/profile_cpu").
+		WithQuery("start_time", startTime).WithQuery("end_time", endTime).
+		WithQuery("labels[]", `{"Key":"env","Value":"prod"}`).
+		Expect().
+		Status(http.StatusOK).JSON().Object()
+	merged.Value("profile_count").Equal(3)
+	id := merged.Value("profile_id").String().Raw()
+	require.Equal(t, "3", id)
+	merged.Value("pprof_ui").Equal("/api/pprof/ui/" + id + "?si=profile_cpu")
+
+	// scaled to the average duration 20s
+	_, saved, err := s.GetProfile(id)
+	require.NoError(t, err)
+	p, err = profile.ParseData(saved)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(p.Sample))
+	require.Equal(t, int64(20+20+60), p.Sample[0].Value[0])
+	require.Equal(t, int64(60*time.Second), p.DurationNanos)
+
+	e.GET("/api/merge/profile_cpu").
+		WithQuery("start_time", startTime).WithQuery("end_time", endTime).
+		WithQuery("labels[]", `{"Key":"env","Value":"dev"}`).
+		Expect().
+		Status(http.StatusNotFound)
+
+	e.GET("/api/merge/profile_cpu").
+		Expect().
+		Status(http.StatusBadRequest)
+
+	e = getExpect(NewAPIServer(DefaultOptions(s).WithMaxMergeProfiles(2)), t)
+	e.GET("/api/merge/profile_cpu").
+		WithQuery("start_time", startTime).WithQuery("end_time", endTime).
+		Expect().
+		Status(http.StatusBadRequest).Text().Contains("more than the limit 2")
+}
+
+func TestMergeProfiles(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	s := badger.NewStore(badger.DefaultOptions(dir))
+	defer s.Release()
+
+	profileBytes, err := ioutil.ReadFile("./testdata/profile.out.testdata")
+	require.Equal(t, nil, err)
+	traceBytes, err := ioutil.ReadFile("./testdata/trace.out.testdata")
+	require.Equal(t, nil, err)
+
+	metas := make([]*storage.ProfileMeta, 0)
+	for i := 0; i < mergeBatchSize+1; i++ {
+		id, err := s.SaveProfile("heap", profileBytes, time.Hour)
+		require.NoError(t, err)
+		metas = append(metas, &storage.ProfileMeta{ProfileID: id})
+	}
+	p, err := profile.ParseData(profileBytes)
+	require.NoError(t, err)
+
+	merged, err := mergeProfiles(s, metas)
+	require.NoError(t, err)
+	var want, got int64
+	for _, sample := range p.Sample {
+		want += sample.Value[0]
+	}
+	for _, sample := range merged.Sample {
+		got += sample.Value[0]
+	}
+	require.Equal(t, want*int64(mergeBatchSize+1), got)
+
+	traceID, err := s.SaveProfile("trace", traceBytes, time.Hour)
+	require.NoError(t, err)
+	_, err = mergeProfiles(s, append(metas, &storage.ProfileMeta{ProfileID: traceID}))
+	require.Error(t, err)
+}
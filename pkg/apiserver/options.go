@@ -1,22 +1,51 @@
 package apiserver
 
 import (
+	"net/http"
 	"time"
 
+	"github.com/xyctruth/profiler/pkg/collector"
 	"github.com/xyctruth/profiler/pkg/storage"
 )
 
+// TargetStatusProvider Provide the scrape status of the targets, implemented by collector.Manger
+type TargetStatusProvider interface {
+	ListTargetStatus() []*collector.TargetStatus
+	GetTargetStatus(targetName string) (*collector.TargetStatus, bool)
+}
+
 type Options struct {
 	Addr       string
 	GCInternal time.Duration
 	Store      storage.Store
+	// TargetStatus The scrape status api is empty when it is nil
+	TargetStatus TargetStatusProvider
+	// ProfileMetrics Expose the profile meta values on /metrics/profiles, not registered when it is nil
+	ProfileMetrics http.Handler
+	// IngestExpiration Expiration of the pushed profiles, never expire when 0
+	IngestExpiration time.Duration
+	// MaxIngestSize Max body size of the pushed profiles in bytes
+	MaxIngestSize int64
+	// IngestFunctions Save the function summary and the top functions of the pushed profiles
+	IngestFunctions bool
+	// MergeExpiration Expiration of the merged profiles
+	MergeExpiration time.Duration
+	// MaxMergeProfiles Max number of the profiles of a merge
+	MaxMergeProfiles int
+	// MaxPGOSize Max size of the default.pgo in bytes, the smallest samples are dropped to fit
+	MaxPGOSize int64
 }
 
 func DefaultOptions(store storage.Store) Options {
 	return Options{
-		Store:      store,
-		Addr:       ":8080",
-		GCInternal: 2 * time.Minute,
+		Store:            store,
+		Addr:             ":8080",
+		GCInternal:       2 * time.Minute,
+		MaxIngestSize:    32 << 20,
+		IngestFunctions:  true,
+		MergeExpiration:  time.Hour,
+		MaxMergeProfiles: 1000,
+		MaxPGOSize:       4 << 20,
 	}
 }
 
@@ -29,3 +58,43 @@ func (opt Options) WithGCInternal(internal time.Duration) Options {
 	opt.GCInternal = internal
 	return opt
 }
+
+func (opt Options) WithTargetStatus(provider TargetStatusProvider) Options {
+	opt.TargetStatus = provider
+	return opt
+}
+
+func (opt Options) WithProfileMetrics(handler http.Handler) Options {
+	opt.ProfileMetrics = handler
+	return opt
+}
+
+func (opt Options) WithIngestExpiration(expiration time.Duration) Options {
+	opt.IngestExpiration = expiration
+	return opt
+}
+
+func (opt Options) WithMaxIngestSize(size int64) Options {
+	opt.MaxIngestSize = size
+	return opt
+}
+
+func (opt Options) WithIngestFunctions(functions bool) Options {
+	opt.IngestFunctions = functions
+	return opt
+}
+
+func (opt Options) WithMergeExpiration(expiration time.Duration) Options {
+	opt.MergeExpiration = expiration
+	return opt
+}
+
+func (opt Options) WithMaxMergeProfiles(max int) Options {
+	opt.MaxMergeProfiles = max
+	return opt
+}
+
+func (opt Options) WithMaxPGOSize(size int64) Options {
+	opt.MaxPGOSize = size
+	return opt
+}
@@ -1,6 +1,7 @@
 package apiserver
 
 import (
+	"net/http"
 	"testing"
 	"time"
 
@@ -18,4 +19,12 @@ func TestOptions(t *testing.T) {
 
 	opt = opt.WithAddr(":8081")
 	require.Equal(t, 3*time.Minute, opt.GCInternal)
+
+	require.True(t, opt.IngestFunctions)
+	opt = opt.WithIngestFunctions(false)
+	require.False(t, opt.IngestFunctions)
+
+	require.Nil(t, opt.ProfileMetrics)
+	opt = opt.WithProfileMetrics(http.NotFoundHandler())
+	require.NotNil(t, opt.ProfileMetrics)
 }
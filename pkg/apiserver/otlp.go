@@ -0,0 +1,84 @@
+package apiserver
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xyctruth/profiler/pkg/analysis"
+	"github.com/xyctruth/profiler/pkg/otlp"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const otlpProtobufContentType = "application/x-protobuf"
+
+// otlpProfiles Implement the OTLP/HTTP profiles receiver, the protobuf encoding is supported, optionally gzip compressed
+func (s *APIServer) otlpProfiles(c *gin.Context) {
+	if mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type")); mediaType != otlpProtobufContentType {
+		c.String(http.StatusUnsupportedMediaType, "unsupported content type %s, only %s is supported", mediaType, otlpProtobufContentType)
+		return
+	}
+
+	var body io.Reader = c.Request.Body
+	switch encoding := c.GetHeader("Content-Encoding"); encoding {
+	case "", "identity":
+	case "gzip":
+		gr, err := gzip.NewReader(c.Request.Body)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		defer gr.Close()
+		body = gr
+	default:
+		c.String(http.StatusUnsupportedMediaType, "unsupported content encoding %s", encoding)
+		return
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(body, s.opt.MaxIngestSize+1))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if int64(len(data)) > s.opt.MaxIngestSize {
+		c.String(http.StatusRequestEntityTooLarge, "body is larger than %d bytes", s.opt.MaxIngestSize)
+		return
+	}
+
+	req, err := otlp.Decode(data)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	profiles, rejected, rejectErr := otlp.ToProfiles(req, c.ClientIP())
+	for _, p := range profiles {
+		p.Functions = s.opt.IngestFunctions
+		if _, err = analysis.Save(s.store, p, s.opt.IngestExpiration); err != nil {
+			if !errors.Is(err, analysis.ErrInvalidProfile) {
+				c.String(http.StatusInternalServerError, err.Error())
+				return
+			}
+			rejected++
+			rejectErr = err
+		}
+	}
+
+	// ExportProfilesServiceResponse, the partial_success is set if some profiles are rejected
+	var resp []byte
+	if rejected > 0 {
+		var partial []byte
+		partial = protowire.AppendTag(partial, 1, protowire.VarintType)
+		partial = protowire.AppendVarint(partial, uint64(rejected))
+		partial = protowire.AppendTag(partial, 2, protowire.BytesType)
+		partial = protowire.AppendString(partial, fmt.Sprintf("%d profiles are rejected: %s", rejected, rejectErr))
+		resp = protowire.AppendTag(resp, 1, protowire.BytesType)
+		resp = protowire.AppendBytes(resp, partial)
+	}
+	c.Data(http.StatusOK, otlpProtobufContentType, resp)
+}
@@ -0,0 +1,83 @@
+package apiserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xyctruth/profiler/pkg/storage"
+	"github.com/xyctruth/profiler/pkg/storage/badger"
+)
+
+func TestOTLPProfiles(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	s := badger.NewStore(badger.DefaultOptions(dir))
+	defer s.Release()
+
+	e := getExpect(NewAPIServer(DefaultOptions(s)), t)
+
+	// checkout service on node-1, cpu samples of the ebpf profiler
+	data, err := ioutil.ReadFile("./testdata/otlp_profiles.pb")
+	require.Equal(t, nil, err)
+
+	e.POST("/v1development/profiles").
+		WithHeader("Content-Type", "application/x-protobuf").
+		WithBytes(data).
+		Expect().
+		Status(http.StatusOK).
+		ContentType("application/x-protobuf").
+		Body().Empty()
+
+	gz := &bytes.Buffer{}
+	w := gzip.NewWriter(gz)
+	_, _ = w.Write(data)
+	require.NoError(t, w.Close())
+	e.POST("/v1development/profiles").
+		WithHeader("Content-Type", "application/x-protobuf").
+		WithHeader("Content-Encoding", "gzip").
+		WithBytes(gz.Bytes()).
+		Expect().
+		Status(http.StatusOK)
+
+	sampleTypes, err := s.ListSampleType()
+	require.NoError(t, err)
+	require.Equal(t, []string{"profile_samples"}, sampleTypes)
+
+	metas, err := s.ListProfileMeta("profile_samples", time.Now().Add(-time.Minute), time.Now().Add(time.Minute),
+		storage.LabelFilter{Label: storage.Label{Key: "k8s_namespace_name", Value: "prod"}})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(metas))
+	require.Equal(t, "checkout/node-1", metas[0].Key)
+	require.Equal(t, 2, len(metas[0].ProfileMetas))
+	require.Equal(t, int64(8), metas[0].ProfileMetas[0].Value)
+	require.Equal(t, int64(100000), metas[0].ProfileMetas[0].Timestamp)
+
+	e.POST("/v1development/profiles").
+		WithHeader("Content-Type", "application/json").
+		WithBytes([]byte("{}")).
+		Expect().
+		Status(http.StatusUnsupportedMediaType)
+
+	e.POST("/v1development/profiles").
+		WithHeader("Content-Type", "application/x-protobuf").
+		WithBytes([]byte{0x0a, 0x10}).
+		Expect().
+		Status(http.StatusBadRequest)
+
+	// the location index of the profile is out of range, rejected by partial success
+	invalid := append([]byte(nil), data...)
+	invalid[bytes.Index(invalid, []byte{0x1a, 0x04, 0x01, 0x00, 0x02, 0x00})+2] = 0x09
+	e.POST("/v1development/profiles").
+		WithHeader("Content-Type", "application/x-protobuf").
+		WithBytes(invalid).
+		Expect().
+		Status(http.StatusOK).
+		Body().Contains("1 profiles are rejected")
+}
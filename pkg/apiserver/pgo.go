@@ -0,0 +1,182 @@
+package apiserver
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/pprof/profile"
+	"github.com/xyctruth/profiler/pkg/format"
+	"github.com/xyctruth/profiler/pkg/storage"
+)
+
+// pgoSampleType The cpu profiles scraped from /debug/pprof/profile, the fgprof profiles are wall clock
+// profiles and not used by PGO
+const pgoSampleType = "profile_cpu"
+
+// defaultPGORange The time range of the profiles merged for PGO
+const defaultPGORange = 7 * 24 * time.Hour
+
+// pgoProfile Merge the cpu profiles of the target as a default.pgo for the profile-guided optimization,
+// the profiles are sampled evenly over the range if there are more than MaxMergeProfiles
+// Query parameters: range (e.g. 7d, 12h, default 7d), labels[] the profiles must have all the labels
+func (s *APIServer) pgoProfile(c *gin.Context) {
+	target := c.Param("target")
+	timeRange, err := parseRange(c.Query("range"))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	req := struct {
+		Filters []storage.LabelFilter `json:"labels[]" form:"labels[]"`
+	}{}
+	if err = c.ShouldBind(&req); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	endTime := time.Now()
+	metaByTargets, err := s.store.ListProfileMeta(pgoSampleType, endTime.Add(-timeRange), endTime, req.Filters...)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	metas := make([]*storage.ProfileMeta, 0)
+	for _, meta := range uniqueMetas(metaByTargets) {
+		if meta.TargetName == target && hasLabels(meta, req.Filters) {
+			metas = append(metas, meta)
+		}
+	}
+	if len(metas) == 0 {
+		c.String(http.StatusNotFound, "CPU profile of target %s not found", target)
+		return
+	}
+	metas = sampleMetas(metas, s.opt.MaxMergeProfiles)
+
+	merged, err := mergeProfiles(s.store, metas)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	// the pushed profiles can have the cpu sample type at any index
+	cpuIndex, err := format.SampleIndex(merged, "cpu")
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	data, err := pgo(merged, cpuIndex, s.opt.MaxPGOSize)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="default.pgo"`)
+	c.Header("X-Profile-Count", strconv.Itoa(len(metas)))
+	c.Data(http.StatusOK, "application/octet-stream", data)
+}
+
+// parseRange Parse the duration with the day unit, e.g. 7d
+func parseRange(value string) (time.Duration, error) {
+	if value == "" {
+		return defaultPGORange, nil
+	}
+	var d time.Duration
+	var err error
+	if strings.HasSuffix(value, "d") {
+		var days int
+		days, err = strconv.Atoi(strings.TrimSuffix(value, "d"))
+		d = time.Duration(days) * 24 * time.Hour
+	} else {
+		d, err = time.ParseDuration(value)
+	}
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid range %s, must be a positive duration like 7d or 12h", value)
+	}
+	return d, nil
+}
+
+func hasLabels(meta *storage.ProfileMeta, filters []storage.LabelFilter) bool {
+	for _, filter := range filters {
+		found := false
+		for _, l := range meta.Labels {
+			if l == filter.Label {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// sampleMetas Select max metas evenly by time
+func sampleMetas(metas []*storage.ProfileMeta, max int) []*storage.ProfileMeta {
+	if len(metas) <= max {
+		return metas
+	}
+	sort.Slice(metas, func(i, j int) bool {
+		return metas[i].Timestamp < metas[j].Timestamp
+	})
+	sampled := make([]*storage.ProfileMeta, 0, max)
+	for i := 0; i < max; i++ {
+		sampled = append(sampled, metas[i*len(metas)/max])
+	}
+	return sampled
+}
+
+// pgo Strip the labels, addresses and mappings not used by the compiler, the samples with the smallest values
+// of the cpuIndex sample type are dropped until the profile is not larger than maxSize
+func pgo(p *profile.Profile, cpuIndex int, maxSize int64) ([]byte, error) {
+	p.Comments = nil
+	p.DropFrames = ""
+	p.KeepFrames = ""
+	p.Mapping = nil
+	for _, loc := range p.Location {
+		loc.Mapping = nil
+		loc.Address = 0
+	}
+
+	samples := make([]*profile.Sample, 0, len(p.Sample))
+	for _, s := range p.Sample {
+		s.Label = nil
+		s.NumLabel = nil
+		s.NumUnit = nil
+		// the locations without symbols are useless without the addresses
+		locations := make([]*profile.Location, 0, len(s.Location))
+		for _, loc := range s.Location {
+			if len(loc.Line) > 0 {
+				locations = append(locations, loc)
+			}
+		}
+		s.Location = locations
+		if len(s.Location) > 0 && s.Value[cpuIndex] != 0 {
+			samples = append(samples, s)
+		}
+	}
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].Value[cpuIndex] > samples[j].Value[cpuIndex]
+	})
+	p.Sample = samples
+
+	for {
+		merged, err := profile.Merge([]*profile.Profile{p})
+		if err != nil {
+			return nil, err
+		}
+		b := &bytes.Buffer{}
+		if err = merged.Write(b); err != nil {
+			return nil, err
+		}
+		if int64(b.Len()) <= maxSize || len(p.Sample) <= 1 {
+			return b.Bytes(), nil
+		}
+		p.Sample = p.Sample[:len(p.Sample)*3/4]
+	}
+}
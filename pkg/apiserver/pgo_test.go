@@ -0,0 +1,185 @@
+package apiserver
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+	"github.com/xyctruth/profiler/pkg/analysis"
+	"github.com/xyctruth/profiler/pkg/format"
+	"github.com/xyctruth/profiler/pkg/storage"
+	"github.com/xyctruth/profiler/pkg/storage/badger"
+)
+
+func TestPGOProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	s := badger.NewStore(badger.DefaultOptions(dir))
+	defer s.Release()
+
+	saveCPUProfile(t, s, "pod-0", 10, 10*time.Second)
+	saveCPUProfile(t, s, "pod-1", 30, 10*time.Second)
+
+	p, err := format.ParseFolded(strings.NewReader("main;bar 1"), &profile.ValueType{Type: "cpu", Unit: "nanoseconds"})
+	require.NoError(t, err)
+	b := &bytes.Buffer{}
+	require.NoError(t, p.Write(b))
+	_, err = analysis.Save(s, analysis.Profile{
+		TargetName:  "payment",
+		Instance:    "pod-0",
+		ProfileType: "profile",
+		Labels:      []storage.Label{{Key: "env", Value: "prod"}},
+		Data:        b.Bytes(),
+		Partial:     true,
+	}, time.Hour)
+	require.NoError(t, err)
+	// the index time of the metas is in seconds
+	time.Sleep(time.Second)
+
+	e := getExpect(NewAPIServer(DefaultOptions(s)), t)
+
+	resp := e.GET("/api/pgo/checkout").
+		WithQuery("range", "1d").
+		WithQuery("labels[]", `{"Key":"env","Value":"prod"}`).
+		Expect().
+		Status(http.StatusOK)
+	resp.Header("Content-Disposition").Equal(`attachment; filename="default.pgo"`)
+	resp.Header("X-Profile-Count").Equal("2")
+
+	pgoProfile, err := profile.ParseData([]byte(resp.Body().Raw()))
+	require.NoError(t, err)
+	require.NoError(t, pgoProfile.CheckValid())
+	require.Empty(t, pgoProfile.Mapping)
+	require.Equal(t, 1, len(pgoProfile.Sample))
+	require.Equal(t, int64(40), pgoProfile.Sample[0].Value[0])
+	require.Equal(t, []string{"main", "foo"}, format.Frames(pgoProfile.Sample[0]))
+
+	e.GET("/api/pgo/checkout").
+		WithQuery("labels[]", `{"Key":"env","Value":"dev"}`).
+		Expect().
+		Status(http.StatusNotFound)
+	e.GET("/api/pgo/order").
+		Expect().
+		Status(http.StatusNotFound)
+	e.GET("/api/pgo/checkout").
+		WithQuery("range", "-1d").
+		Expect().
+		Status(http.StatusBadRequest)
+}
+
+func TestPGOProfileCPUNotLast(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	s := badger.NewStore(badger.DefaultOptions(dir))
+	defer s.Release()
+
+	// the pushed profiles can have the cpu sample type before the others
+	p, err := format.ParseFolded(strings.NewReader("main;foo 1\nmain;bar 1"), &profile.ValueType{Type: "cpu", Unit: "nanoseconds"})
+	require.NoError(t, err)
+	p.SampleType = append(p.SampleType, &profile.ValueType{Type: "samples", Unit: "count"})
+	p.Sample[0].Value = []int64{0, 5}
+	p.Sample[1].Value = []int64{10, 0}
+	b := &bytes.Buffer{}
+	require.NoError(t, p.Write(b))
+	_, err = analysis.Save(s, analysis.Profile{
+		TargetName:  "search",
+		Instance:    "pod-0",
+		ProfileType: "profile",
+		Data:        b.Bytes(),
+		Partial:     true,
+	}, time.Hour)
+	require.NoError(t, err)
+	// the index time of the metas is in seconds
+	time.Sleep(time.Second)
+
+	e := getExpect(NewAPIServer(DefaultOptions(s)), t)
+	body := e.GET("/api/pgo/search").
+		Expect().
+		Status(http.StatusOK).Body().Raw()
+
+	pgoProfile, err := profile.ParseData([]byte(body))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(pgoProfile.Sample))
+	require.Equal(t, []int64{10, 0}, pgoProfile.Sample[0].Value)
+	require.Equal(t, []string{"main", "bar"}, format.Frames(pgoProfile.Sample[0]))
+}
+
+func TestParseRange(t *testing.T) {
+	d, err := parseRange("")
+	require.NoError(t, err)
+	require.Equal(t, 7*24*time.Hour, d)
+	d, err = parseRange("2d")
+	require.NoError(t, err)
+	require.Equal(t, 48*time.Hour, d)
+	d, err = parseRange("90m")
+	require.NoError(t, err)
+	require.Equal(t, 90*time.Minute, d)
+	_, err = parseRange("d")
+	require.Error(t, err)
+	_, err = parseRange("0h")
+	require.Error(t, err)
+}
+
+func TestSampleMetas(t *testing.T) {
+	metas := make([]*storage.ProfileMeta, 0)
+	for i := 9; i >= 0; i-- {
+		metas = append(metas, &storage.ProfileMeta{ProfileID: fmt.Sprint(i), Timestamp: int64(i)})
+	}
+	require.Equal(t, 10, len(sampleMetas(metas, 10)))
+
+	sampled := sampleMetas(metas, 3)
+	require.Equal(t, []int64{0, 3, 6}, []int64{sampled[0].Timestamp, sampled[1].Timestamp, sampled[2].Timestamp})
+}
+
+func TestPGOStrip(t *testing.T) {
+	folded := &strings.Builder{}
+	for i := 1; i <= 100; i++ {
+		fmt.Fprintf(folded, "main;handler%d;work%d %d\n", i, i, i)
+	}
+	p, err := format.ParseFolded(strings.NewReader(folded.String()), &profile.ValueType{Type: "cpu", Unit: "nanoseconds"})
+	require.NoError(t, err)
+	p.Mapping = []*profile.Mapping{{ID: 1, File: "checkout"}}
+	for _, loc := range p.Location {
+		loc.Mapping = p.Mapping[0]
+		loc.Address = 0x1000 + loc.ID
+	}
+	for _, s := range p.Sample {
+		s.Label = map[string][]string{"handler": {"checkout"}}
+	}
+	// a location without symbols
+	p.Location = append(p.Location, &profile.Location{ID: uint64(len(p.Location) + 1), Mapping: p.Mapping[0], Address: 0x9000})
+	p.Sample[0].Location = append(p.Sample[0].Location, p.Location[len(p.Location)-1])
+
+	full, err := pgo(p.Copy(), 0, 1<<20)
+	require.NoError(t, err)
+	stripped, err := profile.ParseData(full)
+	require.NoError(t, err)
+	require.Equal(t, 100, len(stripped.Sample))
+	for _, s := range stripped.Sample {
+		require.Empty(t, s.Label)
+		for _, loc := range s.Location {
+			require.Nil(t, loc.Mapping)
+			require.NotEmpty(t, loc.Line)
+		}
+	}
+
+	capped, err := pgo(p.Copy(), 0, int64(len(full)/2))
+	require.NoError(t, err)
+	require.LessOrEqual(t, len(capped), len(full)/2)
+	stripped, err = profile.ParseData(capped)
+	require.NoError(t, err)
+	require.Less(t, len(stripped.Sample), 100)
+	// the biggest samples are kept
+	for _, s := range stripped.Sample {
+		require.Greater(t, s.Value[0], int64(100-len(stripped.Sample)))
+	}
+}
@@ -0,0 +1,293 @@
+package apiserver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/pprof/profile"
+	"github.com/xyctruth/profiler/pkg/analysis"
+	"github.com/xyctruth/profiler/pkg/format"
+	"github.com/xyctruth/profiler/pkg/storage"
+)
+
+// pyroscopeSampleType The profile type and sample type of a pyroscope app name suffix
+type pyroscopeSampleType struct {
+	profileType string
+	sampleType  string
+	unit        string
+	// partial The profile types have the other sample types scraped from the pprof endpoints
+	partial bool
+}
+
+var pyroscopeSampleTypes = map[string]pyroscopeSampleType{
+	"cpu":            {profileType: "profile", sampleType: "samples", unit: "count"},
+	"itimer":         {profileType: "profile", sampleType: "samples", unit: "count"},
+	"wall":           {profileType: "fgprof", sampleType: "samples", unit: "count"},
+	"alloc_objects":  {profileType: "heap", sampleType: "alloc_objects", unit: "count", partial: true},
+	"alloc_space":    {profileType: "heap", sampleType: "alloc_space", unit: "bytes", partial: true},
+	"inuse_objects":  {profileType: "heap", sampleType: "inuse_objects", unit: "count", partial: true},
+	"inuse_space":    {profileType: "heap", sampleType: "inuse_space", unit: "bytes", partial: true},
+	"goroutines":     {profileType: "goroutine", sampleType: "goroutine", unit: "count"},
+	"mutex_count":    {profileType: "mutex", sampleType: "contentions", unit: "count", partial: true},
+	"mutex_duration": {profileType: "mutex", sampleType: "delay", unit: "nanoseconds", partial: true},
+	"block_count":    {profileType: "block", sampleType: "contentions", unit: "count", partial: true},
+	"block_duration": {profileType: "block", sampleType: "delay", unit: "nanoseconds", partial: true},
+}
+
+// pyroscopeUnits The units of the pyroscope units parameter
+var pyroscopeUnits = map[string]string{
+	"samples":          "count",
+	"objects":          "count",
+	"goroutines":       "count",
+	"lock_samples":     "count",
+	"bytes":            "bytes",
+	"lock_nanoseconds": "nanoseconds",
+}
+
+var nonAlphanumericRegexp = regexp.MustCompile(`[^a-zA-Z0-9]`)
+
+// pyroscopeApp The parsed pyroscope app name, e.g. myapp.cpu{env=prod,region=us}
+type pyroscopeApp struct {
+	target string
+	suffix string
+	labels []storage.Label
+}
+
+func parsePyroscopeAppName(name string) (*pyroscopeApp, error) {
+	app := &pyroscopeApp{labels: make([]storage.Label, 0)}
+
+	if i := strings.Index(name, "{"); i >= 0 {
+		if !strings.HasSuffix(name, "}") {
+			return nil, fmt.Errorf("invalid name %s, labels must be enclosed in {}", name)
+		}
+		for _, l := range strings.Split(name[i+1:len(name)-1], ",") {
+			l = strings.TrimSpace(l)
+			if l == "" {
+				continue
+			}
+			kv := strings.SplitN(l, "=", 2)
+			if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+				return nil, fmt.Errorf("invalid label %s, the format is key=value", l)
+			}
+			key := strings.TrimSpace(kv[0])
+			// __name__ and the other reserved labels
+			if strings.HasPrefix(key, "_") {
+				continue
+			}
+			app.labels = append(app.labels, storage.Label{Key: key, Value: strings.TrimSpace(kv[1])})
+		}
+		name = name[:i]
+	}
+
+	app.target, app.suffix = name, "cpu"
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		app.target, app.suffix = name[:i], name[i+1:]
+	}
+	if app.target == "" {
+		return nil, errors.New("app name is empty")
+	}
+	return app, nil
+}
+
+// pyroscopeIngest Implement the pyroscope /ingest http api, the pprof, folded and lines formats are supported
+func (s *APIServer) pyroscopeIngest(c *gin.Context) {
+	app, err := parsePyroscopeAppName(c.Query("name"))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	st, ok := pyroscopeSampleTypes[app.suffix]
+	if !ok {
+		st = pyroscopeSampleType{
+			profileType: nonAlphanumericRegexp.ReplaceAllString(app.suffix, ""),
+			sampleType:  "samples",
+			unit:        "count",
+		}
+		if st.profileType == "" {
+			c.String(http.StatusBadRequest, "invalid profile type %s", app.suffix)
+			return
+		}
+	}
+	if unit, ok := pyroscopeUnits[c.Query("units")]; ok {
+		st.unit = unit
+	}
+
+	instance := c.ClientIP()
+	labels := make([]storage.Label, 0, len(app.labels)+1)
+	for _, l := range app.labels {
+		if l.Key == "instance" {
+			instance = l.Value
+			continue
+		}
+		labels = append(labels, l)
+	}
+	if spyName := c.Query("spyName"); spyName != "" {
+		labels = append(labels, storage.Label{Key: "spy_name", Value: spyName})
+	}
+
+	from, until := parseUnixTime(c.Query("from")), parseUnixTime(c.Query("until"))
+
+	data, isMultipart, err := s.readPyroscopeBody(c)
+	if err != nil {
+		if errors.Is(err, errBodyTooLarge) {
+			c.String(http.StatusRequestEntityTooLarge, err.Error())
+			return
+		}
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	p := analysis.Profile{
+		TargetName:  app.target,
+		Instance:    instance,
+		ProfileType: st.profileType,
+		Labels:      labels,
+		Data:        data,
+		Time:        from,
+		Functions:   s.opt.IngestFunctions,
+	}
+
+	// the pprof is uploaded as multipart form
+	bodyFormat := c.Query("format")
+	if bodyFormat == "" {
+		bodyFormat = "folded"
+		if isMultipart {
+			bodyFormat = "pprof"
+		}
+	}
+
+	switch bodyFormat {
+	case "pprof":
+	case "folded", "lines":
+		sampleRate, err := strconv.ParseInt(c.DefaultQuery("sampleRate", "100"), 10, 64)
+		if err != nil || sampleRate <= 0 {
+			c.String(http.StatusBadRequest, "invalid sampleRate %s", c.Query("sampleRate"))
+			return
+		}
+		if p.Data, err = convertFolded(bodyFormat, data, st, sampleRate, from, until); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		p.Partial = st.partial
+	default:
+		c.String(http.StatusBadRequest, "unsupported format %s", bodyFormat)
+		return
+	}
+
+	if _, err = analysis.Save(s.store, p, s.opt.IngestExpiration); err != nil {
+		if errors.Is(err, analysis.ErrInvalidProfile) {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+var errBodyTooLarge = errors.New("body is too large")
+
+// readPyroscopeBody Read the raw body, or the profile field of the multipart form
+func (s *APIServer) readPyroscopeBody(c *gin.Context) ([]byte, bool, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, s.opt.MaxIngestSize+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if int64(len(data)) > s.opt.MaxIngestSize {
+		return nil, false, fmt.Errorf("%w, the limit is %d bytes", errBodyTooLarge, s.opt.MaxIngestSize)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	isMultipart := err == nil && mediaType == "multipart/form-data"
+	if isMultipart {
+		if data, err = multipartProfile(data, params["boundary"]); err != nil {
+			return nil, true, err
+		}
+	}
+	if len(data) == 0 {
+		return nil, isMultipart, errors.New("body is empty")
+	}
+	return data, isMultipart, nil
+}
+
+// multipartProfile The profile field of the multipart form, the prev_profile and sample_type_config fields are ignored
+func multipartProfile(data []byte, boundary string) ([]byte, error) {
+	r := multipart.NewReader(bytes.NewReader(data), boundary)
+	for {
+		part, err := r.NextPart()
+		if err == io.EOF {
+			return nil, errors.New("profile field is not found")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == "profile" {
+			return ioutil.ReadAll(part)
+		}
+	}
+}
+
+// convertFolded Convert the folded or lines body into pprof, the cpu samples also have the cpu time
+func convertFolded(bodyFormat string, data []byte, st pyroscopeSampleType, sampleRate int64, from, until time.Time) ([]byte, error) {
+	sampleType := &profile.ValueType{Type: st.sampleType, Unit: st.unit}
+
+	var prof *profile.Profile
+	var err error
+	if bodyFormat == "lines" {
+		prof, err = format.ParseLines(bytes.NewReader(data), sampleType)
+	} else {
+		prof, err = format.ParseFolded(bytes.NewReader(data), sampleType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", analysis.ErrInvalidProfile, err)
+	}
+
+	if st.profileType == "profile" || st.profileType == "fgprof" {
+		// the sample types of the cpu and fgprof profiles scraped from the pprof endpoints
+		period := int64(time.Second) / sampleRate
+		timeType := "cpu"
+		if st.profileType == "fgprof" {
+			timeType = "time"
+		}
+		prof.SampleType = append(prof.SampleType, &profile.ValueType{Type: timeType, Unit: "nanoseconds"})
+		prof.PeriodType = &profile.ValueType{Type: timeType, Unit: "nanoseconds"}
+		prof.Period = period
+		for _, sample := range prof.Sample {
+			sample.Value = append(sample.Value, sample.Value[0]*period)
+		}
+	}
+
+	if !from.IsZero() {
+		prof.TimeNanos = from.UnixNano()
+		if until.After(from) {
+			prof.DurationNanos = until.Sub(from).Nanoseconds()
+		}
+	}
+
+	b := &bytes.Buffer{}
+	if err = prof.Write(b); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// parseUnixTime Parse the unix seconds, zero if it is invalid
+func parseUnixTime(s string) time.Time {
+	sec, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || sec <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
@@ -0,0 +1,106 @@
+package apiserver
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xyctruth/profiler/pkg/storage"
+	"github.com/xyctruth/profiler/pkg/storage/badger"
+)
+
+func TestParsePyroscopeAppName(t *testing.T) {
+	app, err := parsePyroscopeAppName("my.app.cpu{env=prod, region = us,__name__=x}")
+	require.NoError(t, err)
+	require.Equal(t, "my.app", app.target)
+	require.Equal(t, "cpu", app.suffix)
+	require.Equal(t, []storage.Label{{Key: "env", Value: "prod"}, {Key: "region", Value: "us"}}, app.labels)
+
+	app, err = parsePyroscopeAppName("app")
+	require.NoError(t, err)
+	require.Equal(t, "cpu", app.suffix)
+
+	_, err = parsePyroscopeAppName("app.cpu{env=prod")
+	require.Error(t, err)
+	_, err = parsePyroscopeAppName("app.cpu{env}")
+	require.Error(t, err)
+	_, err = parsePyroscopeAppName("{env=prod}")
+	require.Error(t, err)
+}
+
+func TestPyroscopeIngest(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	s := badger.NewStore(badger.DefaultOptions(dir))
+	defer s.Release()
+
+	e := getExpect(NewAPIServer(DefaultOptions(s)), t)
+	from := time.Now().Add(-10 * time.Second)
+
+	e.POST("/ingest").
+		WithQuery("name", "python-app.cpu{env=prod,instance=pod-0}").
+		WithQuery("from", from.Unix()).WithQuery("until", from.Add(10*time.Second).Unix()).
+		WithQuery("sampleRate", 100).WithQuery("spyName", "pyspy").
+		WithBytes([]byte("main;foo;bar 10\nmain;foo 5\n")).
+		Expect().
+		Status(http.StatusOK)
+
+	e.POST("/ingest").
+		WithQuery("name", "python-app.alloc_space{env=prod,instance=pod-0}").WithQuery("format", "lines").
+		WithBytes([]byte("main;foo\nmain;foo\n")).
+		Expect().
+		Status(http.StatusOK)
+
+	// pprof in multipart form
+	profileBytes, err := ioutil.ReadFile("./testdata/profile.out.testdata")
+	require.Equal(t, nil, err)
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	part, err := w.CreateFormFile("profile", "profile.pprof")
+	require.NoError(t, err)
+	_, _ = part.Write(profileBytes)
+	require.NoError(t, w.Close())
+	e.POST("/ingest").
+		WithQuery("name", "go-app.alloc_objects").
+		WithHeader("Content-Type", w.FormDataContentType()).
+		WithBytes(body.Bytes()).
+		Expect().
+		Status(http.StatusOK)
+
+	sampleTypes, err := s.ListSampleType()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"profile_samples", "profile_cpu", "heap_alloc_space",
+		"heap_alloc_objects", "heap_inuse_objects", "heap_inuse_space"}, sampleTypes)
+
+	metas, err := s.ListProfileMeta("profile_cpu", time.Now().Add(-time.Minute), time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, "python-app/pod-0", metas[0].Key)
+	meta := metas[0].ProfileMetas[0]
+	require.Equal(t, int64(150000000), meta.Value)
+	require.Equal(t, int64(10*time.Second), meta.Duration)
+	require.Equal(t, from.Unix()*1000, meta.Timestamp)
+	require.Contains(t, meta.Labels, storage.Label{Key: "spy_name", Value: "pyspy"})
+
+	metas, err = s.ListProfileMeta("heap_alloc_space", time.Now().Add(-time.Minute), time.Now().Add(time.Minute), storage.LabelFilter{Label: storage.Label{Key: "env", Value: "prod"}})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), metas[0].ProfileMetas[0].Value)
+
+	e.POST("/ingest").WithQuery("name", "app.cpu").WithQuery("format", "jfr").WithBytes([]byte("x")).
+		Expect().
+		Status(http.StatusBadRequest)
+	e.POST("/ingest").WithQuery("name", "app.cpu").WithBytes([]byte("main;foo x")).
+		Expect().
+		Status(http.StatusBadRequest)
+	e.POST("/ingest").WithQuery("name", "app.cpu").WithQuery("format", "pprof").WithBytes([]byte("invalid")).
+		Expect().
+		Status(http.StatusBadRequest)
+	e.POST("/ingest").WithQuery("name", "app.cpu").
+		Expect().
+		Status(http.StatusBadRequest)
+}
@@ -0,0 +1,96 @@
+package apiserver
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/pprof/profile"
+	"github.com/xyctruth/profiler/pkg/analysis"
+	"github.com/xyctruth/profiler/pkg/storage"
+)
+
+// topFunctions The top functions by flat and cum value of each sample type of the profile,
+// the profiles saved without the top functions (e.g. merged or diff profiles) are parsed
+// Query parameters: sample_type, limit (default and max analysis.TopFunctionsLimit)
+func (s *APIServer) topFunctions(c *gin.Context) {
+	id := c.Param("id")
+	limit := analysis.TopFunctionsLimit
+	if v := c.Query("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			c.String(http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+	}
+
+	tops, err := s.store.GetTopFunctions(id)
+	if errors.Is(err, storage.ErrTopFunctionsNotFound) {
+		var ok bool
+		if tops, ok = s.parseTopFunctions(c, id); !ok {
+			return
+		}
+		err = nil
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if sampleType := c.Query("sample_type"); sampleType != "" {
+		filtered := make([]*storage.TopFunctions, 0, 1)
+		for _, top := range tops {
+			if top.SampleType == sampleType {
+				filtered = append(filtered, top)
+			}
+		}
+		if len(filtered) == 0 {
+			c.String(http.StatusBadRequest, "sample type %s not found", sampleType)
+			return
+		}
+		tops = filtered
+	}
+
+	for _, top := range tops {
+		if len(top.Flat) > limit {
+			top.Flat = top.Flat[:limit]
+		}
+		if len(top.Cum) > limit {
+			top.Cum = top.Cum[:limit]
+		}
+	}
+	c.JSON(http.StatusOK, tops)
+}
+
+// parseTopFunctions The top functions of the profile saved without them, the error response is written if ok is false.
+// The sample types are named as the metas of the profiles saved with the name target-profileType,
+// the merged and diff profiles keep the sample types of the pprof
+func (s *APIServer) parseTopFunctions(c *gin.Context, id string) ([]*storage.TopFunctions, bool) {
+	name, data, err := s.store.GetProfile(id)
+	if err != nil {
+		if errors.Is(err, storage.ErrProfileNotFound) {
+			c.String(http.StatusNotFound, "Profile not found")
+			return nil, false
+		}
+		c.String(http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+	p, err := profile.ParseData(data)
+	if err != nil {
+		c.String(http.StatusBadRequest, "the profile is not a pprof: %s", err.Error())
+		return nil, false
+	}
+
+	sampleTypes := make([]string, 0, len(p.SampleType))
+	for _, st := range p.SampleType {
+		sampleTypes = append(sampleTypes, st.Type)
+	}
+	i := strings.LastIndex(name, "-")
+	if i >= 0 && !strings.HasPrefix(name, "merged-") && !strings.HasPrefix(name, "diff-") {
+		profileType := name[i+1:]
+		sampleTypes = analysis.SampleTypeNames(profileType, analysis.IsPartialProfileType(profileType), p)
+	}
+	return analysis.NewTopFunctions(p, analysis.NewFunctionSummary(p, sampleTypes), analysis.TopFunctionsLimit), true
+}
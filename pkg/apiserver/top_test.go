@@ -0,0 +1,88 @@
+package apiserver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xyctruth/profiler/pkg/analysis"
+	"github.com/xyctruth/profiler/pkg/storage"
+	"github.com/xyctruth/profiler/pkg/storage/badger"
+)
+
+func TestTopFunctions(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	s := badger.NewStore(badger.DefaultOptions(dir))
+	defer s.Release()
+
+	saveCPUProfile(t, s, "pod-0", 10, 10*time.Second)
+	metas, err := s.ListProfileMeta("profile_cpu", time.Now().Add(-time.Minute), time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	id := metas[0].ProfileMetas[0].ProfileID
+
+	e := getExpect(NewAPIServer(DefaultOptions(s)), t)
+
+	tops := e.GET(fmt.Sprintf("/api/profile/%s/top", id)).
+		Expect().
+		Status(http.StatusOK).JSON().Array()
+	tops.Length().Equal(1)
+	top := tops.Element(0).Object()
+	top.Value("sample_type").Equal("profile_cpu")
+	top.Value("unit").Equal("nanoseconds")
+	top.Value("total").Equal(10)
+	top.Value("flat").Equal([]map[string]interface{}{{"name": "foo", "value": 10, "percent": 100}})
+	top.Value("cum").Array().Length().Equal(2)
+
+	e.GET(fmt.Sprintf("/api/profile/%s/top", id)).
+		WithQuery("sample_type", "profile_cpu").WithQuery("limit", 1).
+		Expect().
+		Status(http.StatusOK).JSON().Array().Element(0).Object().Value("cum").Array().Length().Equal(1)
+
+	// A profile saved without the top functions is parsed, the sample types are named as the metas
+	foldedID := saveFolded(t, s, "main;foo 1 300\nmain;bar 1 100\n")
+	top = e.GET(fmt.Sprintf("/api/profile/%s/top", foldedID)).
+		WithQuery("sample_type", "profile_cpu").
+		Expect().
+		Status(http.StatusOK).JSON().Array().Element(0).Object()
+	top.Value("total").Equal(400)
+	top.Value("flat").Array().Element(0).Object().Value("percent").Equal(75)
+
+	profileBytes, err := ioutil.ReadFile("./testdata/profile.out.testdata")
+	require.NoError(t, err)
+	heapID, err := analysis.Save(s, analysis.Profile{TargetName: "checkout", ProfileType: "heap", Data: profileBytes}, time.Hour)
+	require.NoError(t, err)
+	_, err = s.GetTopFunctions(heapID)
+	require.ErrorIs(t, err, storage.ErrTopFunctionsNotFound)
+	e.GET(fmt.Sprintf("/api/profile/%s/top", heapID)).
+		WithQuery("sample_type", "heap_inuse_space").
+		Expect().
+		Status(http.StatusOK).JSON().Array().Element(0).Object().Value("sample_type").Equal("heap_inuse_space")
+
+	// the merged profiles keep the sample types of the pprof
+	_, data, err := s.GetProfile(foldedID)
+	require.NoError(t, err)
+	mergedID, err := s.SaveProfile("merged-profile_cpu", data, time.Hour)
+	require.NoError(t, err)
+	e.GET(fmt.Sprintf("/api/profile/%s/top", mergedID)).
+		WithQuery("sample_type", "cpu").
+		Expect().
+		Status(http.StatusOK)
+
+	e.GET(fmt.Sprintf("/api/profile/%s/top", id)).
+		WithQuery("sample_type", "heap").
+		Expect().
+		Status(http.StatusBadRequest)
+	e.GET(fmt.Sprintf("/api/profile/%s/top", id)).
+		WithQuery("limit", "0").
+		Expect().
+		Status(http.StatusBadRequest)
+	e.GET("/api/profile/999/top").
+		Expect().
+		Status(http.StatusNotFound)
+}
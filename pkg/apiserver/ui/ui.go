@@ -6,6 +6,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/xyctruth/profiler/pkg/metrics"
 	"github.com/xyctruth/profiler/pkg/storage"
 	"github.com/xyctruth/profiler/pkg/utils"
 )
@@ -58,6 +59,7 @@ func (s *Server) gc() {
 	s.mux = http.NewServeMux()
 	s.mux.HandleFunc("/", s.register)
 	s.cache = make(map[string]struct{})
+	metrics.UICacheSize.WithLabelValues(s.basePath).Set(0)
 }
 
 func (s *Server) Web(w http.ResponseWriter, r *http.Request) {
@@ -89,12 +91,15 @@ func (s *Server) register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	start := time.Now()
 	err = s.drive(s.basePath, s.mux, id, data)
+	metrics.UIDriverLoadDuration.WithLabelValues(s.basePath).Observe(time.Since(start).Seconds())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	s.cache[id] = struct{}{}
+	metrics.UICacheSize.WithLabelValues(s.basePath).Set(float64(len(s.cache)))
 	http.Redirect(w, r, r.URL.Path+"?"+r.URL.RawQuery, http.StatusSeeOther)
 }
@@ -2,18 +2,18 @@ package collector
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"hash/fnv"
 	"net/http"
 	"reflect"
 	"sync"
 	"time"
 
-	"github.com/xyctruth/stream"
-
 	"github.com/google/pprof/profile"
 	"github.com/sirupsen/logrus"
+	"github.com/xyctruth/profiler/pkg/analysis"
 	"github.com/xyctruth/profiler/pkg/storage"
 )
 
@@ -21,29 +21,75 @@ import (
 type Collector struct {
 	TargetName string
 	TargetConfig
-	exitChan        chan struct{}
-	resetTickerChan chan time.Duration
-	mangerWg        *sync.WaitGroup
-	wg              *sync.WaitGroup
-	httpClient      *http.Client
-	mu              sync.RWMutex
-	log             *logrus.Entry
-	store           storage.Store
+	ctx           context.Context
+	cancel        context.CancelFunc
+	mangerWg      *sync.WaitGroup
+	httpClient    *http.Client
+	httpClientErr error
+	// limiter Limit concurrent scrapes of the target
+	limiter *limiter
+	// globalLimiter Limit concurrent scrapes of all targets, shared by the collectors of the Manger
+	globalLimiter *limiter
+	// loops Running scrape loops, key is profileType/instance
+	loops   map[string]*scrapeLoop
+	running bool
+	mu      sync.RWMutex
+	// status The last scrape of the running loops, key is profileType/instance
+	status   map[string]*ScrapeStatus
+	statusMu sync.Mutex
+	// previous The cumulative sample types of the last scrape of the delta profile types, key is profileType/instance
+	previous   map[string]*profile.Profile
+	previousMu sync.Mutex
+	log        *logrus.Entry
+	store      storage.Store
+}
+
+// scrapeLoop Scrape a profile type of an instance periodically
+type scrapeLoop struct {
+	profileType string
+	instance    string
+	interval    time.Duration
+	cancel      context.CancelFunc
+}
+
+// scrapeTask A scrape of a profile type of an instance, with the configuration when it starts
+type scrapeTask struct {
+	target        TargetConfig
+	httpClient    *http.Client
+	httpClientErr error
+	instance      string
+	profileType   string
+	profileConfig ProfileConfig
+}
+
+// expiration Expiration of the profile, default is the expiration of the target
+func (task scrapeTask) expiration() time.Duration {
+	if task.profileConfig.Expiration > 0 {
+		return task.profileConfig.Expiration
+	}
+	return task.target.Expiration
 }
 
 func newCollector(targetName string, target TargetConfig, store storage.Store, mangerWg *sync.WaitGroup) *Collector {
+	ctx, cancel := context.WithCancel(context.Background())
 	collector := &Collector{
-		TargetName:      targetName,
-		TargetConfig:    target,
-		exitChan:        make(chan struct{}),
-		resetTickerChan: make(chan time.Duration, 1000),
-		mangerWg:        mangerWg,
-		wg:              &sync.WaitGroup{},
-		httpClient:      &http.Client{},
-		log:             logrus.WithField("collector", targetName),
-		store:           store,
+		TargetName:   targetName,
+		TargetConfig: target,
+		ctx:          ctx,
+		cancel:       cancel,
+		mangerWg:     mangerWg,
+		limiter:      newLimiter(target.MaxConcurrency),
+		loops:        make(map[string]*scrapeLoop),
+		status:       make(map[string]*ScrapeStatus),
+		previous:     make(map[string]*profile.Profile),
+		log:          logrus.WithField("collector", targetName),
+		store:        store,
 	}
 	collector.ProfileConfigs = buildProfileConfigs(collector.ProfileConfigs)
+	collector.httpClient, collector.httpClientErr = newHTTPClient(target)
+	if collector.httpClientErr != nil {
+		collector.log.WithError(collector.httpClientErr).Error("build http client error")
+	}
 	return collector
 }
 
@@ -52,26 +98,94 @@ func (collector *Collector) run() {
 	defer collector.mu.Unlock()
 
 	collector.log.Info("collector run")
-	collector.mangerWg.Add(1)
+	collector.running = true
+	collector.syncLoops()
+}
+
+// syncLoops Start a scrape loop for each enabled profile type of each instance,
+// stop the loops of the removed instances or disabled profile types, restart the loops whose interval changed
+func (collector *Collector) syncLoops() {
+	if !collector.running || collector.ctx.Err() != nil {
+		return
+	}
+	if len(collector.Instances) == 0 {
+		collector.log.Warn("collector instances is empty")
+	}
 
-	go collector.scrapeLoop(collector.Interval)
+	loops := make(map[string]*scrapeLoop)
+	for profileType, profileConfig := range collector.ProfileConfigs {
+		if !*profileConfig.Enable {
+			continue
+		}
+		interval := collector.profileInterval(profileConfig)
+		for _, instance := range collector.Instances {
+			loops[profileType+"/"+instance] = &scrapeLoop{profileType: profileType, instance: instance, interval: interval}
+		}
+	}
+
+	for key, loop := range collector.loops {
+		if l, ok := loops[key]; ok && l.interval == loop.interval {
+			continue
+		}
+		loop.cancel()
+		delete(collector.loops, key)
+		collector.swapPrevious(key, nil)
+	}
+
+	for key, loop := range loops {
+		if _, ok := collector.loops[key]; ok {
+			continue
+		}
+		var ctx context.Context
+		ctx, loop.cancel = context.WithCancel(collector.ctx)
+		collector.loops[key] = loop
+		collector.mangerWg.Add(1)
+		go collector.scrapeLoop(ctx, loop)
+	}
+	collector.syncStatus()
 }
 
-func (collector *Collector) scrapeLoop(interval time.Duration) {
+// profileInterval Scrape interval of the profile type, default is the interval of the target
+func (collector *Collector) profileInterval(profileConfig ProfileConfig) time.Duration {
+	if profileConfig.Interval > 0 {
+		return profileConfig.Interval
+	}
+	if collector.Interval > 0 {
+		return collector.Interval
+	}
+	return defaultInterval
+}
+
+// jitter Deterministic offset of the first scrape in the interval,
+// spread the scrapes of the instances and profile types evenly
+func (collector *Collector) jitter(loop *scrapeLoop) time.Duration {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(collector.TargetName + "/" + loop.profileType + "/" + loop.instance))
+	return time.Duration(h.Sum64() % uint64(loop.interval))
+}
+
+func (collector *Collector) scrapeLoop(ctx context.Context, loop *scrapeLoop) {
 	defer collector.mangerWg.Done()
-	collector.scrape()
 
-	ticker := time.NewTicker(interval)
+	timer := time.NewTimer(collector.jitter(loop))
+	select {
+	case <-ctx.Done():
+		timer.Stop()
+		return
+	case <-timer.C:
+	}
+	collector.scrape(loop.profileType, loop.instance)
+
+	ticker := time.NewTicker(loop.interval)
 	defer ticker.Stop()
 	for {
 		select {
-		case <-collector.exitChan:
-			collector.log.Info("scrape loop exit")
+		case <-ctx.Done():
+			collector.log.WithFields(logrus.Fields{"profile_type": loop.profileType, "instance": loop.instance}).
+				Info("scrape loop exit")
 			return
-		case i := <-collector.resetTickerChan:
-			ticker.Reset(i)
 		case <-ticker.C:
-			collector.scrape()
+			collector.scrape(loop.profileType, loop.instance)
 		}
 	}
 }
@@ -87,161 +201,150 @@ func (collector *Collector) reload(target TargetConfig) {
 	}
 	collector.log.Info("reload collector ")
 
-	if collector.Interval != target.Interval {
-		collector.resetTickerChan <- target.Interval
+	collector.limiter.setLimit(target.MaxConcurrency)
+
+	if !reflect.DeepEqual(collector.TLSConfig, target.TLSConfig) {
+		collector.httpClient, collector.httpClientErr = newHTTPClient(target)
+		if collector.httpClientErr != nil {
+			collector.log.WithError(collector.httpClientErr).Error("build http client error")
+		}
 	}
 
 	collector.TargetConfig = target
+	collector.syncLoops()
 }
 
+// exit Stop the scrape loops, the running requests are finished but not retried
 func (collector *Collector) exit() {
-	close(collector.exitChan)
+	collector.cancel()
+}
+
+// scrape Fetch the profile type of the instance with the current configuration
+func (collector *Collector) scrape(profileType string, instance string) {
+	collector.fetch(collector.newScrapeTask(profileType, instance))
 }
 
-func (collector *Collector) scrape() {
+// newScrapeTask Snapshot the configuration, the lock is not held during the scrape
+func (collector *Collector) newScrapeTask(profileType string, instance string) scrapeTask {
 	collector.mu.RLock()
 	defer collector.mu.RUnlock()
-
-	if collector.Instances == nil {
-		collector.log.Warn("collector instances is empty")
-		return
-	}
-
-	collector.log.Info("collector start scrape")
-	for profileType, profileConfig := range collector.ProfileConfigs {
-		if *profileConfig.Enable {
-			stream.NewSlice(collector.Instances).Parallel(len(collector.Instances)).ForEach(func(i int, instance string) {
-				collector.wg.Add(1)
-				collector.fetch(instance, profileType, profileConfig)
-			})
-		}
+	return scrapeTask{
+		target:        collector.TargetConfig,
+		httpClient:    collector.httpClient,
+		httpClientErr: collector.httpClientErr,
+		instance:      instance,
+		profileType:   profileType,
+		profileConfig: collector.ProfileConfigs[profileType],
 	}
-	collector.wg.Wait()
 }
 
-func (collector *Collector) fetch(instance string, profileType string, profileConfig ProfileConfig) {
-	defer collector.wg.Done()
-
-	logEntry := collector.log.WithFields(logrus.Fields{"profile_type": profileType, "profile_url": profileConfig.Path})
+func (collector *Collector) fetch(task scrapeTask) {
+	logEntry := collector.log.WithFields(logrus.Fields{"profile_type": task.profileType, "profile_url": task.profileConfig.Path, "instance": task.instance})
 	logEntry.Info("collector start fetch")
 
-	req, err := http.NewRequest("GET", "http://"+instance+profileConfig.Path, nil)
-	if err != nil {
-		logEntry.WithError(err).Error("invoke task error")
-		return
-	}
-	req.Header.Set("User-Agent", "")
-
-	resp, err := collector.httpClient.Do(req)
-	if err != nil {
-		logEntry.WithError(err).Error("http request error")
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		logEntry.WithError(err).Error("http resp status code is ", resp.StatusCode)
-		return
-	}
-
-	profileBytes, err := ioutil.ReadAll(resp.Body)
+	start := time.Now()
+	profileBytes, err := collector.fetchWithRetry(task, logEntry)
 	if err != nil {
-		logEntry.WithError(err).Error("read resp error")
+		logEntry.WithError(err).Error("fetch profile error")
+		collector.updateStatus(task, start, statusCode(err), 0, err)
 		return
 	}
 
-	if profileType == "trace" {
-		err = collector.analysisTrace(instance, profileType, profileBytes)
-		if err != nil {
-			logEntry.WithError(err).Error("analysis result error")
-			return
-		}
-		return
-	}
-
-	err = collector.analysis(instance, profileType, profileBytes)
+	err = collector.save(task, profileBytes)
 	if err != nil {
 		logEntry.WithError(err).Error("analysis result error")
-		return
 	}
+	collector.updateStatus(task, start, http.StatusOK, len(profileBytes), err)
 }
 
-func (collector *Collector) analysis(instance string, profileType string, profileBytes []byte) error {
-	p, err := profile.ParseData(profileBytes)
-	if err != nil {
-		return err
+// save Save the scraped profile, the cumulative sample types are saved as they are and/or as the delta
+// from the previous scrape of the instance
+func (collector *Collector) save(task scrapeTask, data []byte) error {
+	p := analysis.Profile{
+		TargetName:  collector.TargetName,
+		Instance:    task.instance,
+		ProfileType: task.profileType,
+		Labels:      task.target.instanceLabels(task.instance).ToArray(),
+		Data:        data,
+		Functions:   task.profileConfig.functions(),
 	}
-	if len(p.SampleType) == 0 {
-		return errors.New("sample type is nil")
-	}
-
-	// Set profile name , Display it on the Profile UI
-	if len(p.Mapping) > 0 {
-		p.Mapping[0].File = collector.TargetName
-	}
-
-	b := &bytes.Buffer{}
-	if err = p.Write(b); err != nil {
+	cumulativeTypes, ok := analysis.CumulativeSampleTypes[task.profileType]
+	if !ok || (!task.profileConfig.delta() && task.profileConfig.cumulative()) {
+		_, err := analysis.Save(collector.store, p, task.expiration())
 		return err
 	}
 
-	profileID, err := collector.store.SaveProfile(fmt.Sprintf("%s-%s", collector.TargetName, profileType), b.Bytes(), collector.Expiration)
+	prof, err := profile.ParseData(data)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %s", analysis.ErrInvalidProfile, err)
+	}
+	isCumulative := func(sampleType string) bool {
+		for _, t := range cumulativeTypes {
+			if t == sampleType {
+				return true
+			}
+		}
+		return false
 	}
 
-	metas := make([]*storage.ProfileMeta, 0, len(p.SampleType))
-	for i := range p.SampleType {
-		meta := &storage.ProfileMeta{}
-		meta.Timestamp = time.Now().UnixNano() / time.Millisecond.Nanoseconds()
-		meta.ProfileID = profileID
-		meta.ProfileType = profileType
-		meta.TargetName = collector.TargetName
-		meta.Instance = instance
-
-		meta.Duration = p.DurationNanos
-		meta.SampleTypeUnit = p.SampleType[i].Unit
-		for _, s := range p.Sample {
-			meta.Value += s.Value[i]
+	if task.profileConfig.cumulative() {
+		if _, err = analysis.Save(collector.store, p, task.expiration()); err != nil {
+			return err
 		}
-		if len(p.SampleType) > 1 {
-			meta.SampleType = fmt.Sprintf("%s_%s", profileType, p.SampleType[i].Type)
-		} else {
-			meta.SampleType = profileType
+	} else if other := analysis.SelectSampleTypes(prof, func(sampleType string) bool {
+		return !isCumulative(sampleType)
+	}); other != nil {
+		// named as the sample types of the full profile, e.g. heap_inuse_space
+		partial := p
+		partial.Partial = true
+		if err = saveProfile(collector.store, partial, other, task.expiration()); err != nil {
+			return err
 		}
-
-		meta.Labels = collector.Labels.ToArray()
-		metas = append(metas, meta)
 	}
 
-	err = collector.store.SaveProfileMeta(metas, collector.Expiration)
+	if !task.profileConfig.delta() {
+		return nil
+	}
+	cur := analysis.SelectSampleTypes(prof, isCumulative)
+	if cur == nil {
+		return nil
+	}
+	prev := collector.swapPrevious(task.profileType+"/"+task.instance, cur)
+	if prev == nil {
+		return nil
+	}
+	delta, err := analysis.Delta(prev, cur)
+	if errors.Is(err, analysis.ErrProfileReset) {
+		collector.log.WithFields(logrus.Fields{"profile_type": task.profileType, "instance": task.instance}).
+			WithError(err).Info("skip delta profile")
+		return nil
+	}
 	if err != nil {
 		return err
 	}
-	return nil
+	p.Partial = true
+	return saveProfile(collector.store, p, delta, task.expiration())
 }
 
-func (collector *Collector) analysisTrace(instance string, profileType string, profileBytes []byte) error {
-	profileID, err := collector.store.SaveProfile(fmt.Sprintf("%s-%s", collector.TargetName, profileType), profileBytes, collector.Expiration)
-	if err != nil {
-		return err
+// swapPrevious Set the cumulative profile of the key, returns the previous one
+func (collector *Collector) swapPrevious(key string, p *profile.Profile) *profile.Profile {
+	collector.previousMu.Lock()
+	defer collector.previousMu.Unlock()
+	prev := collector.previous[key]
+	if p == nil {
+		delete(collector.previous, key)
+	} else {
+		collector.previous[key] = p
 	}
+	return prev
+}
 
-	metas := make([]*storage.ProfileMeta, 0, 1)
-	meta := &storage.ProfileMeta{}
-	meta.Timestamp = time.Now().UnixNano() / time.Millisecond.Nanoseconds()
-	meta.ProfileID = profileID
-	meta.ProfileType = profileType
-	meta.SampleType = profileType
-	meta.TargetName = collector.TargetName
-	meta.Instance = instance
-
-	meta.Labels = collector.Labels.ToArray()
-	metas = append(metas, meta)
-
-	err = collector.store.SaveProfileMeta(metas, collector.Expiration)
-	if err != nil {
+func saveProfile(store storage.Store, p analysis.Profile, prof *profile.Profile, expiration time.Duration) error {
+	b := &bytes.Buffer{}
+	if err := prof.Write(b); err != nil {
 		return err
 	}
-	return nil
+	p.Data = b.Bytes()
+	_, err := analysis.Save(store, p, expiration)
+	return err
 }
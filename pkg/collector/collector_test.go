@@ -2,12 +2,17 @@ package collector
 
 import (
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/google/pprof/profile"
 	"github.com/stretchr/testify/require"
+	"github.com/xyctruth/profiler/pkg/storage"
 	"github.com/xyctruth/profiler/pkg/storage/badger"
 	"github.com/xyctruth/profiler/pkg/utils"
 	yaml "gopkg.in/yaml.v2"
@@ -22,7 +27,7 @@ func TestNewCollector(t *testing.T) {
 	require.Equal(t, collector.Interval, 2*time.Second)
 	require.Equal(t, collector.Expiration, time.Duration(0))
 	require.Equal(t, collector.Instances, []string{"localhost:9000"})
-	require.Equal(t, len(collector.ProfileConfigs), 9)
+	require.Equal(t, len(collector.ProfileConfigs), 10)
 }
 
 func TestCollectorReload(t *testing.T) {
@@ -87,7 +92,28 @@ func TestCollectorRun(t *testing.T) {
 
 	collector.run()
 
-	time.Sleep(1 * time.Second)
+	// the first scrapes are spread over the interval, the delta sample types are saved from the second scrapes
+	countSampleTypes := func() (cumulative, delta int) {
+		sampleTypes, err := store.ListSampleType()
+		require.NoError(t, err)
+		for _, sampleType := range sampleTypes {
+			if strings.Contains(sampleType, "_delta_") {
+				delta++
+			} else {
+				cumulative++
+			}
+		}
+		return
+	}
+	require.Eventually(t, func() bool {
+		cumulative, _ := countSampleTypes()
+		return cumulative == 19
+	}, 10*time.Second, 100*time.Millisecond)
+	// alloc_objects and alloc_space of heap and allocs, contentions and delay of mutex and block
+	require.Eventually(t, func() bool {
+		_, delta := countSampleTypes()
+		return delta == 8
+	}, 10*time.Second, 100*time.Millisecond)
 
 	collector.exit()
 	wg.Wait()
@@ -96,9 +122,189 @@ func TestCollectorRun(t *testing.T) {
 	require.Equal(t, nil, err)
 	require.Equal(t, 1, len(targets))
 
-	sampleTypes, err := store.ListSampleType()
-	require.Equal(t, 19, len(sampleTypes))
-
 	labels, err := store.ListLabel()
 	require.Equal(t, 3, len(labels))
 }
+
+func TestCollectorScrapeLoops(t *testing.T) {
+	target := TargetConfig{
+		Interval:  time.Minute,
+		Instances: []string{"localhost:9000", "localhost:9001"},
+		ProfileConfigs: map[string]ProfileConfig{
+			"heap":   {Interval: time.Hour, Expiration: time.Hour},
+			"fgprof": {Enable: utils.Bool(false)},
+		},
+	}
+	wg := &sync.WaitGroup{}
+	collector := newCollector("server", target, nil, wg)
+	collector.run()
+	defer func() {
+		collector.exit()
+		wg.Wait()
+	}()
+
+	// 7 enabled profile types of 2 instances
+	require.Equal(t, 14, len(collector.loops))
+	heap := collector.loops["heap/localhost:9000"]
+	require.Equal(t, time.Hour, heap.interval)
+	require.Equal(t, time.Minute, collector.loops["allocs/localhost:9000"].interval)
+	require.Equal(t, time.Hour, collector.newScrapeTask("heap", "localhost:9000").expiration())
+	require.Equal(t, time.Duration(0), collector.newScrapeTask("allocs", "localhost:9000").expiration())
+
+	// the offset is stable and in the interval
+	require.Equal(t, collector.jitter(heap), collector.jitter(heap))
+	require.Less(t, collector.jitter(heap), heap.interval)
+
+	// unchanged loops are kept, changed interval restarts the loop
+	target.Instances = []string{"localhost:9000"}
+	target.Interval = 2 * time.Minute
+	collector.reload(target)
+	require.Equal(t, 7, len(collector.loops))
+	require.Equal(t, heap, collector.loops["heap/localhost:9000"])
+	require.Equal(t, 2*time.Minute, collector.loops["allocs/localhost:9000"].interval)
+
+	// no loops are started after exit
+	collector.exit()
+	target.Instances = []string{"localhost:9000", "localhost:9002"}
+	collector.reload(target)
+	require.Equal(t, 7, len(collector.loops))
+}
+
+// newHeapServer Serve the heap profiles of the alloc_space values in order
+func newHeapServer(t *testing.T, allocs ...int64) *httptest.Server {
+	var mu sync.Mutex
+	i := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		alloc := allocs[i%len(allocs)]
+		i++
+		mu.Unlock()
+
+		main := &profile.Function{ID: 1, Name: "main"}
+		loc := &profile.Location{ID: 1, Line: []profile.Line{{Function: main}}}
+		p := &profile.Profile{
+			SampleType: []*profile.ValueType{{Type: "alloc_space", Unit: "bytes"}, {Type: "inuse_space", Unit: "bytes"}},
+			PeriodType: &profile.ValueType{Type: "space", Unit: "bytes"},
+			TimeNanos:  time.Now().UnixNano(),
+			Sample:     []*profile.Sample{{Location: []*profile.Location{loc}, Value: []int64{alloc, 1}}},
+			Location:   []*profile.Location{loc},
+			Function:   []*profile.Function{main},
+		}
+		require.NoError(t, p.Write(w))
+	}))
+}
+
+func TestCollectorDelta(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	store := badger.NewStore(badger.DefaultOptions(dir))
+	defer store.Release()
+
+	// restarted before the third scrape
+	server := newHeapServer(t, 10, 25, 5, 8)
+	defer server.Close()
+	instance := strings.TrimPrefix(server.URL, "http://")
+
+	target := TargetConfig{Instances: []string{instance}, MaxRetries: -1, ProfileConfigs: map[string]ProfileConfig{}}
+	for k, v := range defaultProfileConfigs() {
+		v.Enable = utils.Bool(k == "heap")
+		target.ProfileConfigs[k] = v
+	}
+	collector := newCollector("server", target, store, &sync.WaitGroup{})
+	for i := 0; i < 4; i++ {
+		collector.scrape("heap", instance)
+	}
+
+	sampleTypes, err := store.ListSampleType()
+	require.NoError(t, err)
+	require.Equal(t, []string{"heap_alloc_space", "heap_delta_alloc_space", "heap_inuse_space"}, sampleTypes)
+
+	metas, err := store.ListProfileMeta("heap_delta_alloc_space", time.Now().Add(-time.Minute), time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(metas))
+	values := make([]int64, 0)
+	for _, meta := range metas[0].ProfileMetas {
+		values = append(values, meta.Value)
+		require.Greater(t, meta.Duration, int64(0))
+	}
+	require.ElementsMatch(t, []int64{15, 3}, values)
+
+	metas, err = store.ListProfileMeta("heap_alloc_space", time.Now().Add(-time.Minute), time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 4, len(metas[0].ProfileMetas))
+
+	// the previous profiles of the stopped loops are removed
+	collector.run()
+	require.Equal(t, 1, len(collector.previous))
+	target.Instances = nil
+	collector.reload(target)
+	require.Empty(t, collector.previous)
+	collector.exit()
+}
+
+func TestCollectorDeltaOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	store := badger.NewStore(badger.DefaultOptions(dir))
+	defer store.Release()
+
+	server := newHeapServer(t, 10, 25)
+	defer server.Close()
+	instance := strings.TrimPrefix(server.URL, "http://")
+
+	target := TargetConfig{Instances: []string{instance}, MaxRetries: -1, ProfileConfigs: map[string]ProfileConfig{}}
+	for k, v := range defaultProfileConfigs() {
+		v.Enable = utils.Bool(k == "heap" || k == "mutex")
+		target.ProfileConfigs[k] = v
+	}
+	target.ProfileConfigs["heap"] = ProfileConfig{Cumulative: utils.Bool(false)}
+	target.ProfileConfigs["mutex"] = ProfileConfig{Path: "/debug/pprof/heap", Delta: utils.Bool(false)}
+	collector := newCollector("server", target, store, &sync.WaitGroup{})
+	collector.scrape("heap", instance)
+	collector.scrape("heap", instance)
+	collector.scrape("mutex", instance)
+	collector.scrape("mutex", instance)
+
+	// the alloc_space values are not cumulative sample types of mutex
+	sampleTypes, err := store.ListSampleType()
+	require.NoError(t, err)
+	require.Equal(t, []string{"heap_delta_alloc_space", "heap_inuse_space", "mutex_alloc_space", "mutex_inuse_space"}, sampleTypes)
+}
+
+func TestCollectorFunctions(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	store := badger.NewStore(badger.DefaultOptions(dir))
+	defer store.Release()
+
+	server := newHeapServer(t, 10)
+	defer server.Close()
+	instance := strings.TrimPrefix(server.URL, "http://")
+
+	target := TargetConfig{Instances: []string{instance}, MaxRetries: -1, ProfileConfigs: map[string]ProfileConfig{
+		"heap":      {},
+		"goroutine": {Path: "/debug/pprof/heap"},
+	}}
+	collector := newCollector("server", target, store, &sync.WaitGroup{})
+	collector.scrape("heap", instance)
+	collector.scrape("goroutine", instance)
+
+	// the function summaries and top functions of the goroutine profiles are not saved by default
+	for sampleType, saved := range map[string]bool{"heap_inuse_space": true, "goroutine_inuse_space": false} {
+		metas, err := store.ListProfileMeta(sampleType, time.Now().Add(-time.Minute), time.Now().Add(time.Minute))
+		require.NoError(t, err)
+		require.Equal(t, 1, len(metas))
+		_, err = store.GetFunctionSummary(metas[0].ProfileMetas[0].ProfileID)
+		_, topErr := store.GetTopFunctions(metas[0].ProfileMetas[0].ProfileID)
+		if saved {
+			require.NoError(t, err)
+			require.NoError(t, topErr)
+		} else {
+			require.ErrorIs(t, err, storage.ErrFunctionSummaryNotFound)
+			require.ErrorIs(t, topErr, storage.ErrTopFunctionsNotFound)
+		}
+	}
+}
@@ -1,12 +1,13 @@
 package collector
 
 import (
+	"context"
 	"fmt"
 	"time"
 
-	"github.com/fsnotify/fsnotify"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"github.com/xyctruth/profiler/pkg/collector/discovery"
 	"github.com/xyctruth/profiler/pkg/storage"
 	"github.com/xyctruth/profiler/pkg/utils"
 )
@@ -28,16 +29,21 @@ func LoadConfig(configPath string, fn func(CollectorConfig)) error {
 		return fmt.Errorf("fatal error config CollectorConfig: %w", err)
 	}
 
-	conf.OnConfigChange(func(in fsnotify.Event) {
+	err = utils.WatchFile(context.Background(), configPath, func() {
 		var newConfig CollectorConfig
-		if err = conf.UnmarshalKey("collector", &newConfig); err != nil {
-			log.Error("Fatal error config CollectorConfig: %w")
+		if err := conf.ReadInConfig(); err != nil {
+			log.WithError(err).Error("Fatal error config file")
+			return
+		}
+		if err := conf.UnmarshalKey("collector", &newConfig); err != nil {
+			log.WithError(err).Error("Fatal error config CollectorConfig")
 			return
 		}
 		fn(newConfig)
 	})
-
-	conf.WatchConfig()
+	if err != nil {
+		return fmt.Errorf("watch config file: %w", err)
+	}
 	fn(config)
 
 	return nil
@@ -50,15 +56,57 @@ type Config struct {
 type CollectorConfig struct {
 	//key TargetName
 	TargetConfigs map[string]TargetConfig `yaml:"targetConfigs"`
+	// KubernetesSD Discover target instances from kubernetes pods
+	KubernetesSD []discovery.KubernetesConfig `yaml:"kubernetesSD"`
+	// FileSD Discover target instances from json or yaml target files
+	FileSD []discovery.FileConfig `yaml:"fileSD"`
+	// DiscoveryTargetConfig Configuration of the targets only found by service discovery, e.g. interval, expiration
+	// and profileConfigs, the instances and dnsSD are ignored. The default interval is 15s
+	DiscoveryTargetConfig TargetConfig `yaml:"discoveryTargetConfig"`
+	// MaxConcurrency Max concurrent scrapes of all targets, no limit when <= 0
+	MaxConcurrency int `yaml:"maxConcurrency"`
 }
 
 type TargetConfig struct {
-	//key is profile name (profile, fgprof, mutex, heap, goroutine, allocs, block, threadcreate)
+	//key is profile name (profile, fgprof, mutex, heap, goroutine, allocs, block, threadcreate, trace, goroutinedump)
 	ProfileConfigs map[string]ProfileConfig `yaml:"profileConfigs"`
 	Interval       time.Duration            `yaml:"interval"`
 	Expiration     time.Duration            `yaml:"expiration"`
 	Instances      []string                 `yaml:"instances"`
 	Labels         LabelConfig              `yaml:"labels"`
+	// ScrapeTimeout Timeout of each scrape request, default is the seconds parameter of the profile path + 10s
+	ScrapeTimeout time.Duration `yaml:"scrapeTimeout"`
+	// MaxRetries Retries of the failed scrapes caused by transient errors, default 2, negative disables retry
+	MaxRetries int `yaml:"maxRetries"`
+	// MaxConcurrency Max concurrent scrapes of the target, no limit when <= 0
+	MaxConcurrency int `yaml:"maxConcurrency"`
+	// Scheme of the pprof endpoints, http (default) or https
+	Scheme          string            `yaml:"scheme"`
+	BasicAuth       *BasicAuth        `yaml:"basicAuth"`
+	BearerToken     string            `yaml:"bearerToken"`
+	BearerTokenFile string            `yaml:"bearerTokenFile"`
+	Headers         map[string]string `yaml:"headers"`
+	TLSConfig       TLSConfig         `yaml:"tlsConfig"`
+	// DNSSD Resolve instances from dns records periodically
+	DNSSD *discovery.DNSConfig `yaml:"dnsSD"`
+	// InstanceLabels Labels of instances found by service discovery, key is instance
+	InstanceLabels map[string]LabelConfig `yaml:"-"`
+}
+
+// instanceLabels Labels of the target merged with the labels of the instance
+func (t TargetConfig) instanceLabels(instance string) LabelConfig {
+	labels, ok := t.InstanceLabels[instance]
+	if !ok {
+		return t.Labels
+	}
+	merged := make(LabelConfig, len(t.Labels)+len(labels))
+	for k, v := range t.Labels {
+		merged[k] = v
+	}
+	for k, v := range labels {
+		merged[k] = v
+	}
+	return merged
 }
 
 type LabelConfig map[string]string
@@ -77,43 +125,85 @@ func (t LabelConfig) ToArray() []storage.Label {
 type ProfileConfig struct {
 	Path   string `yaml:"path"`
 	Enable *bool  `yaml:"enable"`
+	// Interval Scrape interval of the profile type, default is the interval of the target
+	Interval time.Duration `yaml:"interval"`
+	// Expiration of the profile type, default is the expiration of the target
+	Expiration time.Duration `yaml:"expiration"`
+	// Delta Save the delta between the scrapes of the cumulative sample types (alloc_* of heap and allocs,
+	// mutex and block) as the delta_* sample types, default true
+	Delta *bool `yaml:"delta"`
+	// Cumulative Save the cumulative sample types, default true
+	Cumulative *bool `yaml:"cumulative"`
+	// Functions Save the function summary and the top functions with the profiles for the function series,
+	// top functions and function alert rules, default true except goroutine, threadcreate and goroutinedump
+	Functions *bool `yaml:"functions"`
+}
+
+func (config ProfileConfig) delta() bool {
+	return config.Delta != nil && *config.Delta
+}
+
+func (config ProfileConfig) cumulative() bool {
+	return config.Cumulative == nil || *config.Cumulative
+}
+
+func (config ProfileConfig) functions() bool {
+	return config.Functions != nil && *config.Functions
 }
 
 // defaultProfileConfigs The default fetching profile config
 func defaultProfileConfigs() map[string]ProfileConfig {
 	return map[string]ProfileConfig{
 		"profile": {
-			Path:   "/debug/pprof/profile?seconds=10",
-			Enable: utils.BoolPtr(true),
+			Path:      "/debug/pprof/profile?seconds=10",
+			Enable:    utils.BoolPtr(true),
+			Functions: utils.BoolPtr(true),
 		},
 		"fgprof": {
-			Path:   "/debug/fgprof?seconds=10",
-			Enable: utils.BoolPtr(true),
+			Path:      "/debug/fgprof?seconds=10",
+			Enable:    utils.BoolPtr(true),
+			Functions: utils.BoolPtr(true),
 		},
 		"mutex": {
-			Path:   "/debug/pprof/mutex",
-			Enable: utils.BoolPtr(true),
+			Path:       "/debug/pprof/mutex",
+			Enable:     utils.BoolPtr(true),
+			Delta:      utils.BoolPtr(true),
+			Cumulative: utils.BoolPtr(true),
+			Functions:  utils.BoolPtr(true),
 		},
 		"heap": {
-			Path:   "/debug/pprof/heap",
-			Enable: utils.BoolPtr(true),
+			Path:       "/debug/pprof/heap",
+			Enable:     utils.BoolPtr(true),
+			Delta:      utils.BoolPtr(true),
+			Cumulative: utils.BoolPtr(true),
+			Functions:  utils.BoolPtr(true),
 		},
 		"goroutine": {
 			Path:   "/debug/pprof/goroutine",
 			Enable: utils.BoolPtr(true),
 		},
 		"allocs": {
-			Path:   "/debug/pprof/allocs",
-			Enable: utils.BoolPtr(true),
+			Path:       "/debug/pprof/allocs",
+			Enable:     utils.BoolPtr(true),
+			Delta:      utils.BoolPtr(true),
+			Cumulative: utils.BoolPtr(true),
+			Functions:  utils.BoolPtr(true),
 		},
 		"block": {
-			Path:   "/debug/pprof/block",
-			Enable: utils.BoolPtr(true),
+			Path:       "/debug/pprof/block",
+			Enable:     utils.BoolPtr(true),
+			Delta:      utils.BoolPtr(true),
+			Cumulative: utils.BoolPtr(true),
+			Functions:  utils.BoolPtr(true),
 		},
 		"threadcreate": {
 			Path:   "/debug/pprof/threadcreate",
 			Enable: utils.BoolPtr(true),
 		},
+		"goroutinedump": {
+			Path:   "/debug/pprof/goroutine?debug=2",
+			Enable: utils.BoolPtr(false),
+		},
 		"trace": {
 			Path:   "/debug/pprof/trace?seconds=10",
 			Enable: utils.BoolPtr(false),
@@ -137,6 +227,15 @@ func buildProfileConfigs(profileConfig map[string]ProfileConfig) map[string]Prof
 			if config.Enable == nil {
 				config.Enable = defaultConfig.Enable
 			}
+			if config.Delta == nil {
+				config.Delta = defaultConfig.Delta
+			}
+			if config.Cumulative == nil {
+				config.Cumulative = defaultConfig.Cumulative
+			}
+			if config.Functions == nil {
+				config.Functions = defaultConfig.Functions
+			}
 			profiles[key] = config
 			continue
 		}
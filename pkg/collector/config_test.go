@@ -220,7 +220,7 @@ func TestBuildProfileConfigs(t *testing.T) {
 
 	profileConfigs := buildProfileConfigs(serverConfig.ProfileConfigs)
 
-	require.Equal(t, len(profileConfigs), 9)
+	require.Equal(t, len(profileConfigs), 10)
 
 	require.Equal(t, defaultProfileConfigs()["fgprof"].Path, profileConfigs["fgprof"].Path)
 	require.Equal(t, utils.Bool(false), profileConfigs["fgprof"].Enable)
@@ -231,3 +231,34 @@ func TestBuildProfileConfigs(t *testing.T) {
 	require.Equal(t, defaultProfileConfigs()["heap"].Path, profileConfigs["heap"].Path)
 	require.Equal(t, utils.Bool(true), profileConfigs["heap"].Enable)
 }
+
+func TestLoadDiscoveryConfig(t *testing.T) {
+	file, err := ioutil.TempFile("./", "temp-*.yaml")
+	require.NoError(t, err)
+	defer os.Remove(file.Name())
+	_, err = file.Write([]byte(`
+collector:
+  kubernetesSD:
+    - namespaces: ["default", "profiler-system"]
+      labelSelector: app=server
+      port: 6060
+  discoveryTargetConfig:
+    interval: 1m
+    expiration: 24h
+  targetConfigs:
+    profiler-server:
+      interval: 2s
+      instances: ["localhost:9000"]
+`))
+	require.NoError(t, err)
+
+	err = LoadConfig(file.Name(), func(config CollectorConfig) {
+		require.Equal(t, 1, len(config.KubernetesSD))
+		require.Equal(t, []string{"default", "profiler-system"}, config.KubernetesSD[0].Namespaces)
+		require.Equal(t, "app=server", config.KubernetesSD[0].LabelSelector)
+		require.Equal(t, 6060, config.KubernetesSD[0].Port)
+		require.Equal(t, time.Minute, config.DiscoveryTargetConfig.Interval)
+		require.Equal(t, 24*time.Hour, config.DiscoveryTargetConfig.Expiration)
+	})
+	require.NoError(t, err)
+}
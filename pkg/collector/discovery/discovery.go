@@ -0,0 +1,18 @@
+package discovery
+
+import "context"
+
+// TargetGroup A set of instances discovered for a target, all instances share the same labels
+type TargetGroup struct {
+	// Source Identifies the group inside its discoverer, e.g. namespace/pod
+	Source    string
+	Target    string
+	Instances []string
+	Labels    map[string]string
+}
+
+// Discoverer Provide target groups of a service discovery mechanism
+type Discoverer interface {
+	// Run Send the full list of target groups to up every time it changes, until ctx is done
+	Run(ctx context.Context, up chan<- []*TargetGroup)
+}
@@ -0,0 +1,152 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultDNSRefreshInterval = 30 * time.Second
+
+	DNSTypeSRV  = "SRV"
+	DNSTypeA    = "A"
+	DNSTypeAAAA = "AAAA"
+)
+
+type DNSConfig struct {
+	Names []string `yaml:"names"`
+	// Type of the dns records, SRV (default), A or AAAA
+	Type string `yaml:"type"`
+	// Port of the instances found by A and AAAA records
+	Port int `yaml:"port"`
+	// Refresh interval, default 30s
+	Refresh time.Duration `yaml:"refresh"`
+}
+
+// Resolver Look up dns records, implemented by *net.Resolver
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// DNSDiscovery Discover scrape instances of a target from dns records
+type DNSDiscovery struct {
+	target   string
+	config   DNSConfig
+	resolver Resolver
+	// instances The last resolved instances of each name
+	instances map[string][]string
+	log       *log.Entry
+}
+
+func NewDNSDiscovery(target string, config DNSConfig, resolver Resolver) (*DNSDiscovery, error) {
+	config.Type = strings.ToUpper(config.Type)
+	if config.Type == "" {
+		config.Type = DNSTypeSRV
+	}
+	switch config.Type {
+	case DNSTypeSRV:
+	case DNSTypeA, DNSTypeAAAA:
+		if config.Port == 0 {
+			return nil, fmt.Errorf("port is required for %s records", config.Type)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported dns record type %s", config.Type)
+	}
+	if config.Refresh <= 0 {
+		config.Refresh = defaultDNSRefreshInterval
+	}
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	return &DNSDiscovery{
+		target:    target,
+		config:    config,
+		resolver:  resolver,
+		instances: make(map[string][]string),
+		log:       log.WithFields(log.Fields{"discovery": "dns", "target": target}),
+	}, nil
+}
+
+func (d *DNSDiscovery) Run(ctx context.Context, up chan<- []*TargetGroup) {
+	ticker := time.NewTicker(d.config.Refresh)
+	defer ticker.Stop()
+
+	var last []*TargetGroup
+	for {
+		groups := d.refresh(ctx)
+		if last == nil || !reflect.DeepEqual(groups, last) {
+			select {
+			case <-ctx.Done():
+				return
+			case up <- groups:
+			}
+			last = groups
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refresh Resolve all names, a name that fails to resolve keeps its previous instances
+func (d *DNSDiscovery) refresh(ctx context.Context) []*TargetGroup {
+	groups := make([]*TargetGroup, 0, len(d.config.Names))
+	for _, name := range d.config.Names {
+		instances, err := d.lookup(ctx, name)
+		if err != nil {
+			d.log.WithError(err).Error("dns lookup error ", name)
+			instances = d.instances[name]
+		} else {
+			d.instances[name] = instances
+		}
+		groups = append(groups, &TargetGroup{
+			Source:    name,
+			Target:    d.target,
+			Instances: instances,
+		})
+	}
+	return groups
+}
+
+func (d *DNSDiscovery) lookup(ctx context.Context, name string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.config.Refresh)
+	defer cancel()
+
+	instances := make([]string, 0)
+	if d.config.Type == DNSTypeSRV {
+		_, records, err := d.resolver.LookupSRV(ctx, "", "", name)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			host := strings.TrimSuffix(record.Target, ".")
+			instances = append(instances, net.JoinHostPort(host, strconv.Itoa(int(record.Port))))
+		}
+		return instances, nil
+	}
+
+	addrs, err := d.resolver.LookupIPAddr(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		isIPv4 := addr.IP.To4() != nil
+		if (d.config.Type == DNSTypeA) != isIPv4 {
+			continue
+		}
+		instances = append(instances, net.JoinHostPort(addr.IP.String(), strconv.Itoa(d.config.Port)))
+	}
+	return instances, nil
+}
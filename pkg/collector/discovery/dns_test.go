@@ -0,0 +1,169 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// stubDNSServer Answer SRV, A and AAAA questions from static records
+type stubDNSServer struct {
+	conn net.PacketConn
+	mu   sync.Mutex
+	srv  map[string][]dnsmessage.SRVResource
+	a    map[string][]dnsmessage.AResource
+	aaaa map[string][]dnsmessage.AAAAResource
+}
+
+func newStubDNSServer(t *testing.T) *stubDNSServer {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &stubDNSServer{
+		conn: conn,
+		srv:  make(map[string][]dnsmessage.SRVResource),
+		a:    make(map[string][]dnsmessage.AResource),
+		aaaa: make(map[string][]dnsmessage.AAAAResource),
+	}
+	go s.serve()
+	return s
+}
+
+func (s *stubDNSServer) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		var msg dnsmessage.Message
+		if err = msg.Unpack(buf[:n]); err != nil || len(msg.Questions) == 0 {
+			continue
+		}
+		resp, err := s.answer(msg)
+		if err != nil {
+			continue
+		}
+		_, _ = s.conn.WriteTo(resp, addr)
+	}
+}
+
+func (s *stubDNSServer) answer(msg dnsmessage.Message) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q := msg.Questions[0]
+	name := q.Name.String()
+	header := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 1}
+
+	found := false
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: msg.ID, Response: true, Authoritative: true})
+	b.EnableCompression()
+	_ = b.StartQuestions()
+	_ = b.Question(q)
+	_ = b.StartAnswers()
+	switch q.Type {
+	case dnsmessage.TypeSRV:
+		for _, r := range s.srv[name] {
+			found = true
+			_ = b.SRVResource(header, r)
+		}
+	case dnsmessage.TypeA:
+		for _, r := range s.a[name] {
+			found = true
+			_ = b.AResource(header, r)
+		}
+	case dnsmessage.TypeAAAA:
+		for _, r := range s.aaaa[name] {
+			found = true
+			_ = b.AAAAResource(header, r)
+		}
+	}
+	if !found && len(s.srv[name])+len(s.a[name])+len(s.aaaa[name]) == 0 {
+		b = dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: msg.ID, Response: true, RCode: dnsmessage.RCodeNameError})
+		_ = b.StartQuestions()
+		_ = b.Question(q)
+	}
+	return b.Finish()
+}
+
+func (s *stubDNSServer) resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "udp", s.conn.LocalAddr().String())
+		},
+	}
+}
+
+func TestDNSDiscoverySRV(t *testing.T) {
+	s := newStubDNSServer(t)
+	defer s.conn.Close()
+	s.mu.Lock()
+	s.srv["server.service.consul."] = []dnsmessage.SRVResource{
+		{Target: dnsmessage.MustNewName("node1.node.consul."), Port: 6060},
+		{Target: dnsmessage.MustNewName("node2.node.consul."), Port: 6061},
+	}
+	s.mu.Unlock()
+
+	d, err := NewDNSDiscovery("server", DNSConfig{Names: []string{"server.service.consul", "notfound.service.consul"}}, s.resolver())
+	require.NoError(t, err)
+	require.Equal(t, DNSTypeSRV, d.config.Type)
+	require.Equal(t, defaultDNSRefreshInterval, d.config.Refresh)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	groups := d.refresh(ctx)
+	require.Equal(t, 2, len(groups))
+	require.Equal(t, "server", groups[0].Target)
+	require.ElementsMatch(t, []string{"node1.node.consul:6060", "node2.node.consul:6061"}, groups[0].Instances)
+	require.Equal(t, 0, len(groups[1].Instances))
+
+	// failed lookup keeps the previous instances
+	s.mu.Lock()
+	s.srv = map[string][]dnsmessage.SRVResource{}
+	s.mu.Unlock()
+	groups = d.refresh(ctx)
+	require.ElementsMatch(t, []string{"node1.node.consul:6060", "node2.node.consul:6061"}, groups[0].Instances)
+}
+
+func TestDNSDiscoveryA(t *testing.T) {
+	s := newStubDNSServer(t)
+	defer s.conn.Close()
+	s.mu.Lock()
+	s.a["server.default.svc."] = []dnsmessage.AResource{{A: [4]byte{10, 0, 0, 1}}, {A: [4]byte{10, 0, 0, 2}}}
+	s.aaaa["server.default.svc."] = []dnsmessage.AAAAResource{{AAAA: [16]byte{15: 1}}}
+	s.mu.Unlock()
+
+	d, err := NewDNSDiscovery("server", DNSConfig{Names: []string{"server.default.svc"}, Type: "a", Port: 6060}, s.resolver())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	up := make(chan []*TargetGroup)
+	go d.Run(ctx, up)
+
+	groups := receive(t, up)
+	require.Equal(t, 1, len(groups))
+	require.ElementsMatch(t, []string{"10.0.0.1:6060", "10.0.0.2:6060"}, groups[0].Instances)
+
+	d, err = NewDNSDiscovery("server", DNSConfig{Names: []string{"server.default.svc"}, Type: DNSTypeAAAA, Port: 6060}, s.resolver())
+	require.NoError(t, err)
+	groups = d.refresh(ctx)
+	require.Equal(t, []string{"[::1]:6060"}, groups[0].Instances)
+}
+
+func TestNewDNSDiscoveryError(t *testing.T) {
+	_, err := NewDNSDiscovery("server", DNSConfig{Type: DNSTypeA}, nil)
+	require.Error(t, err)
+
+	_, err = NewDNSDiscovery("server", DNSConfig{Type: "MX"}, nil)
+	require.Error(t, err)
+
+	d, err := NewDNSDiscovery("server", DNSConfig{}, nil)
+	require.NoError(t, err)
+	require.Equal(t, net.DefaultResolver, d.resolver)
+}
@@ -0,0 +1,214 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xyctruth/profiler/pkg/utils"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const defaultFileRefreshInterval = 5 * time.Minute
+
+type FileConfig struct {
+	// Files Glob patterns of the target files, .json .yml .yaml
+	Files []string `yaml:"files"`
+	// RefreshInterval Re-read the files periodically in case of missed change events, default 5m
+	RefreshInterval time.Duration `yaml:"refreshInterval"`
+}
+
+// fileGroup The target group format in target files
+type fileGroup struct {
+	Target    string            `yaml:"target" json:"target"`
+	Instances []string          `yaml:"instances" json:"instances"`
+	Labels    map[string]string `yaml:"labels" json:"labels"`
+}
+
+// FileDiscovery Discover scrape instances from target files
+type FileDiscovery struct {
+	config FileConfig
+	// groups The target groups of each file, key is file path
+	groups map[string][]*TargetGroup
+	last   []*TargetGroup
+	log    *log.Entry
+}
+
+func NewFileDiscovery(config FileConfig) *FileDiscovery {
+	if config.RefreshInterval <= 0 {
+		config.RefreshInterval = defaultFileRefreshInterval
+	}
+	// the event names of the watcher are clean, ./targets/*.json must match targets/a.json
+	files := make([]string, 0, len(config.Files))
+	for _, pattern := range config.Files {
+		files = append(files, filepath.Clean(pattern))
+	}
+	config.Files = files
+	return &FileDiscovery{
+		config: config,
+		groups: make(map[string][]*TargetGroup),
+		log:    log.WithField("discovery", "file"),
+	}
+}
+
+func (d *FileDiscovery) Run(ctx context.Context, up chan<- []*TargetGroup) {
+	changed := make(chan struct{}, 1)
+	err := utils.WatchFiles(ctx, d.watchDirs(), d.match, func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+	if err != nil {
+		d.log.WithError(err).Error("create file watcher error, only refresh periodically")
+	}
+
+	ticker := time.NewTicker(d.config.RefreshInterval)
+	defer ticker.Stop()
+
+	groups, _ := d.refresh()
+	for {
+		if groups != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case up <- groups:
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-changed:
+		}
+
+		var ok bool
+		if groups, ok = d.refresh(); !ok {
+			groups = nil
+		}
+	}
+}
+
+// watchDirs The directories of the glob patterns, the directories with wildcards are expanded when Run starts,
+// the directories created later are found by the periodic refresh
+func (d *FileDiscovery) watchDirs() []string {
+	dirs := make([]string, 0, len(d.config.Files))
+	seen := make(map[string]struct{})
+	for _, pattern := range d.config.Files {
+		matches := []string{filepath.Dir(pattern)}
+		if strings.ContainsAny(matches[0], "*?[") {
+			var err error
+			if matches, err = filepath.Glob(matches[0]); err != nil {
+				d.log.WithError(err).Error("invalid file pattern ", pattern)
+				continue
+			}
+		}
+		for _, dir := range matches {
+			if _, ok := seen[dir]; ok {
+				continue
+			}
+			seen[dir] = struct{}{}
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+func (d *FileDiscovery) match(path string) bool {
+	for _, pattern := range d.config.Files {
+		if ok, _ := filepath.Match(pattern, path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// refresh Read all target files, return the target groups and whether they changed since the last refresh
+// A file that fails to read or parse keeps its previous target groups
+func (d *FileDiscovery) refresh() ([]*TargetGroup, bool) {
+	paths := make(map[string]struct{})
+	for _, pattern := range d.config.Files {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			d.log.WithError(err).Error("invalid file pattern ", pattern)
+			continue
+		}
+		for _, path := range matches {
+			paths[path] = struct{}{}
+		}
+	}
+
+	for path := range d.groups {
+		if _, ok := paths[path]; !ok {
+			delete(d.groups, path)
+		}
+	}
+
+	for path := range paths {
+		groups, err := readTargetFile(path)
+		if err != nil {
+			d.log.WithError(err).Error("read target file error ", path)
+			continue
+		}
+		d.groups[path] = groups
+	}
+
+	files := make([]string, 0, len(d.groups))
+	for path := range d.groups {
+		files = append(files, path)
+	}
+	sort.Strings(files)
+
+	groups := make([]*TargetGroup, 0)
+	for _, path := range files {
+		groups = append(groups, d.groups[path]...)
+	}
+
+	if reflect.DeepEqual(groups, d.last) {
+		return groups, false
+	}
+	d.last = groups
+	return groups, true
+}
+
+func readTargetFile(path string) ([]*TargetGroup, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileGroups []fileGroup
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		err = json.Unmarshal(content, &fileGroups)
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(content, &fileGroups)
+	default:
+		return nil, fmt.Errorf("unsupported target file extension %s", ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([]*TargetGroup, 0, len(fileGroups))
+	for i, g := range fileGroups {
+		if g.Target == "" {
+			return nil, fmt.Errorf("target of group %d is empty", i)
+		}
+		groups = append(groups, &TargetGroup{
+			Source:    fmt.Sprintf("%s:%d", path, i),
+			Target:    g.Target,
+			Instances: g.Instances,
+			Labels:    g.Labels,
+		})
+	}
+	return groups, nil
+}
@@ -0,0 +1,122 @@
+package discovery
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileDiscovery(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	jsonFile := filepath.Join(dir, "a.json")
+	yamlFile := filepath.Join(dir, "b.yaml")
+
+	err = ioutil.WriteFile(jsonFile, []byte(`[{"target":"server","instances":["localhost:9000","localhost:9001"],"labels":{"env":"prod"}}]`), 0600)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(yamlFile, []byte(`
+- target: server2
+  instances: ["localhost:9002"]
+  labels:
+    zone: 1
+`), 0600)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(filepath.Join(dir, "c.txt"), []byte(`ignored`), 0600)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	up := make(chan []*TargetGroup)
+	d := NewFileDiscovery(FileConfig{Files: []string{filepath.Join(dir, "*.json"), filepath.Join(dir, "*.yaml")}})
+	go d.Run(ctx, up)
+
+	groups := receive(t, up)
+	require.Equal(t, 2, len(groups))
+	require.Equal(t, "server", groups[0].Target)
+	require.Equal(t, []string{"localhost:9000", "localhost:9001"}, groups[0].Instances)
+	require.Equal(t, map[string]string{"env": "prod"}, groups[0].Labels)
+	require.Equal(t, "server2", groups[1].Target)
+	require.Equal(t, map[string]string{"zone": "1"}, groups[1].Labels)
+
+	err = ioutil.WriteFile(jsonFile, []byte(`[{"target":"server","instances":["localhost:9000"]}]`), 0600)
+	require.NoError(t, err)
+	groups = receive(t, up)
+	require.Equal(t, 2, len(groups))
+	require.Equal(t, []string{"localhost:9000"}, groups[0].Instances)
+
+	// invalid file keeps the previous target groups
+	err = ioutil.WriteFile(yamlFile, []byte(`- target: [`), 0600)
+	require.NoError(t, err)
+	err = os.Remove(jsonFile)
+	require.NoError(t, err)
+	groups = receive(t, up)
+	require.Equal(t, 1, len(groups))
+	require.Equal(t, "server2", groups[0].Target)
+}
+
+func TestFileDiscoveryRefresh(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	d := NewFileDiscovery(FileConfig{Files: []string{filepath.Join(dir, "*.yml")}})
+	require.Equal(t, defaultFileRefreshInterval, d.config.RefreshInterval)
+
+	groups, changed := d.refresh()
+	require.Equal(t, 0, len(groups))
+	require.True(t, changed)
+
+	_, changed = d.refresh()
+	require.False(t, changed)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "a.yml"), []byte(`- instances: ["localhost:9000"]`), 0600)
+	require.NoError(t, err)
+	groups, changed = d.refresh()
+	require.Equal(t, 0, len(groups))
+	require.False(t, changed)
+
+	_, err = readTargetFile(filepath.Join(dir, "a.yml"))
+	require.Error(t, err)
+}
+
+func TestFileDiscoveryWatchPatterns(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	jsonFile := filepath.Join(dir, "a.json")
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "prod"), 0700))
+	nestedFile := filepath.Join(dir, "prod", "targets.yaml")
+	err = ioutil.WriteFile(jsonFile, []byte(`[{"target":"server","instances":["localhost:9000"]}]`), 0600)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(nestedFile, []byte(`[{"target":"server2","instances":["localhost:9002"]}]`), 0600)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	up := make(chan []*TargetGroup)
+	d := NewFileDiscovery(FileConfig{
+		Files:           []string{"./" + dir + "/*.json", filepath.Join(dir, "*", "targets.yaml")},
+		RefreshInterval: time.Hour,
+	})
+	go d.Run(ctx, up)
+	require.Equal(t, 2, len(receive(t, up)))
+
+	// the changes are watched before the refresh
+	err = ioutil.WriteFile(jsonFile, []byte(`[{"target":"server","instances":["localhost:9001"]}]`), 0600)
+	require.NoError(t, err)
+	groups := receive(t, up)
+	require.Equal(t, []string{"localhost:9001"}, groups[0].Instances)
+
+	err = ioutil.WriteFile(nestedFile, []byte(`[{"target":"server2","instances":["localhost:9003"]}]`), 0600)
+	require.NoError(t, err)
+	groups = receive(t, up)
+	require.Equal(t, []string{"localhost:9003"}, groups[1].Instances)
+}
@@ -0,0 +1,209 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// AnnotationScrape Pods annotated with "true" are scraped, "false" excludes a pod selected by labelSelector
+	AnnotationScrape = "profiler.io/scrape"
+	// AnnotationTarget Target name of the pod, default is the pod label app.kubernetes.io/name or app
+	AnnotationTarget = "profiler.io/target"
+	// AnnotationPort Port of the pprof endpoint, default is KubernetesConfig.Port or the container port named pprof
+	AnnotationPort = "profiler.io/port"
+
+	defaultPortName = "pprof"
+)
+
+type KubernetesConfig struct {
+	// Kubeconfig file path, use the in-cluster config when empty
+	Kubeconfig string `yaml:"kubeconfig"`
+	// Namespaces to watch, all namespaces when empty
+	Namespaces []string `yaml:"namespaces"`
+	// LabelSelector Select pods by label, when empty only pods annotated with profiler.io/scrape: "true" are selected
+	LabelSelector string `yaml:"labelSelector"`
+	Port          int    `yaml:"port"`
+}
+
+// KubernetesDiscovery Discover scrape instances from kubernetes pods
+type KubernetesDiscovery struct {
+	client kubernetes.Interface
+	config KubernetesConfig
+	log    *log.Entry
+}
+
+func NewKubernetesDiscovery(config KubernetesConfig) (*KubernetesDiscovery, error) {
+	var restConfig *rest.Config
+	var err error
+	if config.Kubeconfig != "" {
+		restConfig, err = clientcmd.BuildConfigFromFlags("", config.Kubeconfig)
+	} else {
+		restConfig, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	return newKubernetesDiscovery(client, config), nil
+}
+
+func newKubernetesDiscovery(client kubernetes.Interface, config KubernetesConfig) *KubernetesDiscovery {
+	return &KubernetesDiscovery{
+		client: client,
+		config: config,
+		log:    log.WithField("discovery", "kubernetes"),
+	}
+}
+
+func (d *KubernetesDiscovery) Run(ctx context.Context, up chan<- []*TargetGroup) {
+	namespaces := d.config.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+
+	changed := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { notify() },
+		UpdateFunc: func(oldObj, newObj interface{}) { notify() },
+		DeleteFunc: func(obj interface{}) { notify() },
+	}
+
+	stores := make([]cache.Store, 0, len(namespaces))
+	for _, namespace := range namespaces {
+		factory := informers.NewSharedInformerFactoryWithOptions(d.client, 0,
+			informers.WithNamespace(namespace),
+			informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+				options.LabelSelector = d.config.LabelSelector
+			}))
+		informer := factory.Core().V1().Pods().Informer()
+		if _, err := informer.AddEventHandler(handler); err != nil {
+			d.log.WithError(err).Error("add pod event handler error")
+			return
+		}
+		factory.Start(ctx.Done())
+		if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+			return
+		}
+		stores = append(stores, informer.GetStore())
+	}
+	d.log.Info("kubernetes discovery synced")
+	notify()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+			groups := make([]*TargetGroup, 0)
+			for _, store := range stores {
+				for _, obj := range store.List() {
+					if pod, ok := obj.(*corev1.Pod); ok {
+						if group := d.buildGroup(pod); group != nil {
+							groups = append(groups, group)
+						}
+					}
+				}
+			}
+			sort.Slice(groups, func(i, j int) bool { return groups[i].Source < groups[j].Source })
+
+			select {
+			case <-ctx.Done():
+				return
+			case up <- groups:
+			}
+		}
+	}
+}
+
+// buildGroup Build the target group of a pod, return nil if the pod should not be scraped
+func (d *KubernetesDiscovery) buildGroup(pod *corev1.Pod) *TargetGroup {
+	scrape := pod.Annotations[AnnotationScrape]
+	if scrape == "false" || (d.config.LabelSelector == "" && scrape != "true") {
+		return nil
+	}
+	if pod.Status.PodIP == "" || pod.Status.Phase != corev1.PodRunning {
+		return nil
+	}
+
+	logEntry := d.log.WithFields(log.Fields{"namespace": pod.Namespace, "pod": pod.Name})
+
+	target := pod.Annotations[AnnotationTarget]
+	if target == "" {
+		target = pod.Labels["app.kubernetes.io/name"]
+	}
+	if target == "" {
+		target = pod.Labels["app"]
+	}
+	if target == "" {
+		logEntry.Warn("pod target name not found, skip it")
+		return nil
+	}
+
+	port, container := d.resolvePort(pod)
+	if port == 0 {
+		logEntry.Warn("pod pprof port not found, skip it")
+		return nil
+	}
+
+	return &TargetGroup{
+		Source:    pod.Namespace + "/" + pod.Name,
+		Target:    target,
+		Instances: []string{net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(port))},
+		Labels: map[string]string{
+			"namespace": pod.Namespace,
+			"pod":       pod.Name,
+			"container": container,
+			"node":      pod.Spec.NodeName,
+		},
+	}
+}
+
+// resolvePort Resolve pprof port of the pod and the container that declares it
+func (d *KubernetesDiscovery) resolvePort(pod *corev1.Pod) (int, string) {
+	port := d.config.Port
+	if v, ok := pod.Annotations[AnnotationPort]; ok {
+		p, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, ""
+		}
+		port = p
+	}
+
+	for _, container := range pod.Spec.Containers {
+		for _, containerPort := range container.Ports {
+			if port == 0 && containerPort.Name == defaultPortName {
+				return int(containerPort.ContainerPort), container.Name
+			}
+			if port != 0 && int(containerPort.ContainerPort) == port {
+				return port, container.Name
+			}
+		}
+	}
+
+	if port == 0 || len(pod.Spec.Containers) == 0 {
+		return port, ""
+	}
+	return port, pod.Spec.Containers[0].Name
+}
@@ -0,0 +1,128 @@
+package discovery
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newPod(name string, labels, annotations map[string]string, ports ...corev1.ContainerPort) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			Labels:      labels,
+			Annotations: annotations,
+		},
+		Spec: corev1.PodSpec{
+			NodeName: "node-1",
+			Containers: []corev1.Container{
+				{Name: "sidecar"},
+				{Name: "app", Ports: ports},
+			},
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+			PodIP: "10.0.0.1",
+		},
+	}
+}
+
+func receive(t *testing.T, up <-chan []*TargetGroup) []*TargetGroup {
+	select {
+	case groups := <-up:
+		return groups
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "receive target groups timeout")
+		return nil
+	}
+}
+
+func TestKubernetesDiscovery(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		newPod("server-1",
+			map[string]string{"app": "server"},
+			map[string]string{AnnotationScrape: "true"},
+			corev1.ContainerPort{Name: "pprof", ContainerPort: 6060}),
+		newPod("server-2",
+			map[string]string{"app": "server"},
+			map[string]string{AnnotationScrape: "true", AnnotationTarget: "server-canary", AnnotationPort: "9000"}),
+		newPod("not-annotated",
+			map[string]string{"app": "server"},
+			nil,
+			corev1.ContainerPort{Name: "pprof", ContainerPort: 6060}),
+		newPod("no-port",
+			map[string]string{"app": "server"},
+			map[string]string{AnnotationScrape: "true"}),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	up := make(chan []*TargetGroup)
+	go newKubernetesDiscovery(client, KubernetesConfig{}).Run(ctx, up)
+
+	groups := receive(t, up)
+	require.Equal(t, 2, len(groups))
+
+	require.Equal(t, "default/server-1", groups[0].Source)
+	require.Equal(t, "server", groups[0].Target)
+	require.Equal(t, []string{"10.0.0.1:6060"}, groups[0].Instances)
+	require.Equal(t, map[string]string{
+		"namespace": "default",
+		"pod":       "server-1",
+		"container": "app",
+		"node":      "node-1",
+	}, groups[0].Labels)
+
+	require.Equal(t, "server-canary", groups[1].Target)
+	require.Equal(t, []string{"10.0.0.1:9000"}, groups[1].Instances)
+	require.Equal(t, "sidecar", groups[1].Labels["container"])
+
+	err := client.CoreV1().Pods("default").Delete(ctx, "server-2", metav1.DeleteOptions{})
+	require.NoError(t, err)
+	groups = receive(t, up)
+	require.Equal(t, 1, len(groups))
+	require.Equal(t, "default/server-1", groups[0].Source)
+}
+
+func TestKubernetesDiscoveryLabelSelector(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		newPod("server-1",
+			map[string]string{"app": "server"},
+			nil,
+			corev1.ContainerPort{Name: "http", ContainerPort: 8080}),
+		newPod("excluded",
+			map[string]string{"app": "server"},
+			map[string]string{AnnotationScrape: "false"}),
+		newPod("other",
+			map[string]string{"app": "other"},
+			nil),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	up := make(chan []*TargetGroup)
+	go newKubernetesDiscovery(client, KubernetesConfig{LabelSelector: "app=server", Port: 8080}).Run(ctx, up)
+
+	groups := receive(t, up)
+	require.Equal(t, 1, len(groups))
+	require.Equal(t, "server", groups[0].Target)
+	require.Equal(t, []string{"10.0.0.1:8080"}, groups[0].Instances)
+	require.Equal(t, "app", groups[0].Labels["container"])
+
+	pod := newPod("server-2", map[string]string{"app": "server"}, nil)
+	pod.Status.Phase = corev1.PodPending
+	_, err := client.CoreV1().Pods("default").Create(ctx, pod, metav1.CreateOptions{})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(receive(t, up)))
+
+	pod.Status.Phase = corev1.PodRunning
+	_, err = client.CoreV1().Pods("default").Update(ctx, pod, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(receive(t, up)))
+}
@@ -0,0 +1,268 @@
+package collector
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultScrapeTimeout Timeout of the endpoints without seconds parameter,
+	// the endpoints with seconds parameter wait for the requested duration in addition
+	defaultScrapeTimeout = 10 * time.Second
+	defaultMaxRetries    = 2
+	retryBaseBackoff     = 500 * time.Millisecond
+	retryMaxBackoff      = 10 * time.Second
+)
+
+type BasicAuth struct {
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"passwordFile"`
+}
+
+type TLSConfig struct {
+	// CAFile CA certificate to verify the server certificate
+	CAFile string `yaml:"caFile"`
+	// CertFile and KeyFile Client certificate for mutual TLS
+	CertFile           string `yaml:"certFile"`
+	KeyFile            string `yaml:"keyFile"`
+	ServerName         string `yaml:"serverName"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+}
+
+// newHTTPClient Build the http client of the target
+func newHTTPClient(target TargetConfig) (*http.Client, error) {
+	tlsConfig, err := newTLSConfig(target.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport}, nil
+}
+
+func newTLSConfig(config TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         config.ServerName,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+
+	if config.CAFile != "" {
+		ca, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read ca file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in ca file %s", config.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.CertFile != "" || config.KeyFile != "" {
+		if config.CertFile == "" || config.KeyFile == "" {
+			return nil, errors.New("both certFile and keyFile are required for client certificate")
+		}
+		if _, err := loadClientCertificate(config); err != nil {
+			return nil, err
+		}
+		// load the client certificate for every connection, the files may be rotated
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return loadClientCertificate(config)
+		}
+	}
+	return tlsConfig, nil
+}
+
+func loadClientCertificate(config TLSConfig) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load client certificate: %w", err)
+	}
+	return &cert, nil
+}
+
+// newRequest Build the scrape request of the instance, with the authorization and headers of the target
+func newRequest(target TargetConfig, instance string, path string) (*http.Request, error) {
+	scheme := target.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+
+	req, err := http.NewRequest("GET", scheme+"://"+instance+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "")
+	for k, v := range target.Headers {
+		req.Header.Set(k, v)
+	}
+
+	if target.BasicAuth != nil {
+		password := target.BasicAuth.Password
+		if target.BasicAuth.PasswordFile != "" {
+			if password, err = readSecretFile(target.BasicAuth.PasswordFile); err != nil {
+				return nil, fmt.Errorf("read password file: %w", err)
+			}
+		}
+		req.SetBasicAuth(target.BasicAuth.Username, password)
+	}
+
+	// read the token file for every request, the token may be rotated
+	token := target.BearerToken
+	if target.BearerTokenFile != "" {
+		if token, err = readSecretFile(target.BearerTokenFile); err != nil {
+			return nil, fmt.Errorf("read bearer token file: %w", err)
+		}
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req, nil
+}
+
+func readSecretFile(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// scrapeError Failed scrape of an instance
+type scrapeError struct {
+	statusCode int
+	transient  bool
+	err        error
+}
+
+func (e *scrapeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *scrapeError) Unwrap() error {
+	return e.err
+}
+
+// isTransient Whether the scrape may succeed when retried, network errors, 5xx and 429
+func isTransient(err error) bool {
+	var se *scrapeError
+	return errors.As(err, &se) && se.transient
+}
+
+// scrapeTimeout Timeout of a scrape request, default relative to the seconds parameter of the path
+func scrapeTimeout(target TargetConfig, path string) time.Duration {
+	if target.ScrapeTimeout > 0 {
+		return target.ScrapeTimeout
+	}
+	return profileDuration(path) + defaultScrapeTimeout
+}
+
+// profileDuration The profiling duration requested by the seconds parameter of the path
+func profileDuration(path string) time.Duration {
+	u, err := url.Parse(path)
+	if err != nil {
+		return 0
+	}
+	seconds, err := strconv.Atoi(u.Query().Get("seconds"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// backoff Exponential backoff with jitter before the retry of the attempt
+func backoff(attempt int) time.Duration {
+	d := retryBaseBackoff << attempt
+	if d > retryMaxBackoff || d <= 0 {
+		d = retryMaxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// fetchWithRetry Download the profile, the transient errors are retried with backoff
+func (collector *Collector) fetchWithRetry(task scrapeTask, logEntry *logrus.Entry) ([]byte, error) {
+	retries := task.target.MaxRetries
+	if retries == 0 {
+		retries = defaultMaxRetries
+	} else if retries < 0 {
+		retries = 0
+	}
+
+	for attempt := 0; ; attempt++ {
+		profileBytes, err := collector.download(task)
+		if err == nil {
+			return profileBytes, nil
+		}
+		if attempt >= retries || !isTransient(err) || collector.ctx.Err() != nil {
+			return nil, err
+		}
+
+		wait := backoff(attempt)
+		logEntry.WithError(err).Warnf("fetch profile error, retry after %s", wait)
+		select {
+		case <-collector.ctx.Done():
+			return nil, err
+		case <-time.After(wait):
+		}
+	}
+}
+
+// download Request the profile endpoint of the instance once
+func (collector *Collector) download(task scrapeTask) ([]byte, error) {
+	if task.httpClientErr != nil {
+		return nil, fmt.Errorf("http client error: %w", task.httpClientErr)
+	}
+
+	release, err := collector.limiter.acquire(collector.ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	releaseGlobal, err := collector.globalLimiter.acquire(collector.ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseGlobal()
+
+	// the running request is not canceled by exit, it is bounded by the timeout
+	ctx, cancel := context.WithTimeout(context.Background(), scrapeTimeout(task.target, task.profileConfig.Path))
+	defer cancel()
+
+	req, err := newRequest(task.target, task.instance, task.profileConfig.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := task.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, &scrapeError{transient: true, err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &scrapeError{
+			statusCode: resp.StatusCode,
+			transient:  resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests,
+			err:        fmt.Errorf("http resp status code is %d", resp.StatusCode),
+		}
+	}
+
+	profileBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &scrapeError{statusCode: resp.StatusCode, transient: true, err: err}
+	}
+	return profileBytes, nil
+}
@@ -0,0 +1,408 @@
+package collector
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"github.com/xyctruth/profiler/pkg/storage/badger"
+	"github.com/xyctruth/profiler/pkg/utils"
+)
+
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+// newTestCert Create a certificate signed by parent, self-signed when parent is nil
+func newTestCert(t *testing.T, dir, name string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{name},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	c := &testCert{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".crt"),
+		keyFile:  filepath.Join(dir, name+".key"),
+	}
+	err = ioutil.WriteFile(c.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(c.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	require.NoError(t, err)
+	return c
+}
+
+func TestNewRequest(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	req, err := newRequest(TargetConfig{}, "localhost:9000", "/debug/pprof/heap")
+	require.NoError(t, err)
+	require.Equal(t, "http://localhost:9000/debug/pprof/heap", req.URL.String())
+	require.Equal(t, "", req.Header.Get("Authorization"))
+
+	req, err = newRequest(TargetConfig{
+		Scheme:    "https",
+		BasicAuth: &BasicAuth{Username: "user", Password: "pass"},
+		Headers:   map[string]string{"X-Scope": "profiler"},
+	}, "localhost:9000", "/debug/pprof/heap")
+	require.NoError(t, err)
+	require.Equal(t, "https://localhost:9000/debug/pprof/heap", req.URL.String())
+	require.Equal(t, "profiler", req.Header.Get("X-Scope"))
+	username, password, ok := req.BasicAuth()
+	require.True(t, ok)
+	require.Equal(t, "user", username)
+	require.Equal(t, "pass", password)
+
+	passwordFile := filepath.Join(dir, "password")
+	err = ioutil.WriteFile(passwordFile, []byte("secret\n"), 0600)
+	require.NoError(t, err)
+	req, err = newRequest(TargetConfig{
+		BasicAuth: &BasicAuth{Username: "user", Password: "pass", PasswordFile: passwordFile},
+	}, "localhost:9000", "/debug/pprof/heap")
+	require.NoError(t, err)
+	_, password, _ = req.BasicAuth()
+	require.Equal(t, "secret", password)
+
+	req, err = newRequest(TargetConfig{BearerToken: "token"}, "localhost:9000", "/debug/pprof/heap")
+	require.NoError(t, err)
+	require.Equal(t, "Bearer token", req.Header.Get("Authorization"))
+
+	tokenFile := filepath.Join(dir, "token")
+	err = ioutil.WriteFile(tokenFile, []byte("file-token\n"), 0600)
+	require.NoError(t, err)
+	req, err = newRequest(TargetConfig{BearerTokenFile: tokenFile}, "localhost:9000", "/debug/pprof/heap")
+	require.NoError(t, err)
+	require.Equal(t, "Bearer file-token", req.Header.Get("Authorization"))
+
+	_, err = newRequest(TargetConfig{BearerTokenFile: filepath.Join(dir, "notfound")}, "localhost:9000", "/debug/pprof/heap")
+	require.Error(t, err)
+}
+
+func TestNewHTTPClientError(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = newHTTPClient(TargetConfig{TLSConfig: TLSConfig{CAFile: filepath.Join(dir, "notfound")}})
+	require.Error(t, err)
+
+	invalidCA := filepath.Join(dir, "ca.crt")
+	err = ioutil.WriteFile(invalidCA, []byte("invalid"), 0600)
+	require.NoError(t, err)
+	_, err = newHTTPClient(TargetConfig{TLSConfig: TLSConfig{CAFile: invalidCA}})
+	require.Error(t, err)
+
+	_, err = newHTTPClient(TargetConfig{TLSConfig: TLSConfig{CertFile: invalidCA}})
+	require.Error(t, err)
+
+	_, err = newHTTPClient(TargetConfig{TLSConfig: TLSConfig{CertFile: invalidCA, KeyFile: invalidCA}})
+	require.Error(t, err)
+}
+
+func TestCollectorFetchTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, dir, "ca", nil)
+	serverCert := newTestCert(t, dir, "profiler.local", ca)
+	clientCert := newTestCert(t, dir, "client", ca)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	serverKeyPair, err := tls.LoadX509KeyPair(serverCert.certFile, serverCert.keyFile)
+	require.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = pprof.Lookup("heap").WriteTo(w, 0)
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverKeyPair},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	store := badger.NewStore(badger.DefaultOptions(filepath.Join(dir, "data")))
+	defer store.Release()
+
+	target := TargetConfig{
+		Interval:    time.Second,
+		Instances:   []string{strings.TrimPrefix(server.URL, "https://")},
+		Scheme:      "https",
+		BearerToken: "token",
+		TLSConfig: TLSConfig{
+			CAFile:     ca.certFile,
+			CertFile:   clientCert.certFile,
+			KeyFile:    clientCert.keyFile,
+			ServerName: "profiler.local",
+		},
+	}
+	collector := newCollector("tls-server", target, store, &sync.WaitGroup{})
+	require.NoError(t, collector.httpClientErr)
+
+	collector.scrape("heap", target.Instances[0])
+	targets, err := store.ListTarget()
+	require.NoError(t, err)
+	require.Equal(t, []string{"tls-server"}, targets)
+
+	// client certificate is required
+	target.TLSConfig.CertFile, target.TLSConfig.KeyFile = "", ""
+	collector.reload(target)
+	require.NoError(t, collector.httpClientErr)
+	req, err := newRequest(collector.TargetConfig, target.Instances[0], "/debug/pprof/heap")
+	require.NoError(t, err)
+	_, err = collector.httpClient.Do(req)
+	require.Error(t, err)
+}
+
+func TestCollectorFetchTLSRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, dir, "ca", nil)
+	serverCert := newTestCert(t, dir, "profiler.local", ca)
+	clientCert := newTestCert(t, dir, "client", ca)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	serverKeyPair, err := tls.LoadX509KeyPair(serverCert.certFile, serverCert.keyFile)
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	var serials []*big.Int
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		serials = append(serials, r.TLS.PeerCertificates[0].SerialNumber)
+		mu.Unlock()
+		_ = pprof.Lookup("heap").WriteTo(w, 0)
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverKeyPair},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	store := badger.NewStore(badger.DefaultOptions(filepath.Join(dir, "data")))
+	defer store.Release()
+
+	target := TargetConfig{
+		Interval:  time.Second,
+		Instances: []string{strings.TrimPrefix(server.URL, "https://")},
+		Scheme:    "https",
+		TLSConfig: TLSConfig{
+			CAFile:     ca.certFile,
+			CertFile:   clientCert.certFile,
+			KeyFile:    clientCert.keyFile,
+			ServerName: "profiler.local",
+		},
+	}
+	collector := newCollector("tls-server", target, store, &sync.WaitGroup{})
+	require.NoError(t, collector.httpClientErr)
+	collector.scrape("heap", target.Instances[0])
+
+	// the certificate rotated at the same paths is used by the new connections without reload
+	rotated := newTestCert(t, dir, "client", ca)
+	collector.httpClient.CloseIdleConnections()
+	collector.scrape("heap", target.Instances[0])
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []*big.Int{clientCert.cert.SerialNumber, rotated.cert.SerialNumber}, serials)
+}
+
+func TestScrapeTimeout(t *testing.T) {
+	require.Equal(t, 20*time.Second, scrapeTimeout(TargetConfig{}, "/debug/pprof/profile?seconds=10"))
+	require.Equal(t, defaultScrapeTimeout, scrapeTimeout(TargetConfig{}, "/debug/pprof/heap"))
+	require.Equal(t, defaultScrapeTimeout, scrapeTimeout(TargetConfig{}, "/debug/pprof/heap?seconds=a"))
+	require.Equal(t, time.Second, scrapeTimeout(TargetConfig{ScrapeTimeout: time.Second}, "/debug/pprof/profile?seconds=10"))
+
+	for attempt := 0; attempt < 10; attempt++ {
+		d := backoff(attempt)
+		require.GreaterOrEqual(t, d, retryBaseBackoff/2)
+		require.LessOrEqual(t, d, retryMaxBackoff)
+	}
+}
+
+func TestCollectorFetchRetry(t *testing.T) {
+	var mu sync.Mutex
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		n := requests
+		mu.Unlock()
+		switch {
+		case r.URL.Path == "/notfound":
+			w.WriteHeader(http.StatusNotFound)
+		case r.URL.Path == "/hang":
+			time.Sleep(500 * time.Millisecond)
+		case n == 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			_, _ = w.Write([]byte("profile"))
+		}
+	}))
+	defer server.Close()
+	instance := strings.TrimPrefix(server.URL, "http://")
+	logEntry := logrus.WithField("test", "retry")
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+	task := func(collector *Collector, path string) scrapeTask {
+		task := collector.newScrapeTask("heap", instance)
+		task.profileConfig = ProfileConfig{Path: path}
+		return task
+	}
+
+	collector := newCollector("server", TargetConfig{}, nil, &sync.WaitGroup{})
+	b, err := collector.fetchWithRetry(task(collector, "/debug/pprof/heap"), logEntry)
+	require.NoError(t, err)
+	require.Equal(t, "profile", string(b))
+	require.Equal(t, 2, count())
+
+	// not transient
+	_, err = collector.fetchWithRetry(task(collector, "/notfound"), logEntry)
+	require.Error(t, err)
+	require.False(t, isTransient(err))
+	require.Equal(t, 3, count())
+
+	// timeout is retried, until the max retries
+	collector = newCollector("server", TargetConfig{ScrapeTimeout: 50 * time.Millisecond, MaxRetries: 1}, nil, &sync.WaitGroup{})
+	_, err = collector.fetchWithRetry(task(collector, "/hang"), logEntry)
+	require.Error(t, err)
+	require.True(t, isTransient(err))
+	require.Equal(t, 5, count())
+
+	// retry disabled
+	collector = newCollector("server", TargetConfig{ScrapeTimeout: 50 * time.Millisecond, MaxRetries: -1}, nil, &sync.WaitGroup{})
+	_, err = collector.fetchWithRetry(task(collector, "/hang"), logEntry)
+	require.Error(t, err)
+	require.Equal(t, 6, count())
+
+	// exit stops retrying
+	collector.MaxRetries = 5
+	collector.exit()
+	_, err = collector.fetchWithRetry(task(collector, "/hang"), logEntry)
+	require.Error(t, err)
+}
+
+func TestCollectorMaxConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	instance := strings.TrimPrefix(server.URL, "http://")
+
+	target := TargetConfig{
+		Instances:      []string{instance, instance, instance, instance, instance, instance},
+		MaxConcurrency: 2,
+		ProfileConfigs: map[string]ProfileConfig{"heap": {Enable: utils.Bool(true)}},
+	}
+	for k, v := range defaultProfileConfigs() {
+		if k != "heap" {
+			v.Enable = utils.Bool(false)
+			target.ProfileConfigs[k] = v
+		}
+	}
+
+	max := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return maxRunning
+	}
+
+	scrape := func(collector *Collector) {
+		wg := sync.WaitGroup{}
+		for _, instance := range target.Instances {
+			wg.Add(1)
+			go func(instance string) {
+				defer wg.Done()
+				collector.scrape("heap", instance)
+			}(instance)
+		}
+		wg.Wait()
+	}
+
+	collector := newCollector("server", target, nil, &sync.WaitGroup{})
+	scrape(collector)
+	require.Equal(t, 2, max())
+
+	mu.Lock()
+	maxRunning = 0
+	mu.Unlock()
+	target.MaxConcurrency = 0
+	collector.reload(target)
+	collector.globalLimiter = newLimiter(3)
+	scrape(collector)
+	require.Equal(t, 3, max())
+}
@@ -0,0 +1,53 @@
+package collector
+
+import (
+	"context"
+	"sync"
+)
+
+// limiter Limit the number of concurrent scrapes, no limit when the limit <= 0
+// Changing the limit does not wait for the scrapes running under the previous limit
+type limiter struct {
+	mu    sync.Mutex
+	limit int
+	sem   chan struct{}
+}
+
+func newLimiter(limit int) *limiter {
+	l := &limiter{}
+	l.setLimit(limit)
+	return l
+}
+
+func (l *limiter) setLimit(limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if limit == l.limit && (l.sem != nil || limit <= 0) {
+		return
+	}
+	l.limit = limit
+	l.sem = nil
+	if limit > 0 {
+		l.sem = make(chan struct{}, limit)
+	}
+}
+
+// acquire Wait for a free slot until ctx is done, the returned function releases the slot
+func (l *limiter) acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	l.mu.Lock()
+	sem := l.sem
+	l.mu.Unlock()
+	if sem == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,56 @@
+package collector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter(t *testing.T) {
+	var nilLimiter *limiter
+	release, err := nilLimiter.acquire(context.Background())
+	require.NoError(t, err)
+	release()
+
+	l := newLimiter(0)
+	for i := 0; i < 10; i++ {
+		_, err = l.acquire(context.Background())
+		require.NoError(t, err)
+	}
+
+	l.setLimit(2)
+	release1, err := l.acquire(context.Background())
+	require.NoError(t, err)
+	_, err = l.acquire(context.Background())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = l.acquire(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	acquired := make(chan struct{})
+	go func() {
+		release, err := l.acquire(context.Background())
+		require.NoError(t, err)
+		release()
+		close(acquired)
+	}()
+	release1()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		require.FailNow(t, "acquire after release timeout")
+	}
+
+	// new limit applies immediately
+	l.setLimit(1)
+	_, err = l.acquire(context.Background())
+	require.NoError(t, err)
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = l.acquire(ctx)
+	require.Error(t, err)
+}
@@ -1,26 +1,67 @@
 package collector
 
 import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
 	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
+	"github.com/xyctruth/profiler/pkg/collector/discovery"
 	"github.com/xyctruth/profiler/pkg/storage"
+	"github.com/xyctruth/profiler/pkg/utils"
 )
 
+// defaultInterval Scrape interval of the targets only found by service discovery
+const defaultInterval = 15 * time.Second
+
 // Manger Manage multiple collectors to scraping
 type Manger struct {
 	collectors map[string]*Collector
 	store      storage.Store
 	wg         *sync.WaitGroup
 	mu         sync.Mutex
+	// limiter Limit concurrent scrapes of all collectors
+	limiter *limiter
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	// config The last loaded configuration, without the discovered instances
+	config CollectorConfig
+	// discoverers key is discoverer name
+	discoverers map[string]*runningDiscoverer
+	// groups The last target groups sent by each discoverer, key is discoverer name
+	groups map[string][]*discovery.TargetGroup
+	// newDiscoverer Create discoverer by its configuration, replaceable in tests
+	newDiscoverer func(config interface{}) (discovery.Discoverer, error)
+}
+
+// dnsSDConfig The dns discovery configuration of a target
+type dnsSDConfig struct {
+	target string
+	config discovery.DNSConfig
+}
+
+type runningDiscoverer struct {
+	config interface{}
+	cancel context.CancelFunc
 }
 
 // NewManger new Manger instance
 func NewManger(store storage.Store) *Manger {
+	ctx, cancel := context.WithCancel(context.Background())
 	c := &Manger{
-		collectors: make(map[string]*Collector),
-		store:      store,
-		wg:         &sync.WaitGroup{},
+		collectors:    make(map[string]*Collector),
+		store:         store,
+		wg:            &sync.WaitGroup{},
+		limiter:       newLimiter(0),
+		ctx:           ctx,
+		cancel:        cancel,
+		discoverers:   make(map[string]*runningDiscoverer),
+		groups:        make(map[string][]*discovery.TargetGroup),
+		newDiscoverer: newDiscoverer,
 	}
 	return c
 }
@@ -29,6 +70,7 @@ func NewManger(store storage.Store) *Manger {
 func (manger *Manger) Stop() {
 	manger.mu.Lock()
 	defer manger.mu.Unlock()
+	manger.cancel()
 	for _, c := range manger.collectors {
 		c.exit()
 	}
@@ -41,6 +83,43 @@ func (manger *Manger) Stop() {
 func (manger *Manger) Load(config CollectorConfig) {
 	manger.mu.Lock()
 	defer manger.mu.Unlock()
+	manger.config = config
+	manger.limiter.setLimit(config.MaxConcurrency)
+	manger.syncDiscoverers()
+	manger.apply()
+}
+
+// ListTargetStatus The scrape status of all targets, sorted by target name
+func (manger *Manger) ListTargetStatus() []*TargetStatus {
+	manger.mu.Lock()
+	defer manger.mu.Unlock()
+
+	status := make([]*TargetStatus, 0, len(manger.collectors))
+	for _, c := range manger.collectors {
+		status = append(status, c.Status())
+	}
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].TargetName < status[j].TargetName
+	})
+	return status
+}
+
+// GetTargetStatus The scrape status of the target, false if the target does not exist
+func (manger *Manger) GetTargetStatus(targetName string) (*TargetStatus, bool) {
+	manger.mu.Lock()
+	defer manger.mu.Unlock()
+
+	c, ok := manger.collectors[targetName]
+	if !ok {
+		return nil, false
+	}
+	return c.Status(), true
+}
+
+// apply Merge the discovered target groups into the loaded configuration and sync collectors
+func (manger *Manger) apply() {
+	config := mergeTargetGroups(manger.config, manger.groups)
+
 	// delete old collector
 	for k, collector := range manger.collectors {
 		if _, ok := config.TargetConfigs[k]; !ok {
@@ -57,6 +136,7 @@ func (manger *Manger) Load(config CollectorConfig) {
 			// add collector
 			log.Info("add collector ", k)
 			collector = newCollector(k, target, manger.store, manger.wg)
+			collector.globalLimiter = manger.limiter
 			manger.collectors[k] = collector
 			collector.run()
 			continue
@@ -66,3 +146,139 @@ func (manger *Manger) Load(config CollectorConfig) {
 		collector.reload(target)
 	}
 }
+
+// syncDiscoverers Start the configured discoverers, stop the removed or changed ones
+func (manger *Manger) syncDiscoverers() {
+	configs := make(map[string]interface{})
+	for i, c := range manger.config.KubernetesSD {
+		configs[fmt.Sprintf("kubernetes/%d", i)] = c
+	}
+	for i, c := range manger.config.FileSD {
+		configs[fmt.Sprintf("file/%d", i)] = c
+	}
+	for target, c := range manger.config.TargetConfigs {
+		if c.DNSSD != nil {
+			configs["dns/"+target] = dnsSDConfig{target: target, config: *c.DNSSD}
+		}
+	}
+
+	for name, d := range manger.discoverers {
+		if config, ok := configs[name]; ok && reflect.DeepEqual(config, d.config) {
+			continue
+		}
+		log.Info("stop discoverer ", name)
+		d.cancel()
+		delete(manger.discoverers, name)
+		delete(manger.groups, name)
+	}
+
+	for name, config := range configs {
+		if _, ok := manger.discoverers[name]; ok {
+			continue
+		}
+		d, err := manger.newDiscoverer(config)
+		if err != nil {
+			log.WithError(err).Error("create discoverer error ", name)
+			continue
+		}
+		log.Info("start discoverer ", name)
+		ctx, cancel := context.WithCancel(manger.ctx)
+		manger.discoverers[name] = &runningDiscoverer{config: config, cancel: cancel}
+		go manger.runDiscoverer(ctx, name, d)
+	}
+}
+
+func (manger *Manger) runDiscoverer(ctx context.Context, name string, d discovery.Discoverer) {
+	up := make(chan []*discovery.TargetGroup)
+	go d.Run(ctx, up)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case groups := <-up:
+			manger.updateGroups(ctx, name, groups)
+		}
+	}
+}
+
+func (manger *Manger) updateGroups(ctx context.Context, name string, groups []*discovery.TargetGroup) {
+	manger.mu.Lock()
+	defer manger.mu.Unlock()
+	// discoverer stopped while waiting for the lock
+	if ctx.Err() != nil {
+		return
+	}
+	log.WithField("discoverer", name).Info("target groups change, reload collector")
+	manger.groups[name] = groups
+	manger.apply()
+}
+
+func newDiscoverer(config interface{}) (discovery.Discoverer, error) {
+	switch c := config.(type) {
+	case discovery.KubernetesConfig:
+		return discovery.NewKubernetesDiscovery(c)
+	case discovery.FileConfig:
+		return discovery.NewFileDiscovery(c), nil
+	case dnsSDConfig:
+		return discovery.NewDNSDiscovery(c.target, c.config, nil)
+	default:
+		return nil, fmt.Errorf("unknown discovery config %T", config)
+	}
+}
+
+// mergeTargetGroups Append the discovered instances and their labels to the target configs
+// the targets only found by service discovery use the DiscoveryTargetConfig
+func mergeTargetGroups(config CollectorConfig, groups map[string][]*discovery.TargetGroup) CollectorConfig {
+	merged := config
+	merged.TargetConfigs = make(map[string]TargetConfig, len(config.TargetConfigs))
+	for k, target := range config.TargetConfigs {
+		merged.TargetConfigs[k] = target
+	}
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	discovered := make(map[string]struct{})
+
+	for _, name := range names {
+		for _, group := range groups[name] {
+			target, ok := merged.TargetConfigs[group.Target]
+			if !ok {
+				target = config.DiscoveryTargetConfig
+				target.Instances = nil
+				target.DNSSD = nil
+				if target.Interval <= 0 {
+					target.Interval = defaultInterval
+				}
+			}
+			if _, ok = discovered[group.Target]; !ok {
+				// copy before appending, the loaded configuration must not be modified
+				target.Instances = append([]string{}, target.Instances...)
+				instanceLabels := make(map[string]LabelConfig, len(target.InstanceLabels))
+				for instance, labels := range target.InstanceLabels {
+					instanceLabels[instance] = labels
+				}
+				target.InstanceLabels = instanceLabels
+				discovered[group.Target] = struct{}{}
+			}
+
+			target.Instances = append(target.Instances, group.Instances...)
+			if len(group.Labels) > 0 {
+				for _, instance := range group.Instances {
+					target.InstanceLabels[instance] = group.Labels
+				}
+			}
+			merged.TargetConfigs[group.Target] = target
+		}
+	}
+
+	for name := range discovered {
+		target := merged.TargetConfigs[name]
+		target.Instances = utils.RemoveDuplicateElement(target.Instances)
+		merged.TargetConfigs[name] = target
+	}
+	return merged
+}
@@ -1,12 +1,15 @@
 package collector
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
+	"github.com/xyctruth/profiler/pkg/collector/discovery"
 	"github.com/xyctruth/profiler/pkg/storage/badger"
 	"github.com/xyctruth/profiler/pkg/utils"
 	yaml "gopkg.in/yaml.v2"
@@ -59,3 +62,212 @@ func TestErrorHostManger(t *testing.T) {
 	manger.Load(*config)
 	manger.Stop()
 }
+
+type staticDiscoverer struct {
+	groups []*discovery.TargetGroup
+}
+
+func (d *staticDiscoverer) Run(ctx context.Context, up chan<- []*discovery.TargetGroup) {
+	select {
+	case <-ctx.Done():
+	case up <- d.groups:
+	}
+}
+
+func TestMangerDiscovery(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	store := badger.NewStore(badger.DefaultOptions(dir))
+	defer store.Release()
+
+	manger := NewManger(store)
+	manger.newDiscoverer = func(config interface{}) (discovery.Discoverer, error) {
+		return &staticDiscoverer{groups: []*discovery.TargetGroup{
+			{
+				Source:    "default/server2-0",
+				Target:    "server2",
+				Instances: []string{"localhost:9001"},
+				Labels:    map[string]string{"pod": "server2-0"},
+			},
+			{
+				Source:    "default/server3-0",
+				Target:    "server3",
+				Instances: []string{"localhost:9002"},
+				Labels:    map[string]string{"pod": "server3-0"},
+			},
+		}}, nil
+	}
+
+	c := &Config{}
+	yaml.Unmarshal([]byte(generalConfigYAML), c)
+	config := c.Collector
+	config.KubernetesSD = []discovery.KubernetesConfig{{}}
+	manger.Load(config)
+
+	require.Eventually(t, func() bool {
+		manger.mu.Lock()
+		defer manger.mu.Unlock()
+		return len(manger.collectors) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	manger.mu.Lock()
+	server2 := manger.collectors["server2"]
+	server3 := manger.collectors["server3"]
+	manger.mu.Unlock()
+
+	server2.mu.RLock()
+	require.Equal(t, []string{"localhost:9000", "localhost:9001"}, server2.Instances)
+	require.Equal(t, LabelConfig{"pod": "server2-0"}, server2.instanceLabels("localhost:9001"))
+	server2.mu.RUnlock()
+
+	server3.mu.RLock()
+	require.Equal(t, defaultInterval, server3.Interval)
+	require.Equal(t, []string{"localhost:9002"}, server3.Instances)
+	server3.mu.RUnlock()
+
+	// the loaded configuration is not modified by the discovered instances
+	require.Equal(t, []string{"localhost:9000"}, config.TargetConfigs["server2"].Instances)
+
+	status := manger.ListTargetStatus()
+	require.Equal(t, 3, len(status))
+	require.Equal(t, "profiler-server", status[0].TargetName)
+	server3Status, ok := manger.GetTargetStatus("server3")
+	require.True(t, ok)
+	require.Equal(t, "localhost:9002", server3Status.Scrapes[0].Instance)
+	_, ok = manger.GetTargetStatus("notfound")
+	require.False(t, ok)
+
+	config.KubernetesSD = nil
+	manger.Load(config)
+	require.Equal(t, 2, len(manger.collectors))
+	manger.Stop()
+}
+
+func TestMergeTargetGroups(t *testing.T) {
+	config := CollectorConfig{TargetConfigs: map[string]TargetConfig{
+		"server": {
+			Instances: []string{"localhost:9000"},
+			Labels:    LabelConfig{"env": "test"},
+		},
+	}}
+
+	merged := mergeTargetGroups(config, map[string][]*discovery.TargetGroup{
+		"file/0": {{Target: "server", Instances: []string{"localhost:9000", "localhost:9001"}}},
+		"kubernetes/0": {{
+			Target:    "server",
+			Instances: []string{"10.0.0.1:6060"},
+			Labels:    map[string]string{"pod": "server-0", "env": "prod"},
+		}},
+	})
+
+	server := merged.TargetConfigs["server"]
+	require.Equal(t, []string{"localhost:9000", "localhost:9001", "10.0.0.1:6060"}, server.Instances)
+	require.Equal(t, LabelConfig{"env": "test"}, server.instanceLabels("localhost:9001"))
+	require.Equal(t, LabelConfig{"env": "prod", "pod": "server-0"}, server.instanceLabels("10.0.0.1:6060"))
+	require.Equal(t, 1, len(config.TargetConfigs["server"].Instances))
+	require.Nil(t, config.TargetConfigs["server"].InstanceLabels)
+
+	// the targets only found by service discovery use the DiscoveryTargetConfig
+	config.DiscoveryTargetConfig = TargetConfig{
+		Interval:       time.Minute,
+		Expiration:     time.Hour,
+		Instances:      []string{"localhost:9000"},
+		ProfileConfigs: map[string]ProfileConfig{"trace": {Enable: utils.Bool(true)}},
+	}
+	merged = mergeTargetGroups(config, map[string][]*discovery.TargetGroup{
+		"file/0": {{Target: "server2", Instances: []string{"localhost:9001"}}},
+	})
+	server2 := merged.TargetConfigs["server2"]
+	require.Equal(t, time.Minute, server2.Interval)
+	require.Equal(t, time.Hour, server2.Expiration)
+	require.Equal(t, []string{"localhost:9001"}, server2.Instances)
+	require.True(t, *server2.ProfileConfigs["trace"].Enable)
+	require.Equal(t, []string{"localhost:9000"}, config.DiscoveryTargetConfig.Instances)
+
+	config.DiscoveryTargetConfig = TargetConfig{}
+	merged = mergeTargetGroups(config, map[string][]*discovery.TargetGroup{
+		"file/0": {{Target: "server2", Instances: []string{"localhost:9001"}}},
+	})
+	require.Equal(t, defaultInterval, merged.TargetConfigs["server2"].Interval)
+}
+
+func TestMangerFileDiscovery(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	store := badger.NewStore(badger.DefaultOptions(dir))
+	defer store.Release()
+
+	targetFile := filepath.Join(dir, "targets.json")
+	err = ioutil.WriteFile(targetFile, []byte(`[{"target":"server3","instances":["localhost:9000"],"labels":{"env":"vm"}}]`), 0600)
+	require.NoError(t, err)
+
+	manger := NewManger(store)
+	c := &Config{}
+	yaml.Unmarshal([]byte(generalConfigYAML), c)
+	config := c.Collector
+	config.FileSD = []discovery.FileConfig{{Files: []string{filepath.Join(dir, "*.json")}}}
+	// scrape quickly, reload waits for the running scrape
+	config.TargetConfigs["server3"] = TargetConfig{
+		Interval:       time.Second,
+		ProfileConfigs: map[string]ProfileConfig{"profile": {Enable: utils.Bool(false)}, "fgprof": {Enable: utils.Bool(false)}},
+	}
+	manger.Load(config)
+
+	collectorOf := func(name string) *Collector {
+		manger.mu.Lock()
+		defer manger.mu.Unlock()
+		return manger.collectors[name]
+	}
+	require.Eventually(t, func() bool { return collectorOf("server3") != nil }, 5*time.Second, 10*time.Millisecond)
+	server2 := collectorOf("server2")
+
+	err = ioutil.WriteFile(targetFile, []byte(`[{"target":"server3","instances":["localhost:9000","localhost:9001"]}]`), 0600)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		server3 := collectorOf("server3")
+		server3.mu.RLock()
+		defer server3.mu.RUnlock()
+		return len(server3.Instances) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// unaffected collector keeps running
+	require.True(t, server2 == collectorOf("server2"))
+	manger.Stop()
+}
+
+func TestMangerSyncDiscoverers(t *testing.T) {
+	created := make([]interface{}, 0)
+	manger := NewManger(nil)
+	manger.newDiscoverer = func(config interface{}) (discovery.Discoverer, error) {
+		created = append(created, config)
+		return &staticDiscoverer{}, nil
+	}
+
+	// the running discoverers apply the configuration under the lock
+	manger.mu.Lock()
+	defer manger.mu.Unlock()
+
+	dnsConfig := &discovery.DNSConfig{Names: []string{"server.service.consul"}}
+	manger.config = CollectorConfig{
+		TargetConfigs: map[string]TargetConfig{"server": {DNSSD: dnsConfig}, "server2": {}},
+		FileSD:        []discovery.FileConfig{{Files: []string{"*.json"}}},
+	}
+	manger.syncDiscoverers()
+	require.Equal(t, 2, len(manger.discoverers))
+	require.Contains(t, manger.discoverers, "dns/server")
+	require.Contains(t, manger.discoverers, "file/0")
+	require.Contains(t, created, dnsSDConfig{target: "server", config: *dnsConfig})
+
+	// unchanged discoverers keep running
+	manger.syncDiscoverers()
+	require.Equal(t, 2, len(created))
+
+	manger.config.TargetConfigs["server"] = TargetConfig{DNSSD: &discovery.DNSConfig{Names: []string{"server2.service.consul"}}}
+	manger.config.FileSD = nil
+	manger.syncDiscoverers()
+	require.Equal(t, 1, len(manger.discoverers))
+	require.Equal(t, 3, len(created))
+	manger.cancel()
+}
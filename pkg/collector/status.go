@@ -0,0 +1,129 @@
+package collector
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/xyctruth/profiler/pkg/metrics"
+)
+
+const (
+	HealthUnknown = "unknown"
+	HealthUp      = "up"
+	HealthDown    = "down"
+)
+
+// ScrapeStatus The result of the last scrape of a profile type of an instance
+type ScrapeStatus struct {
+	Instance    string `json:"instance"`
+	ProfileType string `json:"profile_type"`
+	Health      string `json:"health"`
+	// LastScrape Start time of the last scrape, zero if not scraped yet
+	LastScrape time.Time `json:"last_scrape"`
+	// LastScrapeDuration Duration of the last scrape in seconds, including retries
+	LastScrapeDuration float64 `json:"last_scrape_duration"`
+	// StatusCode Http status code of the last scrape, 0 if no response is received
+	StatusCode          int    `json:"status_code"`
+	LastError           string `json:"last_error"`
+	Bytes               int    `json:"bytes"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+}
+
+// TargetStatus The scrape status of all instances and profile types of a target
+type TargetStatus struct {
+	TargetName string          `json:"target_name"`
+	Up         int             `json:"up"`
+	Down       int             `json:"down"`
+	Unknown    int             `json:"unknown"`
+	Scrapes    []*ScrapeStatus `json:"scrapes"`
+}
+
+// Status Snapshot of the scrape status of the collector
+func (collector *Collector) Status() *TargetStatus {
+	collector.statusMu.Lock()
+	defer collector.statusMu.Unlock()
+
+	status := &TargetStatus{
+		TargetName: collector.TargetName,
+		Scrapes:    make([]*ScrapeStatus, 0, len(collector.status)),
+	}
+	for _, s := range collector.status {
+		scrape := *s
+		status.Scrapes = append(status.Scrapes, &scrape)
+		switch s.Health {
+		case HealthUp:
+			status.Up++
+		case HealthDown:
+			status.Down++
+		default:
+			status.Unknown++
+		}
+	}
+	sort.Slice(status.Scrapes, func(i, j int) bool {
+		if status.Scrapes[i].Instance != status.Scrapes[j].Instance {
+			return status.Scrapes[i].Instance < status.Scrapes[j].Instance
+		}
+		return status.Scrapes[i].ProfileType < status.Scrapes[j].ProfileType
+	})
+	return status
+}
+
+// syncStatus Keep the status of the running scrape loops only
+func (collector *Collector) syncStatus() {
+	collector.statusMu.Lock()
+	defer collector.statusMu.Unlock()
+
+	for key := range collector.status {
+		if _, ok := collector.loops[key]; !ok {
+			delete(collector.status, key)
+		}
+	}
+	for key, loop := range collector.loops {
+		if _, ok := collector.status[key]; !ok {
+			collector.status[key] = &ScrapeStatus{Instance: loop.instance, ProfileType: loop.profileType, Health: HealthUnknown}
+		}
+	}
+}
+
+// updateStatus Record the result of a scrape in the status and the metrics, err is the fetch or analysis error
+func (collector *Collector) updateStatus(task scrapeTask, start time.Time, statusCode int, bytes int, err error) {
+	duration := time.Since(start)
+	metrics.ScrapesTotal.WithLabelValues(collector.TargetName, task.profileType).Inc()
+	metrics.ScrapeDuration.WithLabelValues(collector.TargetName, task.profileType).Observe(duration.Seconds())
+	metrics.ScrapeBytesTotal.WithLabelValues(collector.TargetName, task.profileType).Add(float64(bytes))
+	if err != nil {
+		metrics.ScrapeErrorsTotal.WithLabelValues(collector.TargetName, task.profileType).Inc()
+	}
+
+	collector.statusMu.Lock()
+	defer collector.statusMu.Unlock()
+
+	s, ok := collector.status[task.profileType+"/"+task.instance]
+	if !ok {
+		// the loop is stopped during the scrape
+		return
+	}
+	s.LastScrape = start
+	s.LastScrapeDuration = duration.Seconds()
+	s.Bytes = bytes
+	s.StatusCode = statusCode
+	if err == nil {
+		s.Health = HealthUp
+		s.LastError = ""
+		s.ConsecutiveFailures = 0
+		return
+	}
+	s.Health = HealthDown
+	s.LastError = err.Error()
+	s.ConsecutiveFailures++
+}
+
+// statusCode Http status code of the failed download, 0 if no response is received
+func statusCode(err error) int {
+	var se *scrapeError
+	if errors.As(err, &se) {
+		return se.statusCode
+	}
+	return 0
+}
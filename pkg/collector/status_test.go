@@ -0,0 +1,123 @@
+package collector
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/xyctruth/profiler/pkg/metrics"
+	"github.com/xyctruth/profiler/pkg/storage/badger"
+	"github.com/xyctruth/profiler/pkg/utils"
+)
+
+func TestCollectorStatus(t *testing.T) {
+	heap := &bytes.Buffer{}
+	require.NoError(t, pprof.Lookup("heap").WriteTo(heap, 0))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/debug/pprof/heap":
+			_, _ = w.Write(heap.Bytes())
+		case "/invalid":
+			_, _ = w.Write([]byte("invalid"))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	instance := strings.TrimPrefix(server.URL, "http://")
+
+	target := TargetConfig{
+		Interval:   time.Hour,
+		Instances:  []string{instance},
+		MaxRetries: -1,
+		ProfileConfigs: map[string]ProfileConfig{
+			"heap":   {Path: "/debug/pprof/heap"},
+			"allocs": {Path: "/fail"},
+			"block":  {Path: "/invalid"},
+		},
+	}
+	for k, v := range defaultProfileConfigs() {
+		if _, ok := target.ProfileConfigs[k]; !ok {
+			v.Enable = utils.Bool(false)
+			target.ProfileConfigs[k] = v
+		}
+	}
+
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	store := badger.NewStore(badger.DefaultOptions(dir))
+	defer store.Release()
+
+	wg := &sync.WaitGroup{}
+	collector := newCollector("status-server", target, store, wg)
+	collector.run()
+	defer func() {
+		collector.exit()
+		wg.Wait()
+	}()
+
+	status := collector.Status()
+	require.Equal(t, "status-server", status.TargetName)
+	require.Equal(t, 3, status.Unknown)
+	require.Equal(t, 3, len(status.Scrapes))
+	require.Equal(t, "allocs", status.Scrapes[0].ProfileType)
+
+	// the metrics are global
+	scrapes := testutil.ToFloat64(metrics.ScrapesTotal.WithLabelValues("status-server", "allocs"))
+	scrapeErrors := testutil.ToFloat64(metrics.ScrapeErrorsTotal.WithLabelValues("status-server", "allocs"))
+	scrapeBytes := testutil.ToFloat64(metrics.ScrapeBytesTotal.WithLabelValues("status-server", "heap"))
+
+	collector.scrape("heap", instance)
+	collector.scrape("allocs", instance)
+	collector.scrape("allocs", instance)
+	collector.scrape("block", instance)
+
+	status = collector.Status()
+	require.Equal(t, 1, status.Up)
+	require.Equal(t, 2, status.Down)
+
+	allocs, block, heapStatus := status.Scrapes[0], status.Scrapes[1], status.Scrapes[2]
+	require.Equal(t, HealthDown, allocs.Health)
+	require.Equal(t, http.StatusInternalServerError, allocs.StatusCode)
+	require.Equal(t, 2, allocs.ConsecutiveFailures)
+	require.Contains(t, allocs.LastError, "500")
+
+	require.Equal(t, HealthDown, block.Health)
+	require.Equal(t, http.StatusOK, block.StatusCode)
+	require.Equal(t, len("invalid"), block.Bytes)
+
+	require.Equal(t, HealthUp, heapStatus.Health)
+	require.Equal(t, heap.Len(), heapStatus.Bytes)
+	require.Equal(t, "", heapStatus.LastError)
+	require.False(t, heapStatus.LastScrape.IsZero())
+
+	require.Equal(t, scrapes+2, testutil.ToFloat64(metrics.ScrapesTotal.WithLabelValues("status-server", "allocs")))
+	require.Equal(t, scrapeErrors+2, testutil.ToFloat64(metrics.ScrapeErrorsTotal.WithLabelValues("status-server", "allocs")))
+	require.Equal(t, scrapeBytes+float64(heap.Len()), testutil.ToFloat64(metrics.ScrapeBytesTotal.WithLabelValues("status-server", "heap")))
+
+	// no response
+	server.Close()
+	collector.scrape("heap", instance)
+	heapStatus = collector.Status().Scrapes[2]
+	require.Equal(t, HealthDown, heapStatus.Health)
+	require.Equal(t, 0, heapStatus.StatusCode)
+	require.Equal(t, 1, heapStatus.ConsecutiveFailures)
+
+	// the status of the removed profile type is removed
+	allocsConfig := target.ProfileConfigs["allocs"]
+	allocsConfig.Enable = utils.Bool(false)
+	target.ProfileConfigs["allocs"] = allocsConfig
+	collector.reload(target)
+	require.Equal(t, 2, len(collector.Status().Scrapes))
+}
@@ -0,0 +1,167 @@
+package exporter
+
+import (
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/require"
+	"github.com/xyctruth/profiler/pkg/storage"
+	"github.com/xyctruth/profiler/pkg/storage/badger"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func newMeta(instance string, sampleType string, value int64, labels ...storage.Label) *storage.ProfileMeta {
+	return &storage.ProfileMeta{
+		ProfileID:      "1",
+		ProfileType:    "heap",
+		SampleType:     sampleType,
+		TargetName:     "server",
+		Instance:       instance,
+		SampleTypeUnit: "bytes",
+		Value:          value,
+		Timestamp:      time.Now().UnixNano() / time.Millisecond.Nanoseconds(),
+		Labels:         labels,
+	}
+}
+
+func scrape(t *testing.T, handler http.Handler) string {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics/profiles", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	return rec.Body.String()
+}
+
+func TestSeriesLabels(t *testing.T) {
+	labels := seriesLabels(newMeta("localhost:9000", "heap_inuse_space", 1,
+		storage.Label{Key: "env", Value: "prod"},
+		storage.Label{Key: "app.kubernetes.io/name", Value: "server"},
+		storage.Label{Key: "instance", Value: "pod-0"},
+		storage.Label{Key: "__name__", Value: "x"},
+	))
+	require.Equal(t, []label{
+		{name: "app_kubernetes_io_name", value: "server"},
+		{name: "env", value: "prod"},
+		{name: "exported_instance", value: "pod-0"},
+		{name: "instance", value: "localhost:9000"},
+		{name: "profile_type", value: "heap"},
+		{name: "sample_type", value: "heap_inuse_space"},
+		{name: "target", value: "server"},
+		{name: "unit", value: "bytes"},
+	}, labels)
+	require.Equal(t, "_9a", sanitizeLabelName("9a"))
+}
+
+func TestGaugeExporter(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	e := NewGaugeExporter(time.Hour)
+	s := NewStore(badger.NewStore(badger.DefaultOptions(dir)), e)
+	defer s.Release()
+
+	err = s.SaveProfileMeta([]*storage.ProfileMeta{
+		newMeta("localhost:9000", "heap_inuse_space", 100, storage.Label{Key: "env", Value: "prod"}),
+		newMeta("localhost:9001", "heap_inuse_space", 200),
+	}, time.Hour)
+	require.NoError(t, err)
+	err = s.SaveProfileMeta([]*storage.ProfileMeta{
+		newMeta("localhost:9000", "heap_inuse_space", 300, storage.Label{Key: "env", Value: "prod"}),
+	}, time.Hour)
+	require.NoError(t, err)
+
+	body := scrape(t, e.Handler())
+	require.Contains(t, body, `profiler_profile_value{env="prod",instance="localhost:9000",profile_type="heap",sample_type="heap_inuse_space",target="server",unit="bytes"} 300`)
+	require.Contains(t, body, `profiler_profile_value{instance="localhost:9001",profile_type="heap",sample_type="heap_inuse_space",target="server",unit="bytes"} 200`)
+	require.NotContains(t, body, "_target")
+
+	// the store is still queryable
+	targets, err := s.ListTarget()
+	require.NoError(t, err)
+	require.Equal(t, []string{"server"}, targets)
+
+	// stale series are removed
+	e.staleness = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	require.NotContains(t, scrape(t, e.Handler()), "profiler_profile_value")
+}
+
+// decodeWriteRequest Decode the value of the label of each time series of the prometheus.WriteRequest
+func decodeWriteRequest(t *testing.T, b []byte, labelName string) map[string]float64 {
+	res := make(map[string]float64)
+	for len(b) > 0 {
+		_, _, n := protowire.ConsumeTag(b)
+		b = b[n:]
+		ts, n := protowire.ConsumeBytes(b)
+		require.True(t, n > 0)
+		b = b[n:]
+
+		var key string
+		var value float64
+		for len(ts) > 0 {
+			num, _, n := protowire.ConsumeTag(ts)
+			ts = ts[n:]
+			field, n := protowire.ConsumeBytes(ts)
+			ts = ts[n:]
+			if num == 1 {
+				_, _, n = protowire.ConsumeTag(field)
+				name, m := protowire.ConsumeString(field[n:])
+				_, _, k := protowire.ConsumeTag(field[n+m:])
+				v, _ := protowire.ConsumeString(field[n+m+k:])
+				if name == labelName {
+					key = v
+				}
+			} else {
+				_, _, n = protowire.ConsumeTag(field)
+				bits, _ := protowire.ConsumeFixed64(field[n:])
+				value = math.Float64frombits(bits)
+			}
+		}
+		res[key] = value
+	}
+	return res
+}
+
+func TestRemoteWriter(t *testing.T) {
+	var mu sync.Mutex
+	received := make(map[string]float64)
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		require.Equal(t, "snappy", r.Header.Get("Content-Encoding"))
+		compressed, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		b, err := snappy.Decode(nil, compressed)
+		require.NoError(t, err)
+		for k, v := range decodeWriteRequest(t, b, "instance") {
+			received[k] = v
+		}
+	}))
+	defer server.Close()
+
+	// the samples are sent by stop
+	w := NewRemoteWriter(DefaultRemoteWriteOptions(server.URL).WithFlushInterval(time.Minute))
+	w.Send([]*storage.ProfileMeta{
+		newMeta("localhost:9000", "heap_inuse_space", 100),
+		newMeta("localhost:9001", "heap_inuse_space", 200),
+	})
+	w.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, 2, requests)
+	require.Equal(t, map[string]float64{"localhost:9000": 100, "localhost:9001": 200}, received)
+}
@@ -0,0 +1,95 @@
+package exporter
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	"github.com/xyctruth/profiler/pkg/storage"
+)
+
+// GaugeExporter Expose the latest value of each target, instance, sample type and labels as a gauge
+type GaugeExporter struct {
+	mu sync.Mutex
+	// staleness The series not updated within staleness are removed, never removed when staleness <= 0
+	staleness time.Duration
+	series    map[string]*gaugeSeries
+	registry  *prometheus.Registry
+}
+
+type gaugeSeries struct {
+	labels  []label
+	value   float64
+	updated time.Time
+}
+
+func NewGaugeExporter(staleness time.Duration) *GaugeExporter {
+	e := &GaugeExporter{
+		staleness: staleness,
+		series:    make(map[string]*gaugeSeries),
+		registry:  prometheus.NewRegistry(),
+	}
+	e.registry.MustRegister(e)
+	return e
+}
+
+func (e *GaugeExporter) Send(metas []*storage.ProfileMeta) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	for _, meta := range metas {
+		labels := seriesLabels(meta)
+		e.series[seriesKey(labels)] = &gaugeSeries{labels: labels, value: float64(meta.Value), updated: now}
+	}
+}
+
+// Describe Send no descriptor, the label names of the series are not fixed
+func (e *GaugeExporter) Describe(chan<- *prometheus.Desc) {}
+
+func (e *GaugeExporter) Collect(ch chan<- prometheus.Metric) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	for key, s := range e.series {
+		if e.staleness > 0 && now.Sub(s.updated) > e.staleness {
+			delete(e.series, key)
+			continue
+		}
+
+		names := make([]string, 0, len(s.labels))
+		values := make([]string, 0, len(s.labels))
+		for _, l := range s.labels {
+			names = append(names, l.name)
+			values = append(values, l.value)
+		}
+		desc := prometheus.NewDesc(MetricName, "Latest aggregated value of the profile sample type.", names, nil)
+		m, err := prometheus.NewConstMetric(desc, prometheus.GaugeValue, s.value, values...)
+		if err != nil {
+			log.WithError(err).Error("build profile meta metric error")
+			continue
+		}
+		ch <- m
+	}
+}
+
+// Handler Expose the gauges in the Prometheus text format
+func (e *GaugeExporter) Handler() http.Handler {
+	return promhttp.HandlerFor(e.registry, promhttp.HandlerOpts{})
+}
+
+func seriesKey(labels []label) string {
+	var b strings.Builder
+	for _, l := range labels {
+		b.WriteString(l.name)
+		b.WriteByte(0xff)
+		b.WriteString(l.value)
+		b.WriteByte(0xff)
+	}
+	return b.String()
+}
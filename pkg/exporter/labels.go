@@ -0,0 +1,74 @@
+package exporter
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/xyctruth/profiler/pkg/storage"
+)
+
+// MetricName Name of the time series of the profile meta values
+const MetricName = "profiler_profile_value"
+
+var reservedLabels = map[string]struct{}{
+	"__name__":     {},
+	"target":       {},
+	"instance":     {},
+	"profile_type": {},
+	"sample_type":  {},
+	"unit":         {},
+}
+
+type label struct {
+	name  string
+	value string
+}
+
+// seriesLabels Labels of the time series of the meta sorted by name, without the metric name
+// The labels of the meta are sanitized, the ones colliding with the reserved labels are prefixed with exported_
+func seriesLabels(meta *storage.ProfileMeta) []label {
+	labels := map[string]string{
+		"target":       meta.TargetName,
+		"instance":     meta.Instance,
+		"profile_type": meta.ProfileType,
+		"sample_type":  meta.SampleType,
+		"unit":         meta.SampleTypeUnit,
+	}
+	for _, l := range meta.Labels {
+		name := sanitizeLabelName(l.Key)
+		if name == "" || strings.HasPrefix(name, "__") {
+			continue
+		}
+		if _, ok := reservedLabels[name]; ok {
+			name = "exported_" + name
+		}
+		labels[name] = l.Value
+	}
+
+	res := make([]label, 0, len(labels))
+	for name, value := range labels {
+		if value == "" {
+			continue
+		}
+		res = append(res, label{name: name, value: value})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].name < res[j].name
+	})
+	return res
+}
+
+// sanitizeLabelName Replace the characters not allowed in Prometheus label names with _
+func sanitizeLabelName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_' || (c >= '0' && c <= '9') {
+			continue
+		}
+		b[i] = '_'
+	}
+	if len(b) > 0 && b[0] >= '0' && b[0] <= '9' {
+		return "_" + string(b)
+	}
+	return string(b)
+}
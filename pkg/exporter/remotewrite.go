@@ -0,0 +1,221 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	log "github.com/sirupsen/logrus"
+	"github.com/xyctruth/profiler/pkg/metrics"
+	"github.com/xyctruth/profiler/pkg/storage"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type RemoteWriteOptions struct {
+	URL     string
+	Timeout time.Duration
+	// BatchSize Max samples of a request
+	BatchSize int
+	// QueueSize Samples waiting to be sent, the new samples are dropped when the queue is full
+	QueueSize     int
+	FlushInterval time.Duration
+	MaxRetries    int
+}
+
+func DefaultRemoteWriteOptions(url string) RemoteWriteOptions {
+	return RemoteWriteOptions{
+		URL:           url,
+		Timeout:       10 * time.Second,
+		BatchSize:     500,
+		QueueSize:     10000,
+		FlushInterval: 5 * time.Second,
+		MaxRetries:    3,
+	}
+}
+
+func (opt RemoteWriteOptions) WithTimeout(timeout time.Duration) RemoteWriteOptions {
+	opt.Timeout = timeout
+	return opt
+}
+
+func (opt RemoteWriteOptions) WithFlushInterval(interval time.Duration) RemoteWriteOptions {
+	opt.FlushInterval = interval
+	return opt
+}
+
+// sample A value of a time series, timestamp in milliseconds
+type sample struct {
+	labels    []label
+	value     float64
+	timestamp int64
+}
+
+// RemoteWriter Send the profile meta values to a Prometheus remote write endpoint
+type RemoteWriter struct {
+	opt    RemoteWriteOptions
+	client *http.Client
+	queue  chan sample
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewRemoteWriter(opt RemoteWriteOptions) *RemoteWriter {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &RemoteWriter{
+		opt:    opt,
+		client: &http.Client{Timeout: opt.Timeout},
+		queue:  make(chan sample, opt.QueueSize),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	w.wg.Add(1)
+	go w.run()
+	return w
+}
+
+func (w *RemoteWriter) Send(metas []*storage.ProfileMeta) {
+	for _, meta := range metas {
+		s := sample{labels: seriesLabels(meta), value: float64(meta.Value), timestamp: meta.Timestamp}
+		select {
+		case w.queue <- s:
+		default:
+			metrics.RemoteWriteSamplesTotal.WithLabelValues("dropped").Inc()
+		}
+	}
+}
+
+// Stop Send the queued samples and stop
+func (w *RemoteWriter) Stop() {
+	w.cancel()
+	w.wg.Wait()
+	log.Info("remote writer exit")
+}
+
+func (w *RemoteWriter) run() {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(w.opt.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]sample, 0, w.opt.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		w.write(batch)
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case <-w.ctx.Done():
+			for {
+				select {
+				case s := <-w.queue:
+					batch = append(batch, s)
+					if len(batch) >= w.opt.BatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		case s := <-w.queue:
+			batch = append(batch, s)
+			if len(batch) >= w.opt.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// write Send the samples, the network errors and 5xx are retried
+func (w *RemoteWriter) write(samples []sample) {
+	body := snappy.Encode(nil, encodeWriteRequest(samples))
+
+	var err error
+	for attempt := 0; attempt <= w.opt.MaxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+		var retry bool
+		if retry, err = w.post(body); err == nil || !retry {
+			break
+		}
+	}
+
+	if err != nil {
+		log.WithError(err).WithField("samples", len(samples)).Error("remote write error")
+		metrics.RemoteWriteSamplesTotal.WithLabelValues("failed").Add(float64(len(samples)))
+		return
+	}
+	metrics.RemoteWriteSamplesTotal.WithLabelValues("sent").Add(float64(len(samples)))
+}
+
+func (w *RemoteWriter) post(body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", w.opt.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests,
+			fmt.Errorf("remote write resp status code is %d", resp.StatusCode)
+	}
+	return false, nil
+}
+
+// encodeWriteRequest Encode the samples as a prometheus.WriteRequest protobuf message, a time series per sample
+func encodeWriteRequest(samples []sample) []byte {
+	var b []byte
+	for _, s := range samples {
+		var ts []byte
+		ts = appendLabel(ts, "__name__", MetricName)
+		for _, l := range s.labels {
+			ts = appendLabel(ts, l.name, l.value)
+		}
+
+		var sb []byte
+		sb = protowire.AppendTag(sb, 1, protowire.Fixed64Type)
+		sb = protowire.AppendFixed64(sb, math.Float64bits(s.value))
+		sb = protowire.AppendTag(sb, 2, protowire.VarintType)
+		sb = protowire.AppendVarint(sb, uint64(s.timestamp))
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sb)
+
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, ts)
+	}
+	return b
+}
+
+func appendLabel(b []byte, name, value string) []byte {
+	var lb []byte
+	lb = protowire.AppendTag(lb, 1, protowire.BytesType)
+	lb = protowire.AppendString(lb, name)
+	lb = protowire.AppendTag(lb, 2, protowire.BytesType)
+	lb = protowire.AppendString(lb, value)
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	return protowire.AppendBytes(b, lb)
+}
@@ -0,0 +1,41 @@
+// Package exporter Export the values of the saved profile metas as Prometheus time series
+package exporter
+
+import (
+	"time"
+
+	"github.com/xyctruth/profiler/pkg/storage"
+)
+
+// MetaSink Receive the profile metas after they are saved
+type MetaSink interface {
+	Send(metas []*storage.ProfileMeta)
+}
+
+type store struct {
+	storage.Store
+	sinks []MetaSink
+}
+
+// NewStore Wrap the store, the saved profile metas are sent to the sinks
+func NewStore(s storage.Store, sinks ...MetaSink) storage.Store {
+	return &store{Store: s, sinks: sinks}
+}
+
+func (s *store) SaveProfileMeta(metas []*storage.ProfileMeta, ttl time.Duration) error {
+	// the store may modify the labels of the metas
+	snapshot := make([]*storage.ProfileMeta, 0, len(metas))
+	for _, meta := range metas {
+		m := *meta
+		m.Labels = append([]storage.Label(nil), meta.Labels...)
+		snapshot = append(snapshot, &m)
+	}
+
+	if err := s.Store.SaveProfileMeta(metas, ttl); err != nil {
+		return err
+	}
+	for _, sink := range s.sinks {
+		sink.Send(snapshot)
+	}
+	return nil
+}
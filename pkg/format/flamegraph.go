@@ -0,0 +1,108 @@
+package format
+
+import (
+	"sort"
+
+	"github.com/google/pprof/profile"
+)
+
+// FlameGraph The call tree of a sample type, the node names are indices of the names table
+type FlameGraph struct {
+	Names      []string        `json:"names"`
+	SampleType string          `json:"sample_type"`
+	Unit       string          `json:"unit"`
+	Total      int64           `json:"total"`
+	Root       *FlameGraphNode `json:"root"`
+}
+
+// FlameGraphNode Total is the value of the node and its children, Self is the value of the node only
+type FlameGraphNode struct {
+	Name     int               `json:"n"`
+	Total    int64             `json:"t"`
+	Self     int64             `json:"s"`
+	Children []*FlameGraphNode `json:"c,omitempty"`
+
+	children map[int]*FlameGraphNode
+}
+
+// NewFlameGraph Build the flame graph of the sample type, the nodes less than nodeFraction of the total are pruned
+func NewFlameGraph(p *profile.Profile, sampleIndex int, nodeFraction float64) *FlameGraph {
+	fg := &FlameGraph{
+		Names:      []string{"root"},
+		SampleType: p.SampleType[sampleIndex].Type,
+		Unit:       p.SampleType[sampleIndex].Unit,
+		Root:       &FlameGraphNode{Name: 0, children: make(map[int]*FlameGraphNode)},
+	}
+	nameIndex := map[string]int{"root": 0}
+
+	for _, s := range p.Sample {
+		value := s.Value[sampleIndex]
+		if value == 0 {
+			continue
+		}
+		node := fg.Root
+		node.Total += value
+		for _, frame := range Frames(s) {
+			name, ok := nameIndex[frame]
+			if !ok {
+				name = len(fg.Names)
+				nameIndex[frame] = name
+				fg.Names = append(fg.Names, frame)
+			}
+			child, ok := node.children[name]
+			if !ok {
+				child = &FlameGraphNode{Name: name, children: make(map[int]*FlameGraphNode)}
+				node.children[name] = child
+			}
+			child.Total += value
+			node = child
+		}
+		node.Self += value
+	}
+	fg.Total = fg.Root.Total
+
+	minValue := int64(float64(abs(fg.Total)) * nodeFraction)
+	fg.Root.prune(minValue, fg.Names)
+
+	// keep the names of the remaining nodes only
+	names := fg.Names
+	fg.Names = make([]string, 0, len(fg.Names))
+	index := make(map[int]int)
+	var rename func(n *FlameGraphNode)
+	rename = func(n *FlameGraphNode) {
+		i, ok := index[n.Name]
+		if !ok {
+			i = len(fg.Names)
+			index[n.Name] = i
+			fg.Names = append(fg.Names, names[n.Name])
+		}
+		n.Name = i
+		for _, child := range n.Children {
+			rename(child)
+		}
+	}
+	rename(fg.Root)
+	return fg
+}
+
+// prune Drop the children less than minValue, the children are sorted by name like the flame graphs
+func (n *FlameGraphNode) prune(minValue int64, names []string) {
+	for _, child := range n.children {
+		if abs(child.Total) < minValue || child.Total == 0 {
+			continue
+		}
+		child.prune(minValue, names)
+		n.Children = append(n.Children, child)
+	}
+	sort.Slice(n.Children, func(i, j int) bool {
+		return names[n.Children[i].Name] < names[n.Children[j].Name]
+	})
+	n.children = nil
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
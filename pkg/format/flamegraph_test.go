@@ -0,0 +1,39 @@
+package format
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewFlameGraph(t *testing.T) {
+	p, err := ParseFolded(strings.NewReader("main;foo;bar 60\nmain;foo 20\nmain;baz 19\nmain;tiny 1\n"),
+		&profile.ValueType{Type: "cpu", Unit: "nanoseconds"})
+	require.NoError(t, err)
+
+	fg := NewFlameGraph(p, 0, 0)
+	require.Equal(t, "cpu", fg.SampleType)
+	require.Equal(t, "nanoseconds", fg.Unit)
+	require.Equal(t, int64(100), fg.Total)
+	require.Equal(t, []string{"root", "main", "baz", "foo", "bar", "tiny"}, fg.Names)
+
+	main := fg.Root.Children[0]
+	require.Equal(t, "main", fg.Names[main.Name])
+	require.Equal(t, int64(100), main.Total)
+	require.Equal(t, int64(0), main.Self)
+	require.Equal(t, 3, len(main.Children))
+
+	foo := main.Children[1]
+	require.Equal(t, "foo", fg.Names[foo.Name])
+	require.Equal(t, int64(80), foo.Total)
+	require.Equal(t, int64(20), foo.Self)
+	require.Equal(t, int64(60), foo.Children[0].Self)
+
+	// tiny is pruned and its name is dropped
+	fg = NewFlameGraph(p, 0, 0.05)
+	require.Equal(t, []string{"root", "main", "baz", "foo", "bar"}, fg.Names)
+	require.Equal(t, 2, len(fg.Root.Children[0].Children))
+	require.Equal(t, int64(100), fg.Root.Total)
+}
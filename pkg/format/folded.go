@@ -0,0 +1,191 @@
+// Package format Convert the profiles between pprof and the other formats
+package format
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// ParseFolded Parse the collapsed stacks, each line is the frames from root to leaf separated by ; and the value
+// e.g. "main;foo;bar 10", the profile has a sample type of each value column
+func ParseFolded(r io.Reader, sampleTypes ...*profile.ValueType) (*profile.Profile, error) {
+	if len(sampleTypes) == 0 {
+		sampleTypes = []*profile.ValueType{{Type: "samples", Unit: "count"}}
+	}
+	b := newBuilder(sampleTypes)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		// the values are the last columns
+		stack := text
+		values := make([]int64, len(sampleTypes))
+		for i := len(values) - 1; i >= 0; i-- {
+			idx := strings.LastIndexAny(stack, " \t")
+			if idx < 0 {
+				return nil, fmt.Errorf("line %d: missing stack or value", line)
+			}
+			v, err := strconv.ParseInt(stack[idx+1:], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid value %s", line, stack[idx+1:])
+			}
+			values[i] = v
+			stack = strings.TrimSpace(stack[:idx])
+		}
+		if stack == "" {
+			return nil, fmt.Errorf("line %d: missing stack", line)
+		}
+
+		b.addSample(strings.Split(stack, ";"), values)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return b.p, nil
+}
+
+// ParseLines Parse the stacks without value, each line is a sample of the frames separated by ;
+func ParseLines(r io.Reader, sampleType *profile.ValueType) (*profile.Profile, error) {
+	b := newBuilder([]*profile.ValueType{sampleType})
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		stack := strings.TrimSpace(scanner.Text())
+		if stack == "" {
+			continue
+		}
+		b.addSample(strings.Split(stack, ";"), []int64{1})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return b.p, nil
+}
+
+// builder Build the profile from stacks, the locations and functions are shared by name
+type builder struct {
+	p         *profile.Profile
+	locations map[string]*profile.Location
+	samples   map[string]*profile.Sample
+}
+
+func newBuilder(sampleTypes []*profile.ValueType) *builder {
+	return &builder{
+		p:         &profile.Profile{SampleType: sampleTypes},
+		locations: make(map[string]*profile.Location),
+		samples:   make(map[string]*profile.Sample),
+	}
+}
+
+// addSample Add the values to the sample of the stack, frames from root to leaf
+func (b *builder) addSample(frames []string, values []int64) {
+	key := strings.Join(frames, ";")
+	if s, ok := b.samples[key]; ok {
+		for i := range values {
+			s.Value[i] += values[i]
+		}
+		return
+	}
+
+	s := &profile.Sample{Value: values}
+	// the locations of the pprof sample are from leaf to root
+	for i := len(frames) - 1; i >= 0; i-- {
+		s.Location = append(s.Location, b.location(strings.TrimSpace(frames[i])))
+	}
+	b.samples[key] = s
+	b.p.Sample = append(b.p.Sample, s)
+}
+
+func (b *builder) location(name string) *profile.Location {
+	if l, ok := b.locations[name]; ok {
+		return l
+	}
+	f := &profile.Function{ID: uint64(len(b.p.Function) + 1), Name: name, SystemName: name}
+	b.p.Function = append(b.p.Function, f)
+	l := &profile.Location{ID: uint64(len(b.p.Location) + 1), Line: []profile.Line{{Function: f}}}
+	b.p.Location = append(b.p.Location, l)
+	b.locations[name] = l
+	return l
+}
+
+// WriteFolded Write the collapsed stacks of the sample type, the lines are sorted and the zero values are skipped
+func WriteFolded(w io.Writer, p *profile.Profile, sampleIndex int) error {
+	if sampleIndex < 0 || sampleIndex >= len(p.SampleType) {
+		return fmt.Errorf("sample index %d out of range", sampleIndex)
+	}
+
+	values := make(map[string]int64)
+	for _, s := range p.Sample {
+		if s.Value[sampleIndex] == 0 {
+			continue
+		}
+		values[strings.Join(Frames(s), ";")] += s.Value[sampleIndex]
+	}
+
+	stacks := make([]string, 0, len(values))
+	for stack := range values {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+
+	bw := bufio.NewWriter(w)
+	for _, stack := range stacks {
+		if _, err := fmt.Fprintf(bw, "%s %d\n", stack, values[stack]); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Frames The frame names of the sample from root to leaf, the inlined functions are separate frames
+// and the locations without symbols are named by address
+func Frames(s *profile.Sample) []string {
+	frames := make([]string, 0, len(s.Location))
+	for i := len(s.Location) - 1; i >= 0; i-- {
+		loc := s.Location[i]
+		if len(loc.Line) == 0 {
+			frames = append(frames, fmt.Sprintf("0x%x", loc.Address))
+			continue
+		}
+		// the last line is the caller of the inlined functions
+		for j := len(loc.Line) - 1; j >= 0; j-- {
+			name := "?"
+			if loc.Line[j].Function != nil {
+				name = loc.Line[j].Function.Name
+			}
+			frames = append(frames, name)
+		}
+	}
+	return frames
+}
+
+// SampleIndex The index of the sample type, the last sample type if sampleType is empty like go tool pprof
+func SampleIndex(p *profile.Profile, sampleType string) (int, error) {
+	if sampleType == "" {
+		if p.DefaultSampleType != "" {
+			sampleType = p.DefaultSampleType
+		} else {
+			return len(p.SampleType) - 1, nil
+		}
+	}
+	for i, st := range p.SampleType {
+		if st.Type == sampleType {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("sample type %s not found", sampleType)
+}
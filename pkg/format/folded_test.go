@@ -0,0 +1,89 @@
+package format
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFolded(t *testing.T) {
+	p, err := ParseFolded(strings.NewReader("main;foo;bar 10\nmain;foo 5\n\nmain;foo;bar 2\nmain;with space 1\n"))
+	require.NoError(t, err)
+	require.NoError(t, p.CheckValid())
+	require.Equal(t, "samples", p.SampleType[0].Type)
+	require.Equal(t, 3, len(p.Sample))
+	require.Equal(t, 4, len(p.Function))
+
+	bar := p.Sample[0]
+	require.Equal(t, []int64{12}, bar.Value)
+	require.Equal(t, "bar", bar.Location[0].Line[0].Function.Name)
+	require.Equal(t, "main", bar.Location[2].Line[0].Function.Name)
+	require.Equal(t, "with space", p.Sample[2].Location[0].Line[0].Function.Name)
+
+	// multiple value columns
+	p, err = ParseFolded(strings.NewReader("main;foo 1 100\n"),
+		&profile.ValueType{Type: "samples", Unit: "count"}, &profile.ValueType{Type: "cpu", Unit: "nanoseconds"})
+	require.NoError(t, err)
+	require.Equal(t, []int64{1, 100}, p.Sample[0].Value)
+
+	_, err = ParseFolded(strings.NewReader("main;foo\n"))
+	require.Error(t, err)
+	_, err = ParseFolded(strings.NewReader("main;foo a\n"))
+	require.Error(t, err)
+	_, err = ParseFolded(strings.NewReader(" 10\n"))
+	require.Error(t, err)
+}
+
+func TestParseLines(t *testing.T) {
+	p, err := ParseLines(strings.NewReader("main;foo\nmain;foo\nmain;bar\n"), &profile.ValueType{Type: "goroutine", Unit: "count"})
+	require.NoError(t, err)
+	require.NoError(t, p.CheckValid())
+	require.Equal(t, []int64{2}, p.Sample[0].Value)
+	require.Equal(t, []int64{1}, p.Sample[1].Value)
+}
+
+func TestWriteFolded(t *testing.T) {
+	p, err := ParseFolded(strings.NewReader("main;foo;bar 1 10\nmain;foo 0 5\nmain;baz 2 0\nmain;foo;bar 3 2\n"),
+		&profile.ValueType{Type: "samples", Unit: "count"}, &profile.ValueType{Type: "cpu", Unit: "nanoseconds"})
+	require.NoError(t, err)
+
+	b := &strings.Builder{}
+	require.NoError(t, WriteFolded(b, p, 0))
+	require.Equal(t, "main;baz 2\nmain;foo;bar 4\n", b.String())
+
+	b.Reset()
+	require.NoError(t, WriteFolded(b, p, 1))
+	require.Equal(t, "main;foo 5\nmain;foo;bar 12\n", b.String())
+
+	require.Error(t, WriteFolded(b, p, 2))
+}
+
+func TestFrames(t *testing.T) {
+	main, inlined := &profile.Function{Name: "main"}, &profile.Function{Name: "inlined"}
+	s := &profile.Sample{Location: []*profile.Location{
+		{Address: 0x10},
+		{Line: []profile.Line{{Function: inlined}, {Function: main}}},
+	}}
+	require.Equal(t, []string{"main", "inlined", "0x10"}, Frames(s))
+}
+
+func TestSampleIndex(t *testing.T) {
+	p := &profile.Profile{SampleType: []*profile.ValueType{{Type: "alloc_space"}, {Type: "inuse_space"}}}
+	index, err := SampleIndex(p, "")
+	require.NoError(t, err)
+	require.Equal(t, 1, index)
+
+	index, err = SampleIndex(p, "alloc_space")
+	require.NoError(t, err)
+	require.Equal(t, 0, index)
+
+	p.DefaultSampleType = "alloc_space"
+	index, err = SampleIndex(p, "")
+	require.NoError(t, err)
+	require.Equal(t, 0, index)
+
+	_, err = SampleIndex(p, "cpu")
+	require.Error(t, err)
+}
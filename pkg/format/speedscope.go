@@ -0,0 +1,127 @@
+package format
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/google/pprof/profile"
+)
+
+const speedscopeSchema = "https://www.speedscope.app/file-format-schema.json"
+
+// speedscopeFile The speedscope file format, https://github.com/jlfwong/speedscope/wiki/Importing-from-custom-sources
+type speedscopeFile struct {
+	Schema             string              `json:"$schema"`
+	Shared             speedscopeShared    `json:"shared"`
+	Profiles           []speedscopeProfile `json:"profiles"`
+	Name               string              `json:"name"`
+	ActiveProfileIndex int                 `json:"activeProfileIndex"`
+	Exporter           string              `json:"exporter"`
+}
+
+type speedscopeShared struct {
+	Frames []speedscopeFrame `json:"frames"`
+}
+
+type speedscopeFrame struct {
+	Name string `json:"name"`
+	File string `json:"file,omitempty"`
+	Line int64  `json:"line,omitempty"`
+}
+
+type speedscopeProfile struct {
+	Type       string  `json:"type"`
+	Name       string  `json:"name"`
+	Unit       string  `json:"unit"`
+	StartValue int64   `json:"startValue"`
+	EndValue   int64   `json:"endValue"`
+	Samples    [][]int `json:"samples"`
+	Weights    []int64 `json:"weights"`
+}
+
+// speedscopeUnits The pprof units supported by speedscope, the others are none
+var speedscopeUnits = map[string]string{
+	"nanoseconds":  "nanoseconds",
+	"microseconds": "microseconds",
+	"milliseconds": "milliseconds",
+	"seconds":      "seconds",
+	"bytes":        "bytes",
+}
+
+// WriteSpeedscope Write the profile as a speedscope file, a sampled profile of each sample type
+// and the active profile is the default sample type
+func WriteSpeedscope(w io.Writer, p *profile.Profile, name string) error {
+	file := speedscopeFile{
+		Schema:   speedscopeSchema,
+		Shared:   speedscopeShared{Frames: make([]speedscopeFrame, 0)},
+		Profiles: make([]speedscopeProfile, 0, len(p.SampleType)),
+		Name:     name,
+		Exporter: "profiler",
+	}
+	if index, err := SampleIndex(p, ""); err == nil {
+		file.ActiveProfileIndex = index
+	}
+
+	// the frames are shared by function and line
+	type frameKey struct {
+		fn   *profile.Function
+		line int64
+		addr uint64
+	}
+	frames := make(map[frameKey]int)
+	frame := func(key frameKey, f speedscopeFrame) int {
+		if i, ok := frames[key]; ok {
+			return i
+		}
+		frames[key] = len(file.Shared.Frames)
+		file.Shared.Frames = append(file.Shared.Frames, f)
+		return frames[key]
+	}
+
+	// the stacks of the samples from root to leaf
+	stacks := make([][]int, len(p.Sample))
+	for i, s := range p.Sample {
+		stack := make([]int, 0, len(s.Location))
+		for j := len(s.Location) - 1; j >= 0; j-- {
+			loc := s.Location[j]
+			if len(loc.Line) == 0 {
+				stack = append(stack, frame(frameKey{addr: loc.Address}, speedscopeFrame{Name: fmt.Sprintf("0x%x", loc.Address)}))
+				continue
+			}
+			for k := len(loc.Line) - 1; k >= 0; k-- {
+				line := loc.Line[k]
+				f := speedscopeFrame{Name: "?", Line: line.Line}
+				if line.Function != nil {
+					f.Name, f.File = line.Function.Name, line.Function.Filename
+				}
+				stack = append(stack, frame(frameKey{fn: line.Function, line: line.Line}, f))
+			}
+		}
+		stacks[i] = stack
+	}
+
+	for i, st := range p.SampleType {
+		sp := speedscopeProfile{
+			Type:    "sampled",
+			Name:    fmt.Sprintf("%s %s", name, st.Type),
+			Unit:    "none",
+			Samples: make([][]int, 0, len(p.Sample)),
+			Weights: make([]int64, 0, len(p.Sample)),
+		}
+		if unit, ok := speedscopeUnits[st.Unit]; ok {
+			sp.Unit = unit
+		}
+		for j, s := range p.Sample {
+			if s.Value[i] == 0 {
+				continue
+			}
+			sp.Samples = append(sp.Samples, stacks[j])
+			sp.Weights = append(sp.Weights, s.Value[i])
+			sp.EndValue += s.Value[i]
+		}
+		file.Profiles = append(file.Profiles, sp)
+	}
+
+	return json.NewEncoder(w).Encode(file)
+}
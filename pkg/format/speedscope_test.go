@@ -0,0 +1,38 @@
+package format
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteSpeedscope(t *testing.T) {
+	p, err := ParseFolded(strings.NewReader("main;foo;bar 1 10\nmain;foo 0 5\n"),
+		&profile.ValueType{Type: "samples", Unit: "count"}, &profile.ValueType{Type: "cpu", Unit: "nanoseconds"})
+	require.NoError(t, err)
+
+	b := &bytes.Buffer{}
+	require.NoError(t, WriteSpeedscope(b, p, "app-profile"))
+
+	file := &speedscopeFile{}
+	require.NoError(t, json.Unmarshal(b.Bytes(), file))
+	require.Equal(t, speedscopeSchema, file.Schema)
+	require.Equal(t, 1, file.ActiveProfileIndex)
+	require.Equal(t, []speedscopeFrame{{Name: "main"}, {Name: "foo"}, {Name: "bar"}}, file.Shared.Frames)
+	require.Equal(t, 2, len(file.Profiles))
+
+	samples := file.Profiles[0]
+	require.Equal(t, "none", samples.Unit)
+	require.Equal(t, [][]int{{0, 1, 2}}, samples.Samples)
+	require.Equal(t, []int64{1}, samples.Weights)
+
+	cpu := file.Profiles[1]
+	require.Equal(t, "app-profile cpu", cpu.Name)
+	require.Equal(t, "nanoseconds", cpu.Unit)
+	require.Equal(t, [][]int{{0, 1, 2}, {0, 1}}, cpu.Samples)
+	require.Equal(t, int64(15), cpu.EndValue)
+}
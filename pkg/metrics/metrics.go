@@ -0,0 +1,154 @@
+// Package metrics Prometheus metrics of the profiler itself
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "profiler"
+
+var (
+	// ScrapesTotal Scrapes of the profile endpoints, including the failed scrapes
+	ScrapesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "collector",
+		Name:      "scrapes_total",
+		Help:      "Total number of scrapes by target and profile type.",
+	}, []string{"target", "profile_type"})
+
+	ScrapeErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "collector",
+		Name:      "scrape_errors_total",
+		Help:      "Total number of failed scrapes by target and profile type.",
+	}, []string{"target", "profile_type"})
+
+	// ScrapeDuration Duration of the scrapes, including retries and analysis
+	ScrapeDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "collector",
+		Name:      "scrape_duration_seconds",
+		Help:      "Duration of the scrapes by target and profile type.",
+		Buckets:   []float64{0.05, 0.1, 0.5, 1, 5, 10, 15, 30, 60},
+	}, []string{"target", "profile_type"})
+
+	ScrapeBytesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "collector",
+		Name:      "scrape_bytes_total",
+		Help:      "Total bytes fetched by target and profile type.",
+	}, []string{"target", "profile_type"})
+
+	StoreProfilesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "store",
+		Name:      "profiles_total",
+		Help:      "Total number of the saved profiles.",
+	})
+
+	StoreProfileBytesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "store",
+		Name:      "profile_bytes_total",
+		Help:      "Total compressed bytes of the saved profiles.",
+	})
+
+	StoreProfileMetasTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "store",
+		Name:      "profile_metas_total",
+		Help:      "Total number of the saved profile metas.",
+	})
+
+	// StoreSizeBytes Size of the lsm tree and the value log, updated by the value log gc
+	StoreSizeBytes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "store",
+		Name:      "size_bytes",
+		Help:      "Size of the store by type, lsm or vlog.",
+	}, []string{"type"})
+
+	// StoreGCRunsTotal Value log gc runs, result is rewritten, skipped or error
+	StoreGCRunsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "store",
+		Name:      "gc_runs_total",
+		Help:      "Total number of the value log gc runs by result.",
+	}, []string{"result"})
+
+	UICacheSize = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "ui",
+		Name:      "cache_size",
+		Help:      "Number of the profiles loaded by the ui server.",
+	}, []string{"ui"})
+
+	UIDriverLoadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "ui",
+		Name:      "driver_load_duration_seconds",
+		Help:      "Duration of loading a profile into the ui server.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"ui"})
+
+	// RemoteWriteSamplesTotal Samples of the profile metas sent by remote write, result is sent, failed or dropped
+	RemoteWriteSamplesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "remote_write",
+		Name:      "samples_total",
+		Help:      "Total number of the remote write samples by result.",
+	}, []string{"result"})
+
+	// AlertEvaluationsTotal Evaluations of the alert rules, result is success or error
+	AlertEvaluationsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "alert",
+		Name:      "evaluations_total",
+		Help:      "Total number of the alert rule evaluations by result.",
+	}, []string{"result"})
+
+	AlertsFiredTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "alert",
+		Name:      "alerts_fired_total",
+		Help:      "Total number of the sent alerts by rule.",
+	}, []string{"rule"})
+
+	// AlertNotificationsTotal Webhook requests of the alerts, result is sent or failed
+	AlertNotificationsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "alert",
+		Name:      "notifications_total",
+		Help:      "Total number of the alert webhook requests by receiver and result.",
+	}, []string{"receiver", "result"})
+
+	APIRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "api",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of the api requests by method, route and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "code"})
+)
+
+// Handler Expose the metrics of the default registry
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// HandleAPIMetrics Gin middleware observing the request duration by route,
+// the requests not matching any route are recorded with an empty route
+func HandleAPIMetrics(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+	APIRequestDuration.
+		WithLabelValues(c.Request.Method, c.FullPath(), strconv.Itoa(c.Writer.Status())).
+		Observe(time.Since(start).Seconds())
+}
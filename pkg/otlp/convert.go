@@ -0,0 +1,346 @@
+package otlp
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/pprof/profile"
+	"github.com/xyctruth/profiler/pkg/analysis"
+	"github.com/xyctruth/profiler/pkg/storage"
+)
+
+const (
+	AttrServiceName       = "service.name"
+	AttrServiceInstanceID = "service.instance.id"
+	AttrHostName          = "host.name"
+	AttrK8SPodName        = "k8s.pod.name"
+	AttrK8SDeploymentName = "k8s.deployment.name"
+	AttrProcessExecutable = "process.executable.name"
+	// AttrProfileType The profile attribute of the pprof profile type, e.g. block, the block and mutex profiles
+	// of the go runtime have the same sample types and period type
+	AttrProfileType = "profile_type"
+
+	// UnknownService The target name of the resources without service name
+	UnknownService = "unknown_service"
+)
+
+// labelPrefixes The resource attributes saved as labels, the dots are replaced with _
+var labelPrefixes = []string{"k8s.", "service.namespace", "service.version", "deployment.environment"}
+
+var invalidLabelRegexp = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+var nonAlphanumericRegexp = regexp.MustCompile(`[^a-zA-Z0-9]`)
+
+// ToProfiles Convert the profiles of the request to pprof, defaultInstance is used if the resource has no host name.
+// The profiles failed to convert are rejected, err is the error of the last rejected profile
+func ToProfiles(req *ExportRequest, defaultInstance string) (profiles []analysis.Profile, rejected int, err error) {
+	for _, rp := range req.ResourceProfiles {
+		target, instance, labels := resourceTarget(rp.Resource, defaultInstance)
+		for _, p := range rp.Profiles {
+			prof, convertErr := toPProf(&req.Dictionary, p)
+			if convertErr != nil {
+				rejected++
+				err = convertErr
+				continue
+			}
+
+			attrs, convertErr := profileAttributes(&req.Dictionary, p)
+			if convertErr != nil {
+				rejected++
+				err = convertErr
+				continue
+			}
+			profileType, partial := profileType(prof, attrs)
+			b := &bytes.Buffer{}
+			if convertErr = prof.Write(b); convertErr != nil {
+				rejected++
+				err = convertErr
+				continue
+			}
+
+			ap := analysis.Profile{
+				TargetName:  target,
+				Instance:    instance,
+				ProfileType: profileType,
+				Labels:      labels,
+				Data:        b.Bytes(),
+				Partial:     partial,
+			}
+			if p.TimeNanos > 0 {
+				ap.Time = time.Unix(0, p.TimeNanos)
+			}
+			profiles = append(profiles, ap)
+		}
+	}
+	return profiles, rejected, err
+}
+
+// resourceTarget Map the service name to the target, the host name to the instance, and the k8s attributes to the labels
+func resourceTarget(resource []KeyValue, defaultInstance string) (string, string, []storage.Label) {
+	attrs := make(map[string]string, len(resource))
+	labels := make([]storage.Label, 0)
+	for _, kv := range resource {
+		attrs[kv.Key] = kv.Value
+		for _, prefix := range labelPrefixes {
+			if strings.HasPrefix(kv.Key, prefix) && kv.Value != "" {
+				labels = append(labels, storage.Label{Key: invalidLabelRegexp.ReplaceAllString(kv.Key, "_"), Value: kv.Value})
+				break
+			}
+		}
+	}
+	return firstValue(attrs, AttrServiceName, AttrK8SDeploymentName, AttrProcessExecutable, UnknownService),
+		firstValue(attrs, AttrHostName, AttrK8SPodName, AttrServiceInstanceID, defaultInstance),
+		labels
+}
+
+// firstValue The first non-empty value of the keys, the last argument is the default value
+func firstValue(attrs map[string]string, keys ...string) string {
+	for _, key := range keys[:len(keys)-1] {
+		if v := attrs[key]; v != "" {
+			return v
+		}
+	}
+	return keys[len(keys)-1]
+}
+
+// profileAttributes The attributes of the profile by key
+func profileAttributes(dict *Dictionary, p *Profile) (map[string]string, error) {
+	attrs := make(map[string]string, len(p.AttributeIndices))
+	for _, index := range p.AttributeIndices {
+		if index < 0 || int(index) >= len(dict.Attributes) {
+			return nil, fmt.Errorf("attribute index %d out of range", index)
+		}
+		attrs[dict.Attributes[index].Key] = dict.Attributes[index].Value
+	}
+	return attrs, nil
+}
+
+// profileType The profile type of the profile_type attribute, or guessed by the sample types, partial is true
+// if the profile type scraped from the pprof endpoints has more sample types
+func profileType(prof *profile.Profile, attrs map[string]string) (string, bool) {
+	if pt := nonAlphanumericRegexp.ReplaceAllString(attrs[AttrProfileType], ""); pt != "" {
+		return pt, analysis.IsPartialProfileType(pt)
+	}
+	for _, st := range prof.SampleType {
+		switch st.Type {
+		case "alloc_objects", "alloc_space", "inuse_objects", "inuse_space":
+			return "heap", true
+		case "goroutine", "goroutines":
+			return "goroutine", false
+		case "contentions", "delay":
+			return "mutex", true
+		case "cpu":
+			return "profile", true
+		}
+	}
+	if prof.PeriodType != nil && prof.PeriodType.Type == "cpu" {
+		return "profile", true
+	}
+	if pt := nonAlphanumericRegexp.ReplaceAllString(prof.SampleType[0].Type, ""); pt != "" {
+		return pt, false
+	}
+	return "otlp", false
+}
+
+// converter Convert the dictionary entries referenced by a profile, the pprof ids are the indices plus 1
+type converter struct {
+	dict      *Dictionary
+	mappings  map[int32]*profile.Mapping
+	locations map[int32]*profile.Location
+	functions map[int32]*profile.Function
+	prof      *profile.Profile
+}
+
+func toPProf(dict *Dictionary, p *Profile) (*profile.Profile, error) {
+	if len(p.SampleTypes) == 0 {
+		return nil, fmt.Errorf("sample type is nil")
+	}
+	c := &converter{
+		dict:      dict,
+		mappings:  make(map[int32]*profile.Mapping),
+		locations: make(map[int32]*profile.Location),
+		functions: make(map[int32]*profile.Function),
+		prof: &profile.Profile{
+			TimeNanos:     p.TimeNanos,
+			DurationNanos: p.DurationNanos,
+			Period:        p.Period,
+		},
+	}
+	prof := c.prof
+
+	for _, vt := range p.SampleTypes {
+		st, err := c.valueType(vt)
+		if err != nil {
+			return nil, err
+		}
+		prof.SampleType = append(prof.SampleType, st)
+	}
+	if p.PeriodType.Type != 0 {
+		pt, err := c.valueType(p.PeriodType)
+		if err != nil {
+			return nil, err
+		}
+		prof.PeriodType = pt
+	}
+
+	for _, s := range p.Samples {
+		sample, err := c.sample(p, s)
+		if err != nil {
+			return nil, err
+		}
+		prof.Sample = append(prof.Sample, sample)
+	}
+
+	if err := prof.CheckValid(); err != nil {
+		return nil, err
+	}
+	return prof, nil
+}
+
+func (c *converter) sample(p *Profile, s Sample) (*profile.Sample, error) {
+	values := s.Values
+	if len(values) == 0 && len(p.SampleTypes) == 1 {
+		// the samples with timestamps only
+		values = []int64{int64(len(s.TimestampsUnixNano))}
+	}
+	if len(values) != len(p.SampleTypes) {
+		return nil, fmt.Errorf("sample has %d values, want %d", len(values), len(p.SampleTypes))
+	}
+
+	start, end := int(s.LocationsStart), int(s.LocationsStart)+int(s.LocationsLength)
+	if start < 0 || end < start || end > len(p.LocationIndices) {
+		return nil, fmt.Errorf("sample locations [%d:%d] out of range", start, end)
+	}
+
+	sample := &profile.Sample{Value: values}
+	for _, index := range p.LocationIndices[start:end] {
+		loc, err := c.location(index)
+		if err != nil {
+			return nil, err
+		}
+		sample.Location = append(sample.Location, loc)
+	}
+
+	for _, index := range s.AttributeIndices {
+		if index < 0 || int(index) >= len(c.dict.Attributes) {
+			return nil, fmt.Errorf("attribute index %d out of range", index)
+		}
+		attr := c.dict.Attributes[index]
+		if sample.Label == nil {
+			sample.Label = make(map[string][]string)
+		}
+		sample.Label[attr.Key] = append(sample.Label[attr.Key], attr.Value)
+	}
+	return sample, nil
+}
+
+func (c *converter) location(index int32) (*profile.Location, error) {
+	if loc, ok := c.locations[index]; ok {
+		return loc, nil
+	}
+	if index < 0 || int(index) >= len(c.dict.Locations) {
+		return nil, fmt.Errorf("location index %d out of range", index)
+	}
+	l := c.dict.Locations[index]
+
+	loc := &profile.Location{ID: uint64(index) + 1, Address: l.Address}
+	if l.MappingIndex >= 0 {
+		m, err := c.mapping(l.MappingIndex)
+		if err != nil {
+			return nil, err
+		}
+		loc.Mapping = m
+	}
+	for _, line := range l.Lines {
+		fn, err := c.function(line.FunctionIndex)
+		if err != nil {
+			return nil, err
+		}
+		loc.Line = append(loc.Line, profile.Line{Function: fn, Line: line.Line})
+	}
+
+	c.locations[index] = loc
+	c.prof.Location = append(c.prof.Location, loc)
+	return loc, nil
+}
+
+func (c *converter) mapping(index int32) (*profile.Mapping, error) {
+	if m, ok := c.mappings[index]; ok {
+		return m, nil
+	}
+	if int(index) >= len(c.dict.Mappings) {
+		return nil, fmt.Errorf("mapping index %d out of range", index)
+	}
+	m := c.dict.Mappings[index]
+	file, err := c.str(m.Filename)
+	if err != nil {
+		return nil, err
+	}
+
+	mapping := &profile.Mapping{
+		ID:              uint64(index) + 1,
+		Start:           m.MemoryStart,
+		Limit:           m.MemoryLimit,
+		Offset:          m.FileOffset,
+		File:            file,
+		HasFunctions:    m.HasFunctions,
+		HasFilenames:    m.HasFilenames,
+		HasLineNumbers:  m.HasLineNumbers,
+		HasInlineFrames: m.HasInlineFrames,
+	}
+	c.mappings[index] = mapping
+	c.prof.Mapping = append(c.prof.Mapping, mapping)
+	return mapping, nil
+}
+
+func (c *converter) function(index int32) (*profile.Function, error) {
+	if fn, ok := c.functions[index]; ok {
+		return fn, nil
+	}
+	if index < 0 || int(index) >= len(c.dict.Functions) {
+		return nil, fmt.Errorf("function index %d out of range", index)
+	}
+	f := c.dict.Functions[index]
+
+	fn := &profile.Function{ID: uint64(index) + 1, StartLine: f.StartLine}
+	var err error
+	if fn.Name, err = c.str(f.Name); err != nil {
+		return nil, err
+	}
+	if fn.SystemName, err = c.str(f.SystemName); err != nil {
+		return nil, err
+	}
+	if fn.Filename, err = c.str(f.Filename); err != nil {
+		return nil, err
+	}
+
+	c.functions[index] = fn
+	c.prof.Function = append(c.prof.Function, fn)
+	return fn, nil
+}
+
+func (c *converter) valueType(vt ValueType) (*profile.ValueType, error) {
+	typ, err := c.str(vt.Type)
+	if err != nil {
+		return nil, err
+	}
+	unit, err := c.str(vt.Unit)
+	if err != nil {
+		return nil, err
+	}
+	return &profile.ValueType{Type: typ, Unit: unit}, nil
+}
+
+// str The string of the string table, index 0 is the empty string
+func (c *converter) str(index int32) (string, error) {
+	if index == 0 {
+		return "", nil
+	}
+	if index < 0 || int(index) >= len(c.dict.Strings) {
+		return "", fmt.Errorf("string index %d out of range", index)
+	}
+	return c.dict.Strings[index], nil
+}
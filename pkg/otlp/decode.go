@@ -0,0 +1,426 @@
+// Package otlp Decode the OpenTelemetry OTLP profiles signal and convert it to pprof,
+// the messages follow opentelemetry/proto/profiles/v1development of the OTLP 1.7.0 release,
+// the decoder is tested with testdata/heap.pb encoded by the generated go.opentelemetry.io/proto/otlp v1.7.0 messages
+package otlp
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// ExportRequest The ExportProfilesServiceRequest message
+type ExportRequest struct {
+	ResourceProfiles []*ResourceProfiles
+	Dictionary       Dictionary
+}
+
+// Dictionary The tables shared by all profiles of the request, referenced by index
+type Dictionary struct {
+	Mappings   []Mapping
+	Locations  []Location
+	Functions  []Function
+	Strings    []string
+	Attributes []KeyValue
+}
+
+type ResourceProfiles struct {
+	Resource []KeyValue
+	Profiles []*Profile
+}
+
+type Profile struct {
+	SampleTypes []ValueType
+	Samples     []Sample
+	// LocationIndices The location table indices referenced by the samples
+	LocationIndices []int32
+	TimeNanos       int64
+	DurationNanos   int64
+	PeriodType      ValueType
+	Period          int64
+	// AttributeIndices The attribute table indices of the profile attributes
+	AttributeIndices []int32
+}
+
+// ValueType The string table indices of the type and unit
+type ValueType struct {
+	Type int32
+	Unit int32
+}
+
+type Sample struct {
+	// LocationsStart and LocationsLength The range of the profile location indices
+	LocationsStart     int32
+	LocationsLength    int32
+	Values             []int64
+	AttributeIndices   []int32
+	TimestampsUnixNano []uint64
+}
+
+type Mapping struct {
+	MemoryStart     uint64
+	MemoryLimit     uint64
+	FileOffset      uint64
+	Filename        int32
+	HasFunctions    bool
+	HasFilenames    bool
+	HasLineNumbers  bool
+	HasInlineFrames bool
+}
+
+type Location struct {
+	// MappingIndex -1 if the location has no mapping
+	MappingIndex int32
+	Address      uint64
+	Lines        []Line
+}
+
+type Line struct {
+	FunctionIndex int32
+	Line          int64
+}
+
+type Function struct {
+	Name       int32
+	SystemName int32
+	Filename   int32
+	StartLine  int64
+}
+
+// KeyValue An attribute, the value is formatted as string
+type KeyValue struct {
+	Key   string
+	Value string
+}
+
+// field A decoded field, v is the bytes of the length delimited field, x is the value of the others
+type field struct {
+	num protowire.Number
+	typ protowire.Type
+	v   []byte
+	x   uint64
+}
+
+// walk Call fn with each field of the message
+func walk(b []byte, fn func(f field) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		f := field{num: num, typ: typ}
+		switch typ {
+		case protowire.VarintType:
+			f.x, n = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			f.v, n = protowire.ConsumeBytes(b)
+		case protowire.Fixed64Type:
+			f.x, n = protowire.ConsumeFixed64(b)
+		case protowire.Fixed32Type:
+			var x uint32
+			x, n = protowire.ConsumeFixed32(b)
+			f.x = uint64(x)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// varints The values of a repeated varint field, packed or not
+func (f field) varints() ([]uint64, error) {
+	switch f.typ {
+	case protowire.VarintType:
+		return []uint64{f.x}, nil
+	case protowire.BytesType:
+		values := make([]uint64, 0, len(f.v))
+		b := f.v
+		for len(b) > 0 {
+			x, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			values = append(values, x)
+			b = b[n:]
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("field %d: unexpected wire type %d", f.num, f.typ)
+}
+
+func appendInt32s(dst []int32, f field) ([]int32, error) {
+	values, err := f.varints()
+	for _, x := range values {
+		dst = append(dst, int32(x))
+	}
+	return dst, err
+}
+
+// Decode Decode the protobuf encoded ExportProfilesServiceRequest
+func Decode(data []byte) (*ExportRequest, error) {
+	req := &ExportRequest{}
+	err := walk(data, func(f field) error {
+		switch f.num {
+		case 1:
+			rp, err := decodeResourceProfiles(f.v)
+			if err != nil {
+				return err
+			}
+			req.ResourceProfiles = append(req.ResourceProfiles, rp)
+		case 2:
+			return decodeDictionary(f.v, &req.Dictionary)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeDictionary(b []byte, d *Dictionary) error {
+	return walk(b, func(f field) error {
+		switch f.num {
+		case 1:
+			m, err := decodeMapping(f.v)
+			d.Mappings = append(d.Mappings, m)
+			return err
+		case 2:
+			l, err := decodeLocation(f.v)
+			d.Locations = append(d.Locations, l)
+			return err
+		case 3:
+			fn, err := decodeFunction(f.v)
+			d.Functions = append(d.Functions, fn)
+			return err
+		case 5:
+			d.Strings = append(d.Strings, string(f.v))
+		case 6:
+			kv, err := decodeKeyValue(f.v)
+			d.Attributes = append(d.Attributes, kv)
+			return err
+		}
+		return nil
+	})
+}
+
+func decodeResourceProfiles(b []byte) (*ResourceProfiles, error) {
+	rp := &ResourceProfiles{}
+	err := walk(b, func(f field) error {
+		switch f.num {
+		case 1:
+			// Resource
+			return walk(f.v, func(f field) error {
+				if f.num == 1 {
+					kv, err := decodeKeyValue(f.v)
+					rp.Resource = append(rp.Resource, kv)
+					return err
+				}
+				return nil
+			})
+		case 2:
+			// ScopeProfiles, the scope is ignored
+			return walk(f.v, func(f field) error {
+				if f.num == 2 {
+					p, err := decodeProfile(f.v)
+					rp.Profiles = append(rp.Profiles, p)
+					return err
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return rp, err
+}
+
+func decodeProfile(b []byte) (*Profile, error) {
+	p := &Profile{}
+	err := walk(b, func(f field) error {
+		var err error
+		switch f.num {
+		case 1:
+			var vt ValueType
+			vt, err = decodeValueType(f.v)
+			p.SampleTypes = append(p.SampleTypes, vt)
+		case 2:
+			var s Sample
+			s, err = decodeSample(f.v)
+			p.Samples = append(p.Samples, s)
+		case 3:
+			p.LocationIndices, err = appendInt32s(p.LocationIndices, f)
+		case 4:
+			p.TimeNanos = int64(f.x)
+		case 5:
+			p.DurationNanos = int64(f.x)
+		case 6:
+			p.PeriodType, err = decodeValueType(f.v)
+		case 7:
+			p.Period = int64(f.x)
+		case 14:
+			p.AttributeIndices, err = appendInt32s(p.AttributeIndices, f)
+		}
+		return err
+	})
+	return p, err
+}
+
+func decodeValueType(b []byte) (ValueType, error) {
+	vt := ValueType{}
+	err := walk(b, func(f field) error {
+		switch f.num {
+		case 1:
+			vt.Type = int32(f.x)
+		case 2:
+			vt.Unit = int32(f.x)
+		}
+		return nil
+	})
+	return vt, err
+}
+
+func decodeSample(b []byte) (Sample, error) {
+	s := Sample{}
+	err := walk(b, func(f field) error {
+		switch f.num {
+		case 1:
+			s.LocationsStart = int32(f.x)
+		case 2:
+			s.LocationsLength = int32(f.x)
+		case 3:
+			values, err := f.varints()
+			for _, x := range values {
+				s.Values = append(s.Values, int64(x))
+			}
+			return err
+		case 4:
+			var err error
+			s.AttributeIndices, err = appendInt32s(s.AttributeIndices, f)
+			return err
+		case 6:
+			values, err := f.varints()
+			s.TimestampsUnixNano = append(s.TimestampsUnixNano, values...)
+			return err
+		}
+		return nil
+	})
+	return s, err
+}
+
+func decodeMapping(b []byte) (Mapping, error) {
+	m := Mapping{}
+	err := walk(b, func(f field) error {
+		switch f.num {
+		case 1:
+			m.MemoryStart = f.x
+		case 2:
+			m.MemoryLimit = f.x
+		case 3:
+			m.FileOffset = f.x
+		case 4:
+			m.Filename = int32(f.x)
+		case 6:
+			m.HasFunctions = f.x != 0
+		case 7:
+			m.HasFilenames = f.x != 0
+		case 8:
+			m.HasLineNumbers = f.x != 0
+		case 9:
+			m.HasInlineFrames = f.x != 0
+		}
+		return nil
+	})
+	return m, err
+}
+
+func decodeLocation(b []byte) (Location, error) {
+	l := Location{MappingIndex: -1}
+	err := walk(b, func(f field) error {
+		switch f.num {
+		case 1:
+			l.MappingIndex = int32(f.x)
+		case 2:
+			l.Address = f.x
+		case 3:
+			line := Line{}
+			err := walk(f.v, func(f field) error {
+				switch f.num {
+				case 1:
+					line.FunctionIndex = int32(f.x)
+				case 2:
+					line.Line = int64(f.x)
+				}
+				return nil
+			})
+			l.Lines = append(l.Lines, line)
+			return err
+		}
+		return nil
+	})
+	return l, err
+}
+
+func decodeFunction(b []byte) (Function, error) {
+	fn := Function{}
+	err := walk(b, func(f field) error {
+		switch f.num {
+		case 1:
+			fn.Name = int32(f.x)
+		case 2:
+			fn.SystemName = int32(f.x)
+		case 3:
+			fn.Filename = int32(f.x)
+		case 4:
+			fn.StartLine = int64(f.x)
+		}
+		return nil
+	})
+	return fn, err
+}
+
+func decodeKeyValue(b []byte) (KeyValue, error) {
+	kv := KeyValue{}
+	err := walk(b, func(f field) error {
+		switch f.num {
+		case 1:
+			kv.Key = string(f.v)
+		case 2:
+			var err error
+			kv.Value, err = decodeAnyValue(f.v)
+			return err
+		}
+		return nil
+	})
+	return kv, err
+}
+
+// decodeAnyValue Format the string, bool, int and double values, the array, kvlist and bytes values are empty
+func decodeAnyValue(b []byte) (string, error) {
+	value := ""
+	err := walk(b, func(f field) error {
+		switch f.num {
+		case 1:
+			value = string(f.v)
+		case 2:
+			value = strconv.FormatBool(f.x != 0)
+		case 3:
+			value = strconv.FormatInt(int64(f.x), 10)
+		case 4:
+			value = strconv.FormatFloat(math.Float64frombits(f.x), 'g', -1, 64)
+		}
+		return nil
+	})
+	return value, err
+}
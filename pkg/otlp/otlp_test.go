@@ -0,0 +1,267 @@
+package otlp
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+	"github.com/xyctruth/profiler/pkg/storage"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// message Encode the fields of a message for the tests
+type message []byte
+
+func (m message) bytes(num protowire.Number, b []byte) message {
+	m = protowire.AppendTag(m, num, protowire.BytesType)
+	return protowire.AppendBytes(m, b)
+}
+
+func (m message) varint(num protowire.Number, x uint64) message {
+	m = protowire.AppendTag(m, num, protowire.VarintType)
+	return protowire.AppendVarint(m, x)
+}
+
+func (m message) packed(num protowire.Number, xs ...uint64) message {
+	var b []byte
+	for _, x := range xs {
+		b = protowire.AppendVarint(b, x)
+	}
+	return m.bytes(num, b)
+}
+
+func keyValue(key, value string) message {
+	return message{}.bytes(1, []byte(key)).bytes(2, message{}.bytes(1, []byte(value)))
+}
+
+// testRequest A cpu profile of the ebpf profiler, main -> foo and main -> bar
+func testRequest() []byte {
+	strings := []string{"", "samples", "count", "cpu", "nanoseconds", "main", "foo", "bar", "main.go", "/app"}
+
+	dict := message{}
+	dict = dict.bytes(1, message{}.varint(1, 0x1000).varint(2, 0x2000).varint(4, 9).varint(6, 1))
+	for _, name := range []uint64{5, 6, 7} {
+		dict = dict.bytes(3, message{}.varint(1, name).varint(3, 8))
+	}
+	for i := uint64(0); i < 3; i++ {
+		line := message{}.varint(1, i).varint(2, 10+i)
+		dict = dict.bytes(2, message{}.varint(1, 0).varint(2, 0x1000+i).bytes(3, line))
+	}
+	for _, s := range strings {
+		dict = dict.bytes(5, []byte(s))
+	}
+	dict = dict.bytes(6, keyValue("thread.name", "worker"))
+
+	p := message{}.
+		bytes(1, message{}.varint(1, 1).varint(2, 2)).
+		// foo, main, bar, main
+		packed(3, 1, 0, 2, 0).
+		varint(4, uint64(time.Unix(100, 0).UnixNano())).
+		varint(5, uint64(10*time.Second)).
+		bytes(6, message{}.varint(1, 3).varint(2, 4)).
+		varint(7, 20000000)
+	p = p.bytes(2, message{}.varint(1, 0).varint(2, 2).packed(3, 5).packed(4, 0))
+	// timestamps only
+	p = p.bytes(2, message{}.varint(1, 2).varint(2, 2).packed(6, 1, 2, 3))
+
+	resource := message{}.
+		bytes(1, keyValue(AttrServiceName, "checkout")).
+		bytes(1, keyValue(AttrHostName, "node-1")).
+		bytes(1, keyValue("k8s.namespace.name", "prod")).
+		bytes(1, keyValue("process.pid", "1"))
+	rp := message{}.bytes(1, resource).bytes(2, message{}.bytes(2, p))
+
+	return message{}.bytes(1, rp).bytes(2, dict)
+}
+
+// testBlockRequest A block profile of the go runtime, main -> sync.(*Mutex).Lock, with the profile_type attribute
+func testBlockRequest() []byte {
+	strings := []string{"", "contentions", "count", "delay", "nanoseconds", "main", "sync.(*Mutex).Lock"}
+
+	dict := message{}
+	for _, name := range []uint64{5, 6} {
+		dict = dict.bytes(3, message{}.varint(1, name))
+	}
+	for i := uint64(0); i < 2; i++ {
+		dict = dict.bytes(2, message{}.bytes(3, message{}.varint(1, i)))
+	}
+	for _, s := range strings {
+		dict = dict.bytes(5, []byte(s))
+	}
+	dict = dict.bytes(6, keyValue(AttrProfileType, "block"))
+
+	p := message{}.
+		bytes(1, message{}.varint(1, 1).varint(2, 2)).
+		bytes(1, message{}.varint(1, 3).varint(2, 4)).
+		packed(3, 1, 0).
+		bytes(6, message{}.varint(1, 1).varint(2, 2)).
+		varint(7, 1).
+		packed(14, 0)
+	p = p.bytes(2, message{}.varint(1, 0).varint(2, 2).packed(3, 2, 3000))
+
+	resource := message{}.bytes(1, keyValue(AttrServiceName, "checkout"))
+	rp := message{}.bytes(1, resource).bytes(2, message{}.bytes(2, p))
+	return message{}.bytes(1, rp).bytes(2, dict)
+}
+
+func TestDecode(t *testing.T) {
+	req, err := Decode(testRequest())
+	require.NoError(t, err)
+	require.Equal(t, 1, len(req.ResourceProfiles))
+	require.Equal(t, 4, len(req.ResourceProfiles[0].Resource))
+	require.Equal(t, 10, len(req.Dictionary.Strings))
+	require.Equal(t, 3, len(req.Dictionary.Locations))
+	require.Equal(t, int32(0), req.Dictionary.Locations[0].MappingIndex)
+
+	p := req.ResourceProfiles[0].Profiles[0]
+	require.Equal(t, []int32{1, 0, 2, 0}, p.LocationIndices)
+	require.Equal(t, []int64{5}, p.Samples[0].Values)
+	require.Equal(t, []uint64{1, 2, 3}, p.Samples[1].TimestampsUnixNano)
+
+	_, err = Decode([]byte{0x0a, 0x10})
+	require.Error(t, err)
+}
+
+func TestToProfiles(t *testing.T) {
+	req, err := Decode(testRequest())
+	require.NoError(t, err)
+
+	profiles, rejected, err := ToProfiles(req, "10.0.0.1")
+	require.NoError(t, err)
+	require.Equal(t, 0, rejected)
+	require.Equal(t, 1, len(profiles))
+
+	p := profiles[0]
+	require.Equal(t, "checkout", p.TargetName)
+	require.Equal(t, "node-1", p.Instance)
+	require.Equal(t, "profile", p.ProfileType)
+	require.True(t, p.Partial)
+	require.Equal(t, []storage.Label{{Key: "k8s_namespace_name", Value: "prod"}}, p.Labels)
+	require.Equal(t, time.Unix(100, 0), p.Time)
+
+	prof, err := profile.ParseData(p.Data)
+	require.NoError(t, err)
+	require.Equal(t, "samples", prof.SampleType[0].Type)
+	require.Equal(t, "cpu", prof.PeriodType.Type)
+	require.Equal(t, int64(10*time.Second), prof.DurationNanos)
+	require.Equal(t, 2, len(prof.Sample))
+	require.Equal(t, []int64{5}, prof.Sample[0].Value)
+	require.Equal(t, []string{"worker"}, prof.Sample[0].Label["thread.name"])
+	require.Equal(t, "foo", prof.Sample[0].Location[0].Line[0].Function.Name)
+	require.Equal(t, "main", prof.Sample[0].Location[1].Line[0].Function.Name)
+	require.Equal(t, []int64{3}, prof.Sample[1].Value)
+	require.Equal(t, "/app", prof.Mapping[0].File)
+
+	// the resource without service name and host name
+	req.ResourceProfiles[0].Resource = []KeyValue{{Key: AttrK8SPodName, Value: "pod-0"}}
+	profiles, _, err = ToProfiles(req, "10.0.0.1")
+	require.NoError(t, err)
+	require.Equal(t, UnknownService, profiles[0].TargetName)
+	require.Equal(t, "pod-0", profiles[0].Instance)
+
+	req.ResourceProfiles[0].Resource = nil
+	profiles, _, err = ToProfiles(req, "10.0.0.1")
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.1", profiles[0].Instance)
+
+	// the block profiles are not mutex profiles
+	blockReq, err := Decode(testBlockRequest())
+	require.NoError(t, err)
+	require.Equal(t, []int32{0}, blockReq.ResourceProfiles[0].Profiles[0].AttributeIndices)
+	profiles, _, err = ToProfiles(blockReq, "10.0.0.1")
+	require.NoError(t, err)
+	require.Equal(t, "block", profiles[0].ProfileType)
+	require.True(t, profiles[0].Partial)
+
+	// invalid indices are rejected
+	req.ResourceProfiles[0].Profiles[0].Samples[0].LocationsLength = 10
+	profiles, rejected, err = ToProfiles(req, "")
+	require.Error(t, err)
+	require.Equal(t, 1, rejected)
+	require.Equal(t, 0, len(profiles))
+}
+
+// TestGolden The payload is encoded by the generated go.opentelemetry.io/proto/otlp messages, see testdata/gen
+func TestGolden(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/heap.pb")
+	require.NoError(t, err)
+	req, err := Decode(data)
+	require.NoError(t, err)
+
+	profiles, rejected, err := ToProfiles(req, "10.0.0.1")
+	require.NoError(t, err)
+	require.Equal(t, 0, rejected)
+	require.Equal(t, 1, len(profiles))
+	require.Equal(t, "checkout", profiles[0].TargetName)
+	require.Equal(t, "node-1", profiles[0].Instance)
+	require.Equal(t, "heap", profiles[0].ProfileType)
+	require.True(t, profiles[0].Partial)
+	require.Equal(t, []storage.Label{{Key: "k8s_namespace_name", Value: "prod"}}, profiles[0].Labels)
+
+	prof, err := profile.ParseData(profiles[0].Data)
+	require.NoError(t, err)
+	data, err = ioutil.ReadFile("../apiserver/testdata/profile.out.testdata")
+	require.NoError(t, err)
+	want, err := profile.ParseData(data)
+	require.NoError(t, err)
+
+	require.Equal(t, want.SampleType, prof.SampleType)
+	require.Equal(t, want.PeriodType, prof.PeriodType)
+	require.Equal(t, want.Period, prof.Period)
+	require.Equal(t, want.TimeNanos, prof.TimeNanos)
+	require.Equal(t, len(want.Sample), len(prof.Sample))
+	for i, s := range prof.Sample {
+		require.Equal(t, want.Sample[i].Value, s.Value)
+		require.Equal(t, len(want.Sample[i].Location), len(s.Location))
+		for j, loc := range s.Location {
+			require.Equal(t, want.Sample[i].Location[j].Address, loc.Address)
+			require.Equal(t, want.Sample[i].Location[j].Mapping.File, loc.Mapping.File)
+			require.Equal(t, len(want.Sample[i].Location[j].Line), len(loc.Line))
+			for k, line := range loc.Line {
+				require.Equal(t, want.Sample[i].Location[j].Line[k].Function.Name, line.Function.Name)
+				require.Equal(t, want.Sample[i].Location[j].Line[k].Function.Filename, line.Function.Filename)
+				require.Equal(t, want.Sample[i].Location[j].Line[k].Line, line.Line)
+			}
+		}
+	}
+}
+
+func TestProfileType(t *testing.T) {
+	tests := []struct {
+		sampleTypes []string
+		periodType  string
+		profileType string
+		partial     bool
+	}{
+		{[]string{"samples", "cpu"}, "cpu", "profile", true},
+		{[]string{"samples"}, "cpu", "profile", true},
+		{[]string{"alloc_space"}, "space", "heap", true},
+		{[]string{"goroutine"}, "goroutine", "goroutine", false},
+		{[]string{"contentions", "delay"}, "contentions", "mutex", true},
+		{[]string{"off_cpu.time"}, "", "offcputime", false},
+		{[]string{"-"}, "", "otlp", false},
+	}
+	for _, tt := range tests {
+		prof := &profile.Profile{PeriodType: &profile.ValueType{Type: tt.periodType}}
+		for _, st := range tt.sampleTypes {
+			prof.SampleType = append(prof.SampleType, &profile.ValueType{Type: st})
+		}
+		profileType, partial := profileType(prof, map[string]string{})
+		require.Equal(t, tt.profileType, profileType, tt.sampleTypes)
+		require.Equal(t, tt.partial, partial, tt.sampleTypes)
+	}
+
+	// the block profiles have the same sample types as the mutex profiles
+	prof := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "contentions"}, {Type: "delay"}},
+		PeriodType: &profile.ValueType{Type: "contentions"},
+	}
+	pt, partial := profileType(prof, map[string]string{AttrProfileType: "block"})
+	require.Equal(t, "block", pt)
+	require.True(t, partial)
+	pt, partial = profileType(prof, map[string]string{AttrProfileType: "off-cpu"})
+	require.Equal(t, "offcpu", pt)
+	require.False(t, partial)
+}
@@ -0,0 +1,148 @@
+// Command gen Encode the heap profile of the apiserver testdata as an OTLP ExportProfilesServiceRequest with the
+// generated go.opentelemetry.io/proto/otlp messages, the golden payload of the decoder tests.
+//
+//	cd pkg/otlp/testdata/gen && go run . -o ../heap.pb
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/google/pprof/profile"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/profiles/v1development"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	profilespb "go.opentelemetry.io/proto/otlp/profiles/v1development"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func main() {
+	in := flag.String("i", "../../../apiserver/testdata/profile.out.testdata", "pprof file")
+	out := flag.String("o", "../heap.pb", "output file")
+	flag.Parse()
+
+	data, err := os.ReadFile(*in)
+	if err != nil {
+		panic(err)
+	}
+	p, err := profile.ParseData(data)
+	if err != nil {
+		panic(err)
+	}
+	b, err := proto.Marshal(convert(p))
+	if err != nil {
+		panic(err)
+	}
+	if err = os.WriteFile(*out, b, 0o644); err != nil {
+		panic(err)
+	}
+}
+
+type dictionary struct {
+	*profilespb.ProfilesDictionary
+	strings map[string]int32
+}
+
+func (d *dictionary) str(s string) int32 {
+	if i, ok := d.strings[s]; ok {
+		return i
+	}
+	i := int32(len(d.StringTable))
+	d.strings[s] = i
+	d.StringTable = append(d.StringTable, s)
+	return i
+}
+
+func (d *dictionary) attr(key, value string) int32 {
+	d.AttributeTable = append(d.AttributeTable, keyValue(key, value))
+	return int32(len(d.AttributeTable) - 1)
+}
+
+func keyValue(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
+
+// convert The pprof to OTLP, the dictionary tables are in the order of the pprof tables
+func convert(p *profile.Profile) *collectorpb.ExportProfilesServiceRequest {
+	d := &dictionary{ProfilesDictionary: &profilespb.ProfilesDictionary{}, strings: map[string]int32{}}
+	d.str("")
+
+	mappings := map[uint64]int32{}
+	for i, m := range p.Mapping {
+		mappings[m.ID] = int32(i)
+		d.MappingTable = append(d.MappingTable, &profilespb.Mapping{
+			MemoryStart:      m.Start,
+			MemoryLimit:      m.Limit,
+			FileOffset:       m.Offset,
+			FilenameStrindex: d.str(m.File),
+			HasFunctions:     m.HasFunctions,
+			HasFilenames:     m.HasFilenames,
+			HasLineNumbers:   m.HasLineNumbers,
+		})
+	}
+	functions := map[uint64]int32{}
+	for i, fn := range p.Function {
+		functions[fn.ID] = int32(i)
+		d.FunctionTable = append(d.FunctionTable, &profilespb.Function{
+			NameStrindex:       d.str(fn.Name),
+			SystemNameStrindex: d.str(fn.SystemName),
+			FilenameStrindex:   d.str(fn.Filename),
+			StartLine:          fn.StartLine,
+		})
+	}
+	locations := map[uint64]int32{}
+	for i, loc := range p.Location {
+		locations[loc.ID] = int32(i)
+		l := &profilespb.Location{Address: loc.Address}
+		if loc.Mapping != nil {
+			index := mappings[loc.Mapping.ID]
+			l.MappingIndex = &index
+		}
+		for _, line := range loc.Line {
+			l.Line = append(l.Line, &profilespb.Line{FunctionIndex: functions[line.Function.ID], Line: line.Line})
+		}
+		d.LocationTable = append(d.LocationTable, l)
+	}
+
+	op := &profilespb.Profile{
+		TimeNanos:        p.TimeNanos,
+		DurationNanos:    p.DurationNanos,
+		PeriodType:       &profilespb.ValueType{TypeStrindex: d.str(p.PeriodType.Type), UnitStrindex: d.str(p.PeriodType.Unit)},
+		Period:           p.Period,
+		AttributeIndices: []int32{d.attr("profile_type", "heap")},
+	}
+	for _, st := range p.SampleType {
+		op.SampleType = append(op.SampleType, &profilespb.ValueType{TypeStrindex: d.str(st.Type), UnitStrindex: d.str(st.Unit)})
+	}
+	for _, s := range p.Sample {
+		sample := &profilespb.Sample{
+			LocationsStartIndex: int32(len(op.LocationIndices)),
+			LocationsLength:     int32(len(s.Location)),
+			Value:               s.Value,
+		}
+		for _, loc := range s.Location {
+			op.LocationIndices = append(op.LocationIndices, locations[loc.ID])
+		}
+		for key, values := range s.Label {
+			for _, value := range values {
+				sample.AttributeIndices = append(sample.AttributeIndices, d.attr(key, value))
+			}
+		}
+		op.Sample = append(op.Sample, sample)
+	}
+
+	return &collectorpb.ExportProfilesServiceRequest{
+		ResourceProfiles: []*profilespb.ResourceProfiles{{
+			Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+				keyValue("service.name", "checkout"),
+				keyValue("host.name", "node-1"),
+				keyValue("k8s.namespace.name", "prod"),
+			}},
+			ScopeProfiles: []*profilespb.ScopeProfiles{{
+				Scope:    &commonpb.InstrumentationScope{Name: "gen"},
+				Profiles: []*profilespb.Profile{op},
+			}},
+		}},
+		Dictionary: d.ProfilesDictionary,
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/dgraph-io/badger/v3"
+	"github.com/vmihailenco/msgpack/v5"
 	"github.com/xyctruth/profiler/pkg/storage"
 )
 
@@ -18,6 +19,10 @@ var (
 	PrefixTarget      = []byte{0x84}
 	PrefixLabel       = []byte{0x85}
 	PrefixIndex       = []byte{0x86}
+
+	PrefixFunctionSummary = []byte{0x87}
+	PrefixTopFunctions    = []byte{0x88}
+	PrefixGoroutineDump   = []byte{0x89}
 )
 
 // TargetLabel 内置label
@@ -35,6 +40,30 @@ func buildProfileKey(id string) []byte {
 	return buf.Bytes()
 }
 
+func buildFunctionSummaryKey(id string) []byte {
+	var buf bytes.Buffer
+	buf.Grow(len(PrefixFunctionSummary) + len(id))
+	buf.Write(PrefixFunctionSummary)
+	buf.WriteString(id)
+	return buf.Bytes()
+}
+
+func buildTopFunctionsKey(id string) []byte {
+	var buf bytes.Buffer
+	buf.Grow(len(PrefixTopFunctions) + len(id))
+	buf.Write(PrefixTopFunctions)
+	buf.WriteString(id)
+	return buf.Bytes()
+}
+
+func buildGoroutineDumpKey(id string) []byte {
+	var buf bytes.Buffer
+	buf.Grow(len(PrefixGoroutineDump) + len(id))
+	buf.Write(PrefixGoroutineDump)
+	buf.WriteString(id)
+	return buf.Bytes()
+}
+
 func buildProfileMetaKey(id string) []byte {
 	var buf bytes.Buffer
 	buf.Grow(len(PrefixProfileMeta) + len(id))
@@ -100,6 +129,34 @@ func newProfileEntry(id string, val []byte, ttl time.Duration) *badger.Entry {
 	return entry
 }
 
+func newFunctionSummaryEntry(id string, val []byte, ttl time.Duration) *badger.Entry {
+	entry := badger.NewEntry(buildFunctionSummaryKey(id), val)
+	if ttl > 0 {
+		entry = entry.WithTTL(ttl)
+	}
+	return entry
+}
+
+func newGoroutineDumpEntry(id string, val []byte, ttl time.Duration) *badger.Entry {
+	entry := badger.NewEntry(buildGoroutineDumpKey(id), val)
+	if ttl > 0 {
+		entry = entry.WithTTL(ttl)
+	}
+	return entry
+}
+
+func newTopFunctionsEntry(id string, tops []*storage.TopFunctions, ttl time.Duration) (*badger.Entry, error) {
+	topsBytes, err := msgpack.Marshal(tops)
+	if err != nil {
+		return nil, err
+	}
+	entry := badger.NewEntry(buildTopFunctionsKey(id), topsBytes)
+	if ttl > 0 {
+		entry = entry.WithTTL(ttl)
+	}
+	return entry, nil
+}
+
 func newProfileMetaEntry(id string, meta *storage.ProfileMeta, ttl time.Duration) (*badger.Entry, error) {
 	metaBytes, err := meta.Encode()
 	if err != nil {
@@ -13,6 +13,8 @@ import (
 
 	"github.com/dgraph-io/badger/v3"
 	log "github.com/sirupsen/logrus"
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/xyctruth/profiler/pkg/metrics"
 	"github.com/xyctruth/profiler/pkg/storage"
 )
 
@@ -65,9 +67,18 @@ func (s *store) GC() {
 func (s *store) gc() {
 	log.Info("store gc start")
 	if err := s.db.RunValueLogGC(0.7); err != nil {
+		if errors.Is(err, badger.ErrNoRewrite) {
+			metrics.StoreGCRunsTotal.WithLabelValues("skipped").Inc()
+		} else {
+			metrics.StoreGCRunsTotal.WithLabelValues("error").Inc()
+		}
+		lsm, vlog := s.db.Size()
+		metrics.StoreSizeBytes.WithLabelValues("lsm").Set(float64(lsm))
+		metrics.StoreSizeBytes.WithLabelValues("vlog").Set(float64(vlog))
 		log.WithError(err).Info("stop store gc")
 		return
 	}
+	metrics.StoreGCRunsTotal.WithLabelValues("rewritten").Inc()
 	s.gc()
 }
 
@@ -124,10 +135,148 @@ func (s *store) SaveProfile(name string, profileData []byte, ttl time.Duration)
 	err = s.db.Update(func(txn *badger.Txn) error {
 		return txn.SetEntry(newProfileEntry(idStr, compressData.Bytes(), ttl))
 	})
+	if err == nil {
+		metrics.StoreProfilesTotal.Inc()
+		metrics.StoreProfileBytesTotal.Add(float64(compressData.Len()))
+	}
 
 	return idStr, err
 }
 
+// SaveFunctionSummary The summary is gzip compressed, the function names of the profiles are repetitive
+func (s *store) SaveFunctionSummary(profileID string, summary *storage.FunctionSummary, ttl time.Duration) error {
+	b, err := summary.Encode()
+	if err != nil {
+		return err
+	}
+	var compressData bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressData)
+	if _, err = gzipWriter.Write(b); err != nil {
+		return err
+	}
+	if err = gzipWriter.Close(); err != nil {
+		return err
+	}
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(newFunctionSummaryEntry(profileID, compressData.Bytes(), ttl))
+	})
+}
+
+func (s *store) GetFunctionSummary(profileID string) (*storage.FunctionSummary, error) {
+	var data []byte
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(buildFunctionSummaryKey(profileID))
+		if err != nil {
+			return err
+		}
+		data, err = item.ValueCopy(nil)
+		return err
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, storage.ErrFunctionSummaryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	gzipReader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer gzipReader.Close()
+	b, err := ioutil.ReadAll(gzipReader)
+	if err != nil {
+		return nil, err
+	}
+	summary := &storage.FunctionSummary{}
+	if err = summary.Decode(b); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+func (s *store) SaveTopFunctions(profileID string, tops []*storage.TopFunctions, ttl time.Duration) error {
+	entry, err := newTopFunctionsEntry(profileID, tops, ttl)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(entry)
+	})
+}
+
+func (s *store) GetTopFunctions(profileID string) ([]*storage.TopFunctions, error) {
+	tops := make([]*storage.TopFunctions, 0)
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(buildTopFunctionsKey(profileID))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			return msgpack.Unmarshal(val, &tops)
+		})
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, storage.ErrTopFunctionsNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tops, nil
+}
+
+// SaveGoroutineDump The dump is gzip compressed, most goroutines have the same frames
+func (s *store) SaveGoroutineDump(profileID string, dump *storage.GoroutineDump, ttl time.Duration) error {
+	b, err := dump.Encode()
+	if err != nil {
+		return err
+	}
+	var compressData bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressData)
+	if _, err = gzipWriter.Write(b); err != nil {
+		return err
+	}
+	if err = gzipWriter.Close(); err != nil {
+		return err
+	}
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(newGoroutineDumpEntry(profileID, compressData.Bytes(), ttl))
+	})
+}
+
+func (s *store) GetGoroutineDump(profileID string) (*storage.GoroutineDump, error) {
+	var data []byte
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(buildGoroutineDumpKey(profileID))
+		if err != nil {
+			return err
+		}
+		data, err = item.ValueCopy(nil)
+		return err
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, storage.ErrGoroutineDumpNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	gzipReader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer gzipReader.Close()
+	b, err := ioutil.ReadAll(gzipReader)
+	if err != nil {
+		return nil, err
+	}
+	dump := &storage.GoroutineDump{}
+	if err = dump.Decode(b); err != nil {
+		return nil, err
+	}
+	return dump, nil
+}
+
 func (s *store) SaveProfileMeta(metas []*storage.ProfileMeta, ttl time.Duration) error {
 	err := s.db.Update(func(txn *badger.Txn) error {
 
@@ -177,6 +326,9 @@ func (s *store) SaveProfileMeta(metas []*storage.ProfileMeta, ttl time.Duration)
 		}
 		return nil
 	})
+	if err == nil {
+		metrics.StoreProfileMetasTotal.Add(float64(len(metas)))
+	}
 	return err
 }
 
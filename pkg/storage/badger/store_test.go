@@ -152,6 +152,82 @@ func TestProfile(t *testing.T) {
 	require.NotEqual(t, nil, err)
 }
 
+func TestFunctionSummary(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	defer os.RemoveAll(dir)
+	require.Equal(t, nil, err)
+	s := NewStore(DefaultOptions(dir))
+	defer s.Release()
+
+	_, err = s.GetFunctionSummary("1")
+	require.ErrorIs(t, err, storage.ErrFunctionSummaryNotFound)
+
+	summary := &storage.FunctionSummary{
+		SampleTypes: []string{"heap_alloc_space", "heap_inuse_space"},
+		Functions:   []string{"foo", "main"},
+		Flat:        [][]int64{{1, 2}, {3, 4}},
+		Cum:         [][]int64{{1, 3}, {3, 7}},
+	}
+	require.NoError(t, s.SaveFunctionSummary("1", summary, 3*time.Second))
+	got, err := s.GetFunctionSummary("1")
+	require.NoError(t, err)
+	require.Equal(t, summary, got)
+	require.Equal(t, 1, got.SampleTypeIndex("heap_inuse_space"))
+	require.Equal(t, -1, got.SampleTypeIndex("heap"))
+
+	// Waiting for the overdue
+	time.Sleep(3 * time.Second)
+	_, err = s.GetFunctionSummary("1")
+	require.ErrorIs(t, err, storage.ErrFunctionSummaryNotFound)
+}
+
+func TestTopFunctions(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	defer os.RemoveAll(dir)
+	require.Equal(t, nil, err)
+	s := NewStore(DefaultOptions(dir))
+	defer s.Release()
+
+	_, err = s.GetTopFunctions("1")
+	require.ErrorIs(t, err, storage.ErrTopFunctionsNotFound)
+
+	tops := []*storage.TopFunctions{{
+		SampleType: "profile_cpu",
+		Unit:       "nanoseconds",
+		Total:      100,
+		Flat:       []*storage.TopFunction{{Name: "foo", Value: 60, Percent: 60}},
+		Cum:        []*storage.TopFunction{{Name: "main", Value: 100, Percent: 100}, {Name: "foo", Value: 60, Percent: 60}},
+	}}
+	require.NoError(t, s.SaveTopFunctions("1", tops, time.Hour))
+	got, err := s.GetTopFunctions("1")
+	require.NoError(t, err)
+	require.Equal(t, tops, got)
+}
+
+func TestGoroutineDump(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	defer os.RemoveAll(dir)
+	require.Equal(t, nil, err)
+	s := NewStore(DefaultOptions(dir))
+	defer s.Release()
+
+	_, err = s.GetGoroutineDump("1")
+	require.ErrorIs(t, err, storage.ErrGoroutineDumpNotFound)
+
+	dump := &storage.GoroutineDump{Goroutines: []*storage.Goroutine{{
+		ID:          18,
+		State:       "chan receive",
+		WaitMinutes: 5,
+		Stack:       []*storage.StackFrame{{Function: "main.worker", File: "/app/main.go", Line: 20}},
+		CreatedBy:   &storage.StackFrame{Function: "main.main", File: "/app/main.go", Line: 10},
+		CreatorID:   1,
+	}}}
+	require.NoError(t, s.SaveGoroutineDump("1", dump, time.Hour))
+	got, err := s.GetGoroutineDump("1")
+	require.NoError(t, err)
+	require.Equal(t, dump, got)
+}
+
 func TestProfileMeta(t *testing.T) {
 	dir, err := ioutil.TempDir("./", "temp-*")
 	defer os.RemoveAll(dir)
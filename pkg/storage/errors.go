@@ -3,5 +3,8 @@ package storage
 import "errors"
 
 var (
-	ErrProfileNotFound = errors.New("profile not found")
+	ErrProfileNotFound         = errors.New("profile not found")
+	ErrFunctionSummaryNotFound = errors.New("function summary not found")
+	ErrTopFunctionsNotFound    = errors.New("top functions not found")
+	ErrGoroutineDumpNotFound   = errors.New("goroutine dump not found")
 )
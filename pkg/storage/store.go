@@ -31,6 +31,24 @@ type Store interface {
 	// ListLabel  Get collection target labels list
 	ListLabel() ([]Label, error)
 
+	// SaveFunctionSummary Save the function summary of a profile, it expires with the profile
+	SaveFunctionSummary(profileID string, summary *FunctionSummary, ttl time.Duration) error
+
+	// GetFunctionSummary Get the function summary of a profile, ErrFunctionSummaryNotFound if it is not saved
+	GetFunctionSummary(profileID string) (*FunctionSummary, error)
+
+	// SaveTopFunctions Save the top functions of a profile, it expires with the profile
+	SaveTopFunctions(profileID string, tops []*TopFunctions, ttl time.Duration) error
+
+	// GetTopFunctions Get the top functions of a profile, ErrTopFunctionsNotFound if they are not saved
+	GetTopFunctions(profileID string) ([]*TopFunctions, error)
+
+	// SaveGoroutineDump Save the goroutines of a goroutine dump profile, it expires with the profile
+	SaveGoroutineDump(profileID string, dump *GoroutineDump, ttl time.Duration) error
+
+	// GetGoroutineDump Get the goroutines of a goroutine dump profile, ErrGoroutineDumpNotFound if they are not saved
+	GetGoroutineDump(profileID string) (*GoroutineDump, error)
+
 	// Release Store
 	Release()
 }
@@ -63,6 +81,87 @@ func (meta *ProfileMeta) Decode(v []byte) error {
 	return msgpack.Unmarshal(v, meta)
 }
 
+// FunctionSummary The flat and cum values of the functions of a profile, saved with the profile
+// so that the function values are queried without parsing the profiles
+type FunctionSummary struct {
+	// SampleTypes The sample types same as the profile metas, e.g. profile_cpu
+	SampleTypes []string `json:"sample_types"`
+	Functions   []string `json:"functions"`
+	// Flat and Cum The values indexed by sample type and function
+	Flat [][]int64 `json:"flat"`
+	Cum  [][]int64 `json:"cum"`
+}
+
+func (summary *FunctionSummary) Encode() ([]byte, error) {
+	return msgpack.Marshal(summary)
+}
+
+func (summary *FunctionSummary) Decode(v []byte) error {
+	return msgpack.Unmarshal(v, summary)
+}
+
+// SampleTypeIndex The index of the sample type, -1 if not found
+func (summary *FunctionSummary) SampleTypeIndex(sampleType string) int {
+	for i, st := range summary.SampleTypes {
+		if st == sampleType {
+			return i
+		}
+	}
+	return -1
+}
+
+// TopFunctions The top functions by flat and cum value of a sample type of a profile
+type TopFunctions struct {
+	SampleType string         `json:"sample_type"`
+	Unit       string         `json:"unit"`
+	Total      int64          `json:"total"`
+	Flat       []*TopFunction `json:"flat"`
+	Cum        []*TopFunction `json:"cum"`
+}
+
+// TopFunction Percent is the value percentage of the total of the sample type
+type TopFunction struct {
+	Name    string  `json:"name"`
+	Value   int64   `json:"value"`
+	Percent float64 `json:"percent"`
+}
+
+// GoroutineDump The goroutines parsed from a goroutine dump of /debug/pprof/goroutine?debug=2, saved with the
+// profile of the goroutines grouped by state
+type GoroutineDump struct {
+	Goroutines []*Goroutine `json:"goroutines"`
+}
+
+func (dump *GoroutineDump) Encode() ([]byte, error) {
+	return msgpack.Marshal(dump)
+}
+
+func (dump *GoroutineDump) Decode(v []byte) error {
+	return msgpack.Unmarshal(v, dump)
+}
+
+// Goroutine A goroutine of the goroutine dump
+type Goroutine struct {
+	ID int64 `json:"id"`
+	// State The status or wait reason, e.g. running, chan receive, select, IO wait
+	State string `json:"state"`
+	// WaitMinutes The minutes blocked, the runtime reports it for the goroutines blocked longer than a minute
+	WaitMinutes    int64 `json:"wait_minutes"`
+	LockedToThread bool  `json:"locked_to_thread"`
+	// Stack The frames from leaf to root like the dump
+	Stack []*StackFrame `json:"stack"`
+	// CreatedBy The go statement of the goroutine, nil for the main goroutine
+	CreatedBy *StackFrame `json:"created_by,omitempty"`
+	// CreatorID The goroutine running the go statement, reported since go 1.21
+	CreatorID int64 `json:"creator_id,omitempty"`
+}
+
+type StackFrame struct {
+	Function string `json:"function"`
+	File     string `json:"file"`
+	Line     int64  `json:"line"`
+}
+
 type Label struct {
 	Key   string `json:"key"`
 	Value string `json:"value"`
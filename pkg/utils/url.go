@@ -11,6 +11,6 @@ func ExtractProfileID(path string) string {
 }
 
 func RemovePrefixSampleType(rawQuery string) string {
-	reg, _ := regexp.Compile(`si=(profile|heap|allocs|black|mutex|fgprof)_`)
+	reg, _ := regexp.Compile(`si=(profile|heap|allocs|block|mutex|fgprof|goroutinedump)_`)
 	return reg.ReplaceAllString(rawQuery, "si=")
 }
@@ -57,6 +57,15 @@ func TestRemovePrefixSampleType(t *testing.T) {
 	rawQuery := RemovePrefixSampleType("si=heap_alloc_space")
 	assert.Equal(t, "si=alloc_space", rawQuery)
 
+	rawQuery = RemovePrefixSampleType("si=block_delay")
+	assert.Equal(t, "si=delay", rawQuery)
+
+	rawQuery = RemovePrefixSampleType("si=heap_delta_alloc_space")
+	assert.Equal(t, "si=delta_alloc_space", rawQuery)
+
+	rawQuery = RemovePrefixSampleType("si=goroutinedump_wait")
+	assert.Equal(t, "si=wait", rawQuery)
+
 	rawQuery = RemovePrefixSampleType("")
 	assert.Equal(t, "", rawQuery)
 }
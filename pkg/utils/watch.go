@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+// WatchDebounce The events within the interval are handled once, the files are often written in several steps
+const WatchDebounce = 100 * time.Millisecond
+
+// WatchFiles Watch the directories until ctx is done, fn is called once for the events of the paths matched
+// by match within WatchDebounce. match is called with the path of every event in the directories, so that the
+// files replaced by a symlink swap like the kubernetes config maps can be matched by their resolved paths.
+// The directories failed to watch and the watcher errors are logged
+func WatchFiles(ctx context.Context, dirs []string, match func(path string) bool, fn func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		if err = watcher.Add(dir); err != nil {
+			log.WithError(err).Error("watch dir error ", dir)
+		}
+	}
+
+	go func() {
+		defer watcher.Close()
+		debounce := time.NewTimer(WatchDebounce)
+		debounce.Stop()
+		defer debounce.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if match(event.Name) {
+					debounce.Reset(WatchDebounce)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.WithError(err).Error("file watcher error")
+			case <-debounce.C:
+				fn()
+			}
+		}
+	}()
+	return nil
+}
+
+// WatchFile Watch the file until ctx is done, fn is called once for the changes within WatchDebounce.
+// The file replaced by a symlink swap like the kubernetes config maps is matched by the resolved path
+func WatchFile(ctx context.Context, path string, fn func()) error {
+	file := filepath.Clean(path)
+	realFile, _ := filepath.EvalSymlinks(file)
+	match := func(path string) bool {
+		if current, _ := filepath.EvalSymlinks(file); current != "" && current != realFile {
+			realFile = current
+			return true
+		}
+		return filepath.Clean(path) == file
+	}
+	return WatchFiles(ctx, []string{filepath.Dir(file)}, match, fn)
+}
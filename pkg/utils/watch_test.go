@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "config.yaml")
+	var calls int32
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err = WatchFiles(ctx, []string{dir}, func(path string) bool {
+		return path == file
+	}, func() {
+		atomic.AddInt32(&calls, 1)
+	})
+	require.NoError(t, err)
+
+	// the truncate and the write are handled once
+	require.NoError(t, ioutil.WriteFile(file, []byte(""), 0600))
+	require.NoError(t, ioutil.WriteFile(file, []byte("a: 1"), 0600))
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&calls) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// not matched
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "other.yaml"), []byte("a: 1"), 0600))
+	time.Sleep(3 * WatchDebounce)
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	cancel()
+	time.Sleep(WatchDebounce)
+	require.NoError(t, ioutil.WriteFile(file, []byte("a: 2"), 0600))
+	time.Sleep(3 * WatchDebounce)
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestWatchFileSymlink(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// the layout of the kubernetes config maps, config.yaml -> ..data/config.yaml
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "v1"), 0700))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "v2"), 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "v1", "config.yaml"), []byte("a: 1"), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "v2", "config.yaml"), []byte("a: 2"), 0600))
+	require.NoError(t, os.Symlink("v1", filepath.Join(dir, "..data")))
+	require.NoError(t, os.Symlink(filepath.Join("..data", "config.yaml"), filepath.Join(dir, "config.yaml")))
+
+	var calls int32
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err = WatchFile(ctx, filepath.Join(dir, "config.yaml"), func() {
+		atomic.AddInt32(&calls, 1)
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, os.Symlink("v2", filepath.Join(dir, "..data_tmp")))
+	require.NoError(t, os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")))
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&calls) == 1
+	}, time.Second, 10*time.Millisecond)
+}
@@ -2,14 +2,17 @@ package main
 
 import (
 	"flag"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
 	log "github.com/sirupsen/logrus"
+	"github.com/xyctruth/profiler/pkg/alert"
 	"github.com/xyctruth/profiler/pkg/apiserver"
 	"github.com/xyctruth/profiler/pkg/collector"
+	"github.com/xyctruth/profiler/pkg/exporter"
 	"github.com/xyctruth/profiler/pkg/storage"
 	"github.com/xyctruth/profiler/pkg/storage/badger"
 	"github.com/xyctruth/profiler/pkg/utils"
@@ -21,6 +24,19 @@ var (
 	dataPath       string
 	dataGCInternal time.Duration
 	uiGCInternal   time.Duration
+
+	profileMetricsStaleness time.Duration
+	remoteWriteURL          string
+	remoteWriteTimeout      time.Duration
+
+	ingestExpiration time.Duration
+	ingestMaxSize    int64
+	ingestFunctions  bool
+
+	mergeExpiration  time.Duration
+	mergeMaxProfiles int
+
+	pgoMaxSize int64
 )
 
 func main() {
@@ -30,6 +46,15 @@ func main() {
 	flag.StringVar(&dataPath, "data-path", "./data", "Collector Data file path")
 	flag.DurationVar(&dataGCInternal, "data-gc-internal", 5*time.Minute, "Collector Data gc internal")
 	flag.DurationVar(&uiGCInternal, "ui-gc-internal", 2*time.Minute, "Trace and pprof ui gc internal, must be greater than or equal to 1m")
+	flag.DurationVar(&profileMetricsStaleness, "profile-metrics-staleness", time.Hour, "Profile meta values not updated within staleness are removed from /metrics/profiles")
+	flag.StringVar(&remoteWriteURL, "remote-write-url", "", "Prometheus remote write url of the profile meta values, disabled when empty")
+	flag.DurationVar(&remoteWriteTimeout, "remote-write-timeout", 10*time.Second, "Prometheus remote write request timeout")
+	flag.DurationVar(&ingestExpiration, "ingest-expiration", 0, "Expiration of the profiles pushed to /api/ingest, never expire when 0")
+	flag.Int64Var(&ingestMaxSize, "ingest-max-size", 32<<20, "Max body size in bytes of the profiles pushed to /api/ingest")
+	flag.BoolVar(&ingestFunctions, "ingest-functions", true, "Save the function summary and the top functions of the profiles pushed to the ingest apis")
+	flag.DurationVar(&mergeExpiration, "merge-expiration", time.Hour, "Expiration of the profiles merged by /api/merge")
+	flag.IntVar(&mergeMaxProfiles, "merge-max-profiles", 1000, "Max number of the profiles merged by /api/merge and /api/pgo")
+	flag.Int64Var(&pgoMaxSize, "pgo-max-size", 4<<20, "Max size in bytes of the default.pgo returned by /api/pgo")
 
 	flag.Parse()
 
@@ -44,29 +69,50 @@ func main() {
 	// Register the pprof endpoint
 	utils.RegisterPProf()
 
-	// New Store
-	store := badger.NewStore(badger.DefaultOptions(dataPath).WithGCInternal(dataGCInternal))
-	// Run api server
-	apiServer := runAPIServer(store, uiGCInternal)
+	// New Store, the saved profile meta values are exported
+	gaugeExporter := exporter.NewGaugeExporter(profileMetricsStaleness)
+	sinks := []exporter.MetaSink{gaugeExporter}
+	var remoteWriter *exporter.RemoteWriter
+	if remoteWriteURL != "" {
+		remoteWriter = exporter.NewRemoteWriter(exporter.DefaultRemoteWriteOptions(remoteWriteURL).WithTimeout(remoteWriteTimeout))
+		sinks = append(sinks, remoteWriter)
+	}
+	store := exporter.NewStore(badger.NewStore(badger.DefaultOptions(dataPath).WithGCInternal(dataGCInternal)), sinks...)
 	// Run collector
 	collectorManger := runCollector(configPath, store)
+	// Run alert evaluator
+	evaluator := runEvaluator(configPath, store)
+	// Run api server
+	apiServer := runAPIServer(store, uiGCInternal, collectorManger, gaugeExporter.Handler())
 
 	// receive signal exit
-	quit := make(chan os.Signal)
+	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	s := <-quit
 	log.Info("signal receive exit ", s)
 	collectorManger.Stop()
+	evaluator.Stop()
 	apiServer.Stop()
+	if remoteWriter != nil {
+		remoteWriter.Stop()
+	}
 	store.Release()
 }
 
 // runAPIServer Run apis ,pprof ui ,trace ui
-func runAPIServer(store storage.Store, gcInternal time.Duration) *apiserver.APIServer {
+func runAPIServer(store storage.Store, gcInternal time.Duration, collectorManger *collector.Manger, profileMetrics http.Handler) *apiserver.APIServer {
 	apiServer := apiserver.NewAPIServer(
 		apiserver.DefaultOptions(store).
 			WithAddr(":8080").
-			WithGCInternal(gcInternal))
+			WithGCInternal(gcInternal).
+			WithTargetStatus(collectorManger).
+			WithProfileMetrics(profileMetrics).
+			WithIngestExpiration(ingestExpiration).
+			WithMaxIngestSize(ingestMaxSize).
+			WithIngestFunctions(ingestFunctions).
+			WithMergeExpiration(mergeExpiration).
+			WithMaxMergeProfiles(mergeMaxProfiles).
+			WithMaxPGOSize(pgoMaxSize))
 
 	log.Infof("api server run on :8080")
 	apiServer.Run()
@@ -85,3 +131,17 @@ func runCollector(configPath string, store storage.Store) *collector.Manger {
 	}
 	return m
 }
+
+// runEvaluator Run the alert evaluator of the alert rules
+func runEvaluator(configPath string, store storage.Store) *alert.Evaluator {
+	e := alert.NewEvaluator(store)
+	err := alert.LoadConfig(configPath, func(config alert.Config) {
+		log.Info("config change, reload alert rules!!!")
+		e.Load(config)
+	})
+	if err != nil {
+		panic(err)
+	}
+	e.Run()
+	return e
+}